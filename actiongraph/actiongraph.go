@@ -0,0 +1,154 @@
+// Package actiongraph reads the actions of a Go build, as written by the go
+// command's -debug-actiongraph flag, and analyses where the build spent its
+// time.
+//
+// Graphs can also be read from the actions of other build tools: the output of
+// bazel aquery --output=jsonproto, a ninja .ninja_log file, or the commands
+// printed by go build -x.
+package actiongraph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Action is a step of the build, such as compiling or linking a package.
+type Action struct {
+	ID        int
+	Mode      string
+	Package   string
+	Deps      []int // IDs of the actions this one depends on.
+	Objdir    string
+	Target    string
+	Priority  int
+	Built     string
+	BuildID   string
+	TimeReady time.Time
+	TimeStart time.Time
+	TimeDone  time.Time
+	Cmd       any // The commands run, or nil if the result was cached.
+	ActionID  string
+	CmdReal   int
+	CmdUser   int64
+	CmdSys    int
+	NeedBuild bool
+
+	// Fields derived by ComputeDerived.
+	Duration     time.Duration
+	Percent      float64       // Duration as a percentage of the total of all actions.
+	StartOffset  time.Duration // Time from the first action starting to TimeStart.
+	DoneOffset   time.Duration // Time from the first action starting to TimeDone.
+	Wait         time.Duration // Time from TimeReady to TimeStart, waiting for a free worker.
+	Cached       bool          // Whether the result was taken from the build cache, in which case the go command records no Cmd.
+	Untimed      bool          // Whether TimeStart or TimeDone is missing, or TimeDone comes first, in which case Duration is zero.
+	CriticalPath bool          // Whether the action is on the critical path: see Graph.CriticalPath.
+	Slack        time.Duration // How much longer the action could have taken without lengthening the critical path.
+}
+
+// Graph is the set of actions of a build, indexed by their ID so that Deps can
+// be followed directly.
+type Graph struct {
+	Actions []Action
+	Total   time.Duration // Duration of all of the actions.
+}
+
+// Load reads the actions written by -debug-actiongraph, or by the other build
+// tools whose output it recognises: an object rather than an array is taken
+// as the output of bazel aquery, and anything else as a ninja log or the
+// commands printed by go build -x.
+func Load(r io.Reader) (*Graph, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return nil, fmt.Errorf("decoding input: %w", err)
+	}
+
+	var actions []Action
+	switch first {
+	case '{':
+		actions, err = decodeAquery(br)
+		if err != nil {
+			return nil, err
+		}
+	case '[':
+		if err := json.NewDecoder(br).Decode(&actions); err != nil {
+			return nil, fmt.Errorf("decoding input: %w", err)
+		}
+	default:
+		if header, _ := br.Peek(len("# ninja log")); string(header) == "# ninja log" {
+			actions, err = decodeNinjaLog(br)
+		} else {
+			actions, err = decodeBuildX(br)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return New(actions)
+}
+
+// peekNonSpace returns the first byte of r which isn't whitespace, without
+// consuming it.
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+// New returns the graph of actions, ordered by ID, with their derived fields
+// computed by ComputeDerived. The IDs must run from zero without gaps.
+func New(actions []Action) (*Graph, error) {
+	g := Graph{Actions: actions}
+
+	// The go command writes actions in ID order, but we don't rely on it.
+	sort.SliceStable(g.Actions, func(i, j int) bool {
+		return g.Actions[i].ID < g.Actions[j].ID
+	})
+	for i := range g.Actions {
+		if g.Actions[i].ID != i {
+			return nil, fmt.Errorf("action IDs are not contiguous: expected ID %d, found %d", i, g.Actions[i].ID)
+		}
+	}
+
+	ComputeDerived(g.Actions)
+	for i := range g.Actions {
+		g.Total += g.Actions[i].Duration
+	}
+	return &g, nil
+}
+
+// Percent returns d as a percentage of the total duration of the actions, or
+// zero if they took no time, as when the build tool recorded no timings.
+func (g *Graph) Percent(d time.Duration) float64 {
+	return percent(d, g.Total)
+}
+
+// Start returns the time at which the first action started.
+func (g *Graph) Start() time.Time {
+	return start(g.Actions)
+}
+
+// Wall returns the wall-clock time of the build, from the first action
+// starting to the last finishing.
+func (g *Graph) Wall() time.Duration {
+	var done time.Time
+	for i := range g.Actions {
+		if g.Actions[i].TimeDone.After(done) {
+			done = g.Actions[i].TimeDone
+		}
+	}
+	return done.Sub(g.Start())
+}
@@ -0,0 +1,97 @@
+package actiongraph
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Top returns the n slowest actions, or all of them if n isn't positive.
+// Actions taking the same time are kept in ID order.
+func (g *Graph) Top(n int) []*Action {
+	top := make([]*Action, len(g.Actions))
+	for i := range g.Actions {
+		top[i] = &g.Actions[i]
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].Duration > top[j].Duration
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// TreeNode is a directory of the package paths built, totalling the actions
+// building the packages within it.
+type TreeNode struct {
+	Path     string
+	Duration time.Duration // Of the actions in this subtree.
+	Count    int           // Number of actions in this subtree.
+	Action   *Action       // Building the package at Path, if any.
+	Children []*TreeNode   // In order of Path.
+}
+
+// Tree returns the build actions arranged by their package paths, beneath a
+// root node whose path is empty.
+func (g *Graph) Tree() *TreeNode {
+	root := &TreeNode{}
+	index := map[string]*TreeNode{"": root}
+	for i := range g.Actions {
+		act := &g.Actions[i]
+		if act.Mode != "build" {
+			continue
+		}
+		root.Duration += act.Duration
+		root.Count++
+
+		node := root
+		for p := 0; p < len(act.Package); {
+			if n := strings.Index(act.Package[p+1:], "/"); n < 0 {
+				p = len(act.Package)
+			} else {
+				p += n + 1
+			}
+			path := act.Package[:p]
+			child, ok := index[path]
+			if !ok {
+				child = &TreeNode{Path: path}
+				index[path] = child
+				node.Children = append(node.Children, child)
+			}
+			node = child
+			node.Duration += act.Duration
+			node.Count++
+		}
+		node.Action = act
+	}
+	for _, n := range index {
+		sort.Slice(n.Children, func(i, j int) bool {
+			return n.Children[i].Path < n.Children[j].Path
+		})
+	}
+	return root
+}
+
+// CriticalPath returns the chain of dependent actions taking the longest in
+// total, which no amount of parallelism could have shortened the build below.
+// The actions are given in the order they ran, from the first dependency to
+// the last dependent.
+func (g *Graph) CriticalPath() []*Action {
+	c := chains(g.Actions)
+	end := -1
+	for i := range g.Actions {
+		if end < 0 || c.before[i] > c.before[end] {
+			end = i
+		}
+	}
+
+	var path []*Action
+	for i := end; i >= 0; i = c.prev[i] {
+		path = append(path, &g.Actions[i])
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
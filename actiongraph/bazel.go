@@ -0,0 +1,192 @@
+package actiongraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// aquery is the output of `bazel aquery --output=jsonproto`, which describes
+// the actions of a Bazel build and the files passing between them.
+type aquery struct {
+	Artifacts []struct {
+		ID             int `json:"id"`
+		PathFragmentID int `json:"pathFragmentId"`
+	} `json:"artifacts"`
+	Actions []struct {
+		TargetID        int      `json:"targetId"`
+		ActionKey       string   `json:"actionKey"`
+		Mnemonic        string   `json:"mnemonic"`
+		Arguments       []string `json:"arguments"`
+		InputDepSetIDs  []int    `json:"inputDepSetIds"`
+		OutputIDs       []int    `json:"outputIds"`
+		PrimaryOutputID int      `json:"primaryOutputId"`
+	} `json:"actions"`
+	Targets []struct {
+		ID    int    `json:"id"`
+		Label string `json:"label"`
+	} `json:"targets"`
+	DepSetOfFiles []struct {
+		ID                  int   `json:"id"`
+		DirectArtifactIDs   []int `json:"directArtifactIds"`
+		TransitiveDepSetIDs []int `json:"transitiveDepSetIds"`
+	} `json:"depSetOfFiles"`
+	PathFragments []struct {
+		ID       int    `json:"id"`
+		Label    string `json:"label"`
+		ParentID int    `json:"parentId"`
+	} `json:"pathFragments"`
+}
+
+// aqueryModes are the modes of the actions for the mnemonics of rules_go,
+// matching those of the go command. Other mnemonics are used as the mode.
+var aqueryModes = map[string]string{
+	"GoCompilePkg":         "build",
+	"GoCompilePkgExternal": "build",
+	"GoLink":               "link",
+	"GoStdlib":             "build",
+	"GoCover":              "cover",
+	"GoTestGenTest":        "build",
+}
+
+// decodeAquery reads the actions of a Bazel build from aquery's jsonproto
+// output. Each action depends on the actions producing its inputs. Bazel
+// records no timings in the action graph, so the actions take no time.
+func decodeAquery(r io.Reader) ([]Action, error) {
+	var q aquery
+	if err := json.NewDecoder(r).Decode(&q); err != nil {
+		return nil, fmt.Errorf("decoding aquery: %w", err)
+	}
+
+	labels := make(map[int]string, len(q.Targets))
+	for _, t := range q.Targets {
+		labels[t.ID] = t.Label
+	}
+	fragments := make(map[int]int, len(q.PathFragments))
+	for i, f := range q.PathFragments {
+		fragments[f.ID] = i
+	}
+	artifactPath := func(id int) string {
+		var parts []string
+		for i, ok := fragments[id]; ok; i, ok = fragments[q.PathFragments[i].ParentID] {
+			parts = append(parts, q.PathFragments[i].Label)
+		}
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		return strings.Join(parts, "/")
+	}
+	artifacts := make(map[int]int, len(q.Artifacts))
+	for _, a := range q.Artifacts {
+		artifacts[a.ID] = a.PathFragmentID
+	}
+
+	// Find the action producing each artifact.
+	producers := make(map[int]int)
+	for i, a := range q.Actions {
+		for _, out := range a.OutputIDs {
+			producers[out] = i
+		}
+	}
+
+	// Find the actions producing the files of each depset, including those of
+	// the depsets it contains.
+	depsets := make(map[int]int, len(q.DepSetOfFiles))
+	for i, d := range q.DepSetOfFiles {
+		depsets[d.ID] = i
+	}
+	producing := make(map[int][]int)
+	var expand func(id int) []int
+	expand = func(id int) []int {
+		if deps, ok := producing[id]; ok {
+			return deps
+		}
+		producing[id] = nil // Guard against cycles.
+		i, ok := depsets[id]
+		if !ok {
+			return nil
+		}
+		seen := make(map[int]bool)
+		var deps []int
+		add := func(dep int) {
+			if !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+			}
+		}
+		for _, art := range q.DepSetOfFiles[i].DirectArtifactIDs {
+			if p, ok := producers[art]; ok {
+				add(p)
+			}
+		}
+		for _, sub := range q.DepSetOfFiles[i].TransitiveDepSetIDs {
+			for _, dep := range expand(sub) {
+				add(dep)
+			}
+		}
+		producing[id] = deps
+		return deps
+	}
+
+	actions := make([]Action, len(q.Actions))
+	for i, a := range q.Actions {
+		act := &actions[i]
+		act.ID = i
+		act.ActionID = a.ActionKey
+		act.Mode = a.Mnemonic
+		if mode, ok := aqueryModes[a.Mnemonic]; ok {
+			act.Mode = mode
+		}
+		act.Package = aqueryPackage(labels[a.TargetID], a.Arguments)
+		if id, ok := artifacts[a.PrimaryOutputID]; ok {
+			act.Target = artifactPath(id)
+		}
+		if len(a.Arguments) > 0 {
+			act.Cmd = []any{joinArgs(a.Arguments)}
+		}
+
+		seen := make(map[int]bool)
+		for _, set := range a.InputDepSetIDs {
+			for _, dep := range expand(set) {
+				if dep != i && !seen[dep] {
+					seen[dep] = true
+					act.Deps = append(act.Deps, dep)
+				}
+			}
+		}
+	}
+	return actions, nil
+}
+
+// aqueryPackage returns the package of an action: the import path given to
+// the rules_go builder if any, or else the package of its target's label,
+// such as example/foo for //example/foo:go_default_library, or the external
+// repository for @com_github_pkg_errors//:errors.
+func aqueryPackage(label string, args []string) string {
+	for i, arg := range args {
+		if arg == "-importpath" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	repo, pkg, _ := strings.Cut(label, "//")
+	pkg, _, _ = strings.Cut(pkg, ":")
+	if repo = strings.TrimLeft(repo, "@"); repo != "" {
+		pkg = path.Join(repo, pkg)
+	}
+	return pkg
+}
+
+// joinArgs joins the arguments of a command into a line which splitCmd can
+// split again.
+func joinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t") {
+			arg = `"` + arg + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,140 @@
+package actiongraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	buildXDir         = regexp.MustCompile(`\$WORK/(b\d+)/`)
+	buildXHeredoc     = regexp.MustCompile(`^cat >(\S+) << '(\w+)'`)
+	buildXPackage     = regexp.MustCompile(`/(?:compile|asm|cgo)(?:\.exe)? .*?\s-(?:p|importpath) (\S+)`)
+	buildXModinfo     = regexp.MustCompile(`path\\t([^\\"]+)\\nmod\\t`)
+	buildXPackagefile = regexp.MustCompile(`^packagefile \S+=\$WORK/(b\d+)/_pkg_\.a$`)
+)
+
+// decodeBuildX reads the actions of a build from the commands printed by
+// `go build -x`, for when -debug-actiongraph isn't available. The go command
+// gives each package a directory $WORK/bNNN, whose commands make up the
+// action building the package, and those writing the executable the action
+// linking it. The importcfg files written for the compiler give the packages
+// each depends on.
+//
+// The commands aren't timed, so the actions take no time, but they're numbered
+// in the reverse of the order their first command ran, putting those depended
+// upon after their dependents as the go command does. Packages taken from the
+// build cache run no commands, and so are missing altogether.
+func decodeBuildX(r io.Reader) ([]Action, error) {
+	type key struct{ dir, mode string }
+	var actions []Action
+	index := make(map[key]int)
+	deps := make(map[int][]string)
+	get := func(k key) *Action {
+		i, ok := index[k]
+		if !ok {
+			i = len(actions)
+			index[k] = i
+			actions = append(actions, Action{ID: i, Mode: k.mode, Cmd: []any{}})
+		}
+		return &actions[i]
+	}
+	// The link action goes by its files in the package's directory.
+	keyOf := func(line string, dir string) key {
+		if strings.Contains(line, "importcfg.link") || strings.Contains(line, "$WORK/"+dir+"/exe/") {
+			return key{dir, "link"}
+		}
+		return key{dir, "build"}
+	}
+
+	var work string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if v, ok := strings.CutPrefix(line, "WORK="); ok {
+			work = v
+			continue
+		}
+		if work != "" {
+			line = strings.ReplaceAll(line, work, "$WORK")
+		}
+		m := buildXDir.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		act := get(keyOf(line, m[1]))
+		act.Objdir = work + "/" + m[1] + "/"
+		act.Cmd = append(act.Cmd.([]any), line)
+		if pm := buildXPackage.FindStringSubmatch(line); pm != nil && act.Package == "" {
+			act.Package = pm[1]
+		}
+		if mv, ok := strings.CutPrefix(line, "mv $WORK/"+m[1]+"/exe/"); ok && act.Mode == "link" {
+			_, act.Target, _ = strings.Cut(mv, " ")
+		}
+
+		// Read the package's imports, or the main package's path, from the
+		// files written for the compiler and linker.
+		hm := buildXHeredoc.FindStringSubmatch(line)
+		if hm == nil {
+			continue
+		}
+		for sc.Scan() {
+			text := sc.Text()
+			if text == hm[2] {
+				break
+			}
+			if work != "" {
+				text = strings.ReplaceAll(text, work, "$WORK")
+			}
+			if pm := buildXPackagefile.FindStringSubmatch(text); pm != nil && act.Mode == "build" {
+				deps[act.ID] = append(deps[act.ID], pm[1])
+			}
+			if mm := buildXModinfo.FindStringSubmatch(text); mm != nil && act.Mode == "link" {
+				act.Package = mm[1]
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("decoding go build -x output: %w", err)
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("decoding go build -x output: found no commands building packages")
+	}
+
+	for k, i := range index {
+		act := &actions[i]
+		switch k.mode {
+		case "build":
+			for _, dir := range deps[i] {
+				if dep, ok := index[key{dir, "build"}]; ok {
+					act.Deps = append(act.Deps, dep)
+				}
+			}
+		case "link":
+			build, ok := index[key{k.dir, "build"}]
+			if !ok {
+				break
+			}
+			act.Deps = append(act.Deps, build)
+			// The main package is compiled as package main, so name it for
+			// its import path as the go command would.
+			if b := &actions[build]; b.Package == "main" && act.Package != "" {
+				b.Package = act.Package
+			}
+		}
+	}
+
+	n := len(actions)
+	reversed := make([]Action, n)
+	for i, act := range actions {
+		act.ID = n - 1 - i
+		for j, dep := range act.Deps {
+			act.Deps[j] = n - 1 - dep
+		}
+		reversed[act.ID] = act
+	}
+	return reversed, nil
+}
@@ -0,0 +1,141 @@
+package actiongraph
+
+import "time"
+
+// ComputeDerived sets the fields of the actions which are derived from the
+// others: their durations, and how they sit on the critical path. Deps are
+// followed by ID, and those not found among the actions are ignored.
+//
+// Actions missing either of their TimeStart or TimeDone, as the go command
+// leaves those it skips, are marked Untimed and given no Duration, so as not
+// to count the time since the zero time. When none of the actions have times,
+// as when the build tool recorded none, they aren't marked.
+func ComputeDerived(actions []Action) {
+	var timed bool
+	for i := range actions {
+		if !actions[i].TimeStart.IsZero() || !actions[i].TimeDone.IsZero() {
+			timed = true
+			break
+		}
+	}
+
+	var total time.Duration
+	for i := range actions {
+		act := &actions[i]
+		act.Duration = act.TimeDone.Sub(act.TimeStart)
+		act.Untimed = timed && (act.TimeStart.IsZero() || act.TimeDone.IsZero() || act.Duration < 0)
+		if act.Untimed {
+			act.Duration = 0
+		}
+		act.Cached = act.Cmd == nil
+		act.Wait = 0
+		if !act.TimeReady.IsZero() && act.TimeStart.After(act.TimeReady) {
+			act.Wait = act.TimeStart.Sub(act.TimeReady)
+		}
+		total += act.Duration
+	}
+
+	first := start(actions)
+	c := chains(actions)
+	var critical time.Duration
+	for i := range actions {
+		if l := c.before[i] + c.after[i]; l > critical {
+			critical = l
+		}
+	}
+	for i := range actions {
+		act := &actions[i]
+		act.Percent = percent(act.Duration, total)
+		act.StartOffset, act.DoneOffset = 0, 0
+		if !act.TimeStart.IsZero() {
+			act.StartOffset = act.TimeStart.Sub(first)
+		}
+		if !act.TimeDone.IsZero() {
+			act.DoneOffset = act.TimeDone.Sub(first)
+		}
+		act.Slack = critical - c.before[i] - c.after[i]
+		act.CriticalPath = critical > 0 && act.Slack == 0
+	}
+}
+
+// chainLengths are the longest chains of dependent actions through each
+// action, indexed like the actions.
+type chainLengths struct {
+	before []time.Duration // Of the longest chain of dependencies ending with the action, including it.
+	after  []time.Duration // Of the longest chain of dependents following the action, excluding it.
+	prev   []int           // Index of the dependency continuing the longest chain before the action, or -1.
+}
+
+func chains(actions []Action) chainLengths {
+	index := make(map[int]int, len(actions))
+	for i := range actions {
+		index[actions[i].ID] = i
+	}
+	c := chainLengths{
+		before: make([]time.Duration, len(actions)),
+		after:  make([]time.Duration, len(actions)),
+		prev:   make([]int, len(actions)),
+	}
+
+	// Visit the actions depended on before their dependents.
+	var order []int
+	seen := make([]bool, len(actions))
+	var visit func(i int)
+	visit = func(i int) {
+		if seen[i] {
+			return // Already visited, or a cycle.
+		}
+		seen[i] = true
+		for _, dep := range actions[i].Deps {
+			if j, ok := index[dep]; ok {
+				visit(j)
+			}
+		}
+		order = append(order, i)
+	}
+	for i := range actions {
+		visit(i)
+	}
+
+	for _, i := range order {
+		c.prev[i] = -1
+		var longest time.Duration
+		for _, dep := range actions[i].Deps {
+			if j, ok := index[dep]; ok && (c.prev[i] < 0 || c.before[j] > longest) {
+				longest, c.prev[i] = c.before[j], j
+			}
+		}
+		c.before[i] = longest + actions[i].Duration
+	}
+	for k := len(order) - 1; k >= 0; k-- {
+		i := order[k]
+		for _, dep := range actions[i].Deps {
+			if j, ok := index[dep]; ok {
+				if l := actions[i].Duration + c.after[i]; l > c.after[j] {
+					c.after[j] = l
+				}
+			}
+		}
+	}
+	return c
+}
+
+// start returns the time at which the first action started.
+func start(actions []Action) time.Time {
+	var start time.Time
+	for i := range actions {
+		t := actions[i].TimeStart
+		if !t.IsZero() && (start.IsZero() || t.Before(start)) {
+			start = t
+		}
+	}
+	return start
+}
+
+// percent returns d as a percentage of total, or zero if total is zero.
+func percent(d, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(d) / float64(total)
+}
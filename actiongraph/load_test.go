@@ -0,0 +1,88 @@
+package actiongraph
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// loaded is what's checked of each action read by Load.
+type loaded struct {
+	Mode     string
+	Package  string
+	Target   string
+	Deps     []int
+	Duration time.Duration
+	Cached   bool
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		file string
+		want []loaded
+	}{
+		{
+			// bazel aquery --output=jsonproto, recognised as an object.
+			file: "aquery.json",
+			want: []loaded{
+				{Mode: "build", Package: "example.com/lib", Target: "bazel-out/bin/lib.a"},
+				{Mode: "build", Package: "cmd/app", Target: "bazel-out/bin/app_lib.a", Deps: []int{0}},
+				{Mode: "link", Package: "cmd/app", Target: "bazel-out/bin/app", Deps: []int{1, 0}},
+				{Mode: "Genrule", Package: "com_github_pkg_errors", Target: "bazel-out/bin/version.txt"},
+			},
+		},
+		{
+			// A .ninja_log holding two builds, of which the last is read. Its
+			// steps writing several outputs are listed for each.
+			file: "ninja_log",
+			want: []loaded{
+				{Mode: "build", Package: "obj/foo.o", Target: "obj/foo.o", Duration: 120 * time.Millisecond},
+				{Mode: "build", Package: "obj/bar.o", Target: "obj/bar.o", Duration: 247 * time.Millisecond},
+				{Mode: "link", Package: "libfoo.a", Target: "libfoo.a", Duration: 50 * time.Millisecond},
+				{Mode: "link", Package: "app", Target: "app", Duration: 120 * time.Millisecond},
+				{Mode: "ninja", Package: "gen/version.h", Target: "gen/version.h", Duration: 5 * time.Millisecond},
+			},
+		},
+		{
+			// The commands printed by go build -x, with the packages of the
+			// standard library taken from the build cache.
+			file: "buildx.txt",
+			want: []loaded{
+				{Mode: "link", Package: "example.com/app/cmd/app", Target: "app", Deps: []int{1}},
+				{Mode: "build", Package: "example.com/app/cmd/app", Deps: []int{2}},
+				{Mode: "build", Package: "example.com/app/lib"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			g, err := Load(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]loaded, len(g.Actions))
+			var total time.Duration
+			for i, act := range g.Actions {
+				if act.ID != i {
+					t.Errorf("action %d has ID %d", i, act.ID)
+				}
+				got[i] = loaded{act.Mode, act.Package, act.Target, act.Deps, act.Duration, act.Cached}
+				total += act.Duration
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load read:\n%+v\nwant:\n%+v", got, tt.want)
+			}
+			if g.Total != total {
+				t.Errorf("Total is %s, want %s", g.Total, total)
+			}
+		})
+	}
+}
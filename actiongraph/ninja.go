@@ -0,0 +1,157 @@
+package actiongraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// decodeNinjaLog reads the actions of the last build recorded in a .ninja_log
+// file. Ninja appends each build to the log, timing its steps in milliseconds
+// from the start of the build, so the last build begins after the end times
+// go backwards. The steps are timed from the Unix epoch, having no dependencies
+// until joined with the graph by JoinNinjaGraph.
+func decodeNinjaLog(r io.Reader) ([]Action, error) {
+	sc := bufio.NewScanner(r)
+	if !sc.Scan() {
+		return nil, fmt.Errorf("decoding ninja log: %w", sc.Err())
+	}
+	var version int
+	if _, err := fmt.Sscanf(sc.Text(), "# ninja log v%d", &version); err != nil || version < 4 {
+		return nil, fmt.Errorf("decoding ninja log: unsupported header %q", sc.Text())
+	}
+
+	// A step writing several outputs has a line for each, which share its
+	// times and command hash.
+	type step struct {
+		start, end int64
+		hash       string
+	}
+	var steps []step
+	var outputs []string
+	index := make(map[step]int)
+	var lastEnd int64
+	for line := 2; sc.Scan(); line++ {
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("decoding ninja log: line %d: expected 5 fields, found %d", line, len(fields))
+		}
+		start, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("decoding ninja log: line %d: %w", line, err)
+		}
+		end, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("decoding ninja log: line %d: %w", line, err)
+		}
+		if end < lastEnd {
+			steps, outputs = steps[:0], outputs[:0]
+			index = make(map[step]int)
+		}
+		lastEnd = end
+
+		s := step{start, end, fields[4]}
+		if _, ok := index[s]; ok {
+			continue
+		}
+		index[s] = len(steps)
+		steps = append(steps, s)
+		outputs = append(outputs, fields[3])
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("decoding ninja log: %w", err)
+	}
+
+	actions := make([]Action, len(steps))
+	for i, s := range steps {
+		actions[i] = Action{
+			ID:        i,
+			Mode:      ninjaMode(outputs[i]),
+			Package:   outputs[i],
+			Target:    outputs[i],
+			ActionID:  s.hash,
+			TimeStart: time.UnixMilli(s.start),
+			TimeDone:  time.UnixMilli(s.end),
+			Cmd:       []any{}, // Ninja doesn't cache, so every step ran.
+		}
+	}
+	return actions, nil
+}
+
+// ninjaMode returns the mode of the step writing output, going by its
+// extension: objects are built, libraries and executables linked.
+func ninjaMode(output string) string {
+	switch path.Ext(output) {
+	case ".o", ".obj":
+		return "build"
+	case ".a", ".lib", ".so", ".dylib", ".dll", ".exe", "":
+		return "link"
+	default:
+		return "ninja"
+	}
+}
+
+var (
+	ninjaGraphNode = regexp.MustCompile(`^"([^"]+)" \[label="([^"]*)"(, shape=ellipse)?`)
+	ninjaGraphEdge = regexp.MustCompile(`^"([^"]+)" -> "([^"]+)"`)
+)
+
+// JoinNinjaGraph sets the Deps of the actions read from a ninja log, given the
+// graph of the build written by `ninja -t graph`. Each step depends on the
+// steps writing its inputs.
+func (g *Graph) JoinNinjaGraph(r io.Reader) error {
+	// The graph has a node for each file, and for each step with several
+	// inputs. Edges run from inputs to outputs, through the step's node if it
+	// has one.
+	files := make(map[string]string)
+	rules := make(map[string]bool)
+	inputs := make(map[string][]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if m := ninjaGraphEdge.FindStringSubmatch(line); m != nil {
+			inputs[m[2]] = append(inputs[m[2]], m[1])
+		} else if m := ninjaGraphNode.FindStringSubmatch(line); m != nil {
+			if m[3] != "" {
+				rules[m[1]] = true
+			} else {
+				files[m[1]] = m[2]
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("decoding ninja graph: %w", err)
+	}
+
+	producers := make(map[string]int, len(g.Actions))
+	for i := range g.Actions {
+		producers[g.Actions[i].Target] = i
+	}
+	for node, label := range files {
+		id, ok := producers[label]
+		if !ok {
+			continue
+		}
+		act := &g.Actions[id]
+		act.Deps = nil
+		seen := make(map[int]bool)
+		var add func(node string)
+		add = func(node string) {
+			for _, in := range inputs[node] {
+				if rules[in] {
+					add(in)
+				} else if dep, ok := producers[files[in]]; ok && dep != id && !seen[dep] {
+					seen[dep] = true
+					act.Deps = append(act.Deps, dep)
+				}
+			}
+		}
+		add(node)
+	}
+	return nil
+}
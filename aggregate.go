@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addAggregateCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "aggregate [-n limit] run1.json run2.json...",
+		Short:   "Summarise the build times of each package over several builds",
+		Long: `List the packages which took longest to build on average over several builds
+of the same code, with the spread of their durations, so that a single noisy
+build doesn't decide what to optimise. The actions of each build are totalled
+by mode and package, as diff does, and then summarised across the builds
+which had them.
+
+Packages whose durations vary too much to be trusted are listed separately,
+after the rest: those whose slowest build took --noisy-spread times their
+quickest, or which had a build more than --noisy-sigma standard deviations
+from the mean of their other builds. These usually point to contention for
+the machine rather than to slow code. Packages taking less than
+--min-duration on average are too quick to judge and never listed as noisy.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := rejectStoreFlags(cmd); err != nil {
+				return err
+			}
+			opt := newOptions(cmd)
+
+			aopt, err := aggregateFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			runs, err := loadStoreFiles(args...)
+			if err != nil {
+				return err
+			}
+			for i, fn := range args[1:] {
+				if warning := instrumentationWarning(runs[0], runs[i+1]); warning != "" {
+					fmt.Fprintf(opt.stderr, "actiongraph: warning: %s: %s\n", fn, warning)
+				}
+			}
+
+			out, err := newRowWriter(cmd, opt, aggregateFormats)
+			if err != nil {
+				return err
+			}
+			return aggregate(opt, runs, aopt, out)
+		},
+	}
+	addAggregateFlags(&cmd)
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+// addAggregateFlags adds the flags read by aggregateFlags.
+func addAggregateFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of packages to show, and of noisy packages")
+	flags.Float64("noisy-spread", 2, "list packages whose slowest build took this many times their quickest as noisy")
+	flags.Float64("noisy-sigma", 3, "list packages with a build this many standard deviations from the mean of the others as noisy")
+	flags.Duration("min-duration", 100*time.Millisecond, "never list packages quicker than this on average as noisy")
+}
+
+// aggregateFlags returns the options of aggregate given by the flags.
+func aggregateFlags(cmd *cobra.Command) (aggregateOptions, error) {
+	flags := cmd.Flags()
+	var aopt aggregateOptions
+	var err error
+	aopt.limit, err = flags.GetInt("limit")
+	if err != nil {
+		return aopt, err
+	}
+	aopt.noisySpread, err = flags.GetFloat64("noisy-spread")
+	if err != nil {
+		return aopt, err
+	}
+	aopt.noisySigma, err = flags.GetFloat64("noisy-sigma")
+	if err != nil {
+		return aopt, err
+	}
+	aopt.minDuration, err = flags.GetDuration("min-duration")
+	return aopt, err
+}
+
+var aggregateFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Mean", tpl: `{{ .Mean | colordur }}`},
+		{name: "Stddev", tpl: `±{{ .Stddev | seconds }}`},
+		{name: "Min", tpl: `{{ .Min | seconds }}`},
+		{name: "Max", tpl: `{{ .Max | seconds }}`},
+		{name: "Runs", tpl: `{{ .Count }}/{{ .Runs }}`},
+		{name: "Noisy", tpl: `{{ if .Noisy }}noisy{{ end }}`, left: true},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Mean", tpl: `{{ .Mean | colordur }}`},
+		{name: "Stddev", tpl: `±{{ .Stddev | seconds }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Mean", tpl: `{{ .Mean | colordur }}`},
+		{name: "Stddev", tpl: `±{{ .Stddev | seconds }}`},
+		{name: "CV", tpl: `{{ .CV | percent }}`},
+		{name: "Min", tpl: `{{ .Min | seconds }}`},
+		{name: "Median", tpl: `{{ .Median | seconds }}`},
+		{name: "Max", tpl: `{{ .Max | seconds }}`},
+		{name: "Spread", tpl: `{{ .Spread | printf "%.2fx" }}`},
+		{name: "Runs", tpl: `{{ .Count }}/{{ .Runs }}`},
+		{name: "Noisy", tpl: `{{ if .Noisy }}noisy{{ end }}`, left: true},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "count", tpl: `{{ .Count }}`},
+		{name: "runs", tpl: `{{ .Runs }}`},
+		{name: "mean", tpl: `{{ .Mean.Seconds | printf "%.3f" }}`},
+		{name: "stddev", tpl: `{{ .Stddev.Seconds | printf "%.3f" }}`},
+		{name: "cv", tpl: `{{ .CV | printf "%.2f" }}`},
+		{name: "min", tpl: `{{ .Min.Seconds | printf "%.3f" }}`},
+		{name: "median", tpl: `{{ .Median.Seconds | printf "%.3f" }}`},
+		{name: "max", tpl: `{{ .Max.Seconds | printf "%.3f" }}`},
+		{name: "spread", tpl: `{{ .Spread | printf "%.2f" }}`},
+		{name: "noisy", tpl: `{{ .Noisy }}`},
+	},
+}
+
+type aggregateOptions struct {
+	limit       int
+	noisySpread float64
+	noisySigma  float64
+	minDuration time.Duration
+}
+
+// pkgAggregate summarises the durations of the actions for one mode and
+// package across several builds. Count is the number of builds having them.
+type pkgAggregate struct {
+	Mode    string
+	Package string
+	Runs    int // Number of builds aggregated.
+	durationStats
+	CV     float64 // Coefficient of variation: Stddev as a percentage of Mean.
+	Spread float64 // Max as a multiple of Min.
+	Noisy  bool    // Whether the durations vary too much between builds to be trusted.
+}
+
+// aggregate lists the packages which took longest on average across the runs.
+// When written as text, the noisy packages follow the rest in a table of
+// their own; otherwise they're marked as Noisy.
+func aggregate(opt *options, runs []*store, aopt aggregateOptions, out *rowWriter) error {
+	type key struct{ mode, pkg string }
+	durations := make(map[key][]time.Duration)
+	for _, s := range runs {
+		totals := make(map[key]time.Duration)
+		for i := range s.actions {
+			act := &s.actions[i]
+			totals[key{act.Mode, act.Package}] += act.Duration
+		}
+		for k, d := range totals {
+			durations[k] = append(durations[k], d)
+		}
+	}
+
+	var steady, noisy []pkgAggregate
+	for k, ds := range durations {
+		a := pkgAggregate{Mode: k.mode, Package: k.pkg, Runs: len(runs), durationStats: summarise(ds)}
+		if a.Mean > 0 {
+			a.CV = 100 * float64(a.Stddev) / float64(a.Mean)
+		}
+		if a.Min > 0 {
+			a.Spread = float64(a.Max) / float64(a.Min)
+		}
+		a.Noisy = a.Mean >= aopt.minDuration &&
+			((a.Min > 0 && a.Spread >= aopt.noisySpread) || hasOutlier(ds, aopt.noisySigma))
+		if a.Noisy && out.text() {
+			noisy = append(noisy, a)
+		} else {
+			steady = append(steady, a)
+		}
+	}
+
+	for i, list := range [][]pkgAggregate{steady, noisy} {
+		if len(list) == 0 {
+			continue
+		}
+		if i == 1 {
+			fmt.Fprintf(opt.stdout, "\nNoisy, varying by %gx or %gσ between builds:\n", aopt.noisySpread, aopt.noisySigma)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Mean != list[j].Mean {
+				return list[i].Mean > list[j].Mean
+			}
+			if list[i].Package != list[j].Package {
+				return list[i].Package < list[j].Package
+			}
+			return list[i].Mode < list[j].Mode
+		})
+		if aopt.limit > 0 && len(list) > aopt.limit {
+			list = list[:aopt.limit]
+		}
+		for _, a := range list {
+			if err := out.row(a); err != nil {
+				return err
+			}
+		}
+		if err := out.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasOutlier reports whether any of the durations lies more than sigma
+// standard deviations from the mean of the others. At least three durations
+// are needed for the others to have a standard deviation.
+func hasOutlier(ds []time.Duration, sigma float64) bool {
+	if len(ds) < 3 {
+		return false
+	}
+	others := make([]time.Duration, 0, len(ds)-1)
+	for i, d := range ds {
+		others = append(append(others[:0], ds[:i]...), ds[i+1:]...)
+		s := summarise(others)
+		if s.Stddev > 0 && math.Abs(float64(d-s.Mean)) > sigma*float64(s.Stddev) {
+			return true
+		}
+	}
+	return false
+}
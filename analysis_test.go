@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icio/actiongraph/actiongraph"
+)
+
+// The analyses of the actiongraph package are checked against the commands
+// giving the same results.
+
+var demoFile = filepath.Join("demo", "k9s.json")
+
+// loadDemo loads demo/k9s.json with the actiongraph package.
+func loadDemo(t *testing.T) *actiongraph.Graph {
+	t.Helper()
+	f, err := os.Open(demoFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	g, err := actiongraph.Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// runOutput runs the command on the actions of file, returning what it wrote
+// to --output in the format given by the output's extension.
+func runOutput(t *testing.T, file, ext string, args ...string) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out"+ext)
+	args = append(args, "-f", file, "--output", path)
+	if err := run(args...); err != nil {
+		t.Fatalf("actiongraph %s: %v", strings.Join(args, " "), err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestGraphTop(t *testing.T) {
+	g := loadDemo(t)
+	for _, n := range []int{1, 10, 0} {
+		var want []int
+		sc := bufio.NewScanner(bytes.NewReader(runOutput(t, demoFile, ".json", "top", "-n", strconv.Itoa(n))))
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var row struct{ ID int }
+			if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, row.ID)
+		}
+		if err := sc.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		top := g.Top(n)
+		if len(top) != len(want) {
+			t.Errorf("Top(%d) returned %d actions, top -n %d listed %d", n, len(top), n, len(want))
+			continue
+		}
+		for i, act := range top {
+			if act.ID != want[i] {
+				t.Errorf("Top(%d)[%d] is action %d, top -n %d listed %d", n, i, act.ID, n, want[i])
+			}
+		}
+	}
+}
+
+func TestGraphTree(t *testing.T) {
+	g := loadDemo(t)
+	type node struct {
+		ID                 int
+		Package            string
+		CumulativeDuration time.Duration
+		Count              int
+		Children           []*node
+	}
+	var root node
+	if err := json.Unmarshal(runOutput(t, demoFile, ".json", "tree"), &root); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := g.Tree()
+	if tree.Duration != root.CumulativeDuration || tree.Count != root.Count {
+		t.Errorf("Tree() totals %d actions taking %s, tree totals %d taking %s", tree.Count, tree.Duration, root.Count, root.CumulativeDuration)
+	}
+
+	// The paths of the command's tree are compacted and rewritten, so its
+	// packages are compared by the actions building them.
+	byAction := make(map[int]*actiongraph.TreeNode)
+	var index func(n *actiongraph.TreeNode)
+	index = func(n *actiongraph.TreeNode) {
+		if n.Action != nil {
+			byAction[n.Action.ID] = n
+		}
+		for _, c := range n.Children {
+			index(c)
+		}
+	}
+	index(tree)
+	var packages int
+	var compare func(n *node)
+	compare = func(n *node) {
+		if n.ID > 0 {
+			packages++
+			if tn := byAction[n.ID]; tn == nil {
+				t.Errorf("Tree() is missing %s, action %d", n.Package, n.ID)
+			} else if tn.Duration != n.CumulativeDuration || tn.Count != n.Count {
+				t.Errorf("Tree() totals %d actions taking %s beneath %s, tree totals %d taking %s", tn.Count, tn.Duration, tn.Path, n.Count, n.CumulativeDuration)
+			}
+		}
+		for _, c := range n.Children {
+			compare(c)
+		}
+	}
+	compare(&root)
+	if packages == 0 {
+		t.Error("tree listed no packages")
+	}
+}
+
+func TestGraphCriticalPath(t *testing.T) {
+	g := loadDemo(t)
+	var want float64
+	sc := bufio.NewScanner(bytes.NewReader(runOutput(t, demoFile, ".txt", "metrics")))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "actiongraph_critical_path_seconds "); ok {
+			var err error
+			if want, err = strconv.ParseFloat(v, 64); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if want == 0 {
+		t.Fatal("metrics gave no critical_path_seconds")
+	}
+
+	path := g.CriticalPath()
+	var total time.Duration
+	for i, act := range path {
+		total += act.Duration
+		if !act.CriticalPath {
+			t.Errorf("%s %s is on CriticalPath() but isn't marked as critical", act.Mode, act.Package)
+		}
+		if i > 0 && !dependsOn(act, path[i-1].ID) {
+			t.Errorf("%s %s doesn't depend on %s %s before it on the critical path", act.Mode, act.Package, path[i-1].Mode, path[i-1].Package)
+		}
+	}
+	if got := strconv.FormatFloat(total.Seconds(), 'f', 3, 64); got != strconv.FormatFloat(want, 'f', 3, 64) {
+		t.Errorf("CriticalPath() takes %ss, metrics gave %.3fs", got, want)
+	}
+}
+
+func dependsOn(act *actiongraph.Action, id int) bool {
+	for _, dep := range act.Deps {
+		if dep == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addBadgeCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "badge [-f compile.json] -o buildtime.svg",
+		Short:   "Draw an SVG badge of the build time",
+		Long: `Draw a shields.io-style SVG badge showing the total build time, for embedding
+in a README or dashboard.
+
+The badge is coloured by --thresholds, a list of DURATION=COLOR pairs: the
+colour of the largest duration which the build time reaches is used, or
+--color if it reaches none. Colours are any SVG colour, or one of the
+shields.io names (brightgreen, green, yellowgreen, yellow, orange, red, blue,
+lightgrey).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			out, err := flags.GetString("output")
+			if err != nil {
+				return err
+			}
+			var bopt badgeOptions
+			bopt.label, err = flags.GetString("label")
+			if err != nil {
+				return err
+			}
+			bopt.color, err = flags.GetString("color")
+			if err != nil {
+				return err
+			}
+			thresholds, err := flags.GetStringSlice("thresholds")
+			if err != nil {
+				return err
+			}
+			bopt.thresholds, err = parseBadgeThresholds(thresholds)
+			if err != nil {
+				return fmt.Errorf("parsing --thresholds: %w", err)
+			}
+			bopt.wall, err = flags.GetBool("wall")
+			if err != nil {
+				return err
+			}
+
+			write := func(w io.Writer) error { return badge(w, opt, bopt) }
+			if out == "" || out == "-" {
+				return write(opt.stdout)
+			}
+			return writeFileAtomic(out, write)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringP("output", "o", "-", "file to write the SVG to (use - for stdout)")
+	flags.String("label", "build time", "text on the left of the badge")
+	flags.String("color", "brightgreen", "colour of the badge below every threshold")
+	flags.StringSlice("thresholds", []string{"1m=yellow", "5m=red"}, "DURATION=COLOR pairs colouring the badge")
+	flags.Bool("wall", false, "show the wall-clock time of the build rather than the total duration of its actions")
+	prog.AddCommand(&cmd)
+}
+
+type badgeOptions struct {
+	label      string
+	color      string
+	thresholds []badgeThreshold
+	wall       bool
+}
+
+type badgeThreshold struct {
+	min   time.Duration
+	color string
+}
+
+func parseBadgeThresholds(pairs []string) ([]badgeThreshold, error) {
+	thresholds := make([]badgeThreshold, 0, len(pairs))
+	for _, pair := range pairs {
+		d, color, ok := strings.Cut(pair, "=")
+		if !ok || color == "" {
+			return nil, fmt.Errorf("%q is not DURATION=COLOR", pair)
+		}
+		min, err := time.ParseDuration(d)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, badgeThreshold{min, color})
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].min < thresholds[j].min })
+	return thresholds, nil
+}
+
+// badgeColors are the colours named by shields.io.
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellowgreen": "#a4a61d",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"lightgrey":   "#9f9f9f",
+}
+
+func badge(w io.Writer, opt *options, bopt badgeOptions) error {
+	var d time.Duration
+	if bopt.wall {
+		d = opt.store.wall()
+	} else {
+		for _, act := range opt.actions() {
+			d += act.Duration
+		}
+	}
+
+	color := bopt.color
+	for _, t := range bopt.thresholds {
+		if d >= t.min {
+			color = t.color
+		}
+	}
+	if c, ok := badgeColors[color]; ok {
+		color = c
+	}
+
+	b := badgeData{
+		Label: bopt.label,
+		Value: humanDuration(d),
+		Color: color,
+	}
+	b.LabelWidth = badgeTextWidth(b.Label) + 10
+	b.ValueWidth = badgeTextWidth(b.Value) + 10
+	return badgeTemplate.Execute(w, b)
+}
+
+// badgeTextWidth approximates the width in pixels of s in 11px Verdana.
+func badgeTextWidth(s string) int {
+	var w float64
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune("iljtfr.:, ", r):
+			w += 4
+		case strings.ContainsRune("mwMW", r):
+			w += 10
+		default:
+			w += 7
+		}
+	}
+	return int(w + 0.5)
+}
+
+type badgeData struct {
+	Label, Value, Color    string
+	LabelWidth, ValueWidth int
+}
+
+var badgeTemplate = template.Must(template.New("badge").Funcs(template.FuncMap{
+	"add":  func(a, b int) int { return a + b },
+	"half": func(a int) float64 { return float64(a) / 2 },
+}).Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{ add .LabelWidth .ValueWidth }}" height="20" role="img" aria-label="{{ html .Label }}: {{ html .Value }}">
+<title>{{ html .Label }}: {{ html .Value }}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{ add .LabelWidth .ValueWidth }}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{ .LabelWidth }}" height="20" fill="#555"/>
+<rect x="{{ .LabelWidth }}" width="{{ .ValueWidth }}" height="20" fill="{{ html .Color }}"/>
+<rect width="{{ add .LabelWidth .ValueWidth }}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{ half .LabelWidth }}" y="15" fill="#010101" fill-opacity=".3">{{ html .Label }}</text>
+<text x="{{ half .LabelWidth }}" y="14">{{ html .Label }}</text>
+<text x="{{ add .LabelWidth .LabelWidth | add .ValueWidth | half }}" y="15" fill="#010101" fill-opacity=".3">{{ html .Value }}</text>
+<text x="{{ add .LabelWidth .LabelWidth | add .ValueWidth | half }}" y="14">{{ html .Value }}</text>
+</g>
+</svg>
+`))
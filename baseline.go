@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addBaselineCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "baseline",
+		Short:   "Manage build time baselines",
+	}
+
+	save := cobra.Command{
+		Use:   "save [-f compile.json] -o baseline.json",
+		Short: "Store the total and per-package durations as a baseline",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+			out, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			b := newBaseline(opt)
+			if out == "" || out == "-" {
+				return b.write(opt.stdout)
+			}
+			return writeFileAtomic(out, b.write)
+		},
+	}
+	save.Flags().StringP("output", "o", "-", "file to write the baseline to (use - for stdout)")
+	cmd.AddCommand(&save)
+
+	prog.AddCommand(&cmd)
+}
+
+func addAssertCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "assert [-f compile.json] [--baseline baseline.json] [--policy budgets.yaml]",
+		Short:   "Fail when the build has regressed from a baseline or exceeds its budgets",
+		Long: `Compare the build with a baseline saved by "actiongraph baseline save",
+exiting with a non-zero status if the total duration, or the duration of any
+package, regressed by more than --max-regression.
+
+Packages taking less than --min-duration in both builds are ignored, since
+their timings are too noisy to compare.
+
+With --policy, the build is checked against the budgets in the YAML or JSON
+file instead, or as well: a list of package globs, as used by tree --exclude,
+each with the largest duration allowed for each of the packages it matches
+(self) or for all of them together (cumulative). Only the compiles are counted
+unless a budget gives another mode:
+
+  - package: example.com/app/...
+    cumulative: 2m
+  - package: example.com/app/internal/gen/**
+    self: 10s
+  - package: example.com/app/cmd/server
+    mode: link
+    self: 30s
+
+Every regression and exceeded budget is listed before exiting.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			path, err := flags.GetString("baseline")
+			if err != nil {
+				return err
+			}
+			policyPath, err := flags.GetString("policy")
+			if err != nil {
+				return err
+			}
+			if path == "" && policyPath == "" {
+				return errors.New("one of --baseline or --policy is required")
+			}
+			var base *baseline
+			if path != "" {
+				base, err = readBaseline(path)
+				if err != nil {
+					return err
+				}
+			}
+			var budgets []*budget
+			if policyPath != "" {
+				budgets, err = readPolicy(policyPath)
+				if err != nil {
+					return err
+				}
+			}
+			maxStr, err := flags.GetString("max-regression")
+			if err != nil {
+				return err
+			}
+			maxRegression, err := parsePercent(maxStr)
+			if err != nil {
+				return fmt.Errorf("parsing --max-regression: %w", err)
+			}
+			minDuration, err := flags.GetDuration("min-duration")
+			if err != nil {
+				return err
+			}
+
+			return assertBaseline(opt, base, budgets, maxRegression, minDuration)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("baseline", "", "baseline file written by baseline save")
+	flags.String("policy", "", "YAML or JSON `file` of the budgets of the packages")
+	flags.String("max-regression", "10%", "largest allowed increase in duration")
+	flags.Duration("min-duration", time.Second, "ignore packages faster than this")
+	prog.AddCommand(&cmd)
+}
+
+// baseline records the durations of a build for later comparison.
+type baseline struct {
+	Total           time.Duration
+	Instrumentation []string `json:",omitempty"`
+
+	// Packages maps the mode and package of each action, as "mode package",
+	// to its total duration.
+	Packages map[string]time.Duration
+}
+
+func newBaseline(opt *options) *baseline {
+	b := baseline{Packages: make(map[string]time.Duration)}
+	for _, act := range opt.actions() {
+		b.Total += act.Duration
+		if act.Package != "" {
+			b.Packages[baselineKey(act)] += act.Duration
+		}
+	}
+	b.Instrumentation = opt.store.instrumentations()
+	return &b
+}
+
+func baselineKey(act *action) string {
+	return act.Mode + " " + act.Package
+}
+
+func (b *baseline) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+func readBaseline(path string) (*baseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var b baseline
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, fmt.Errorf("decoding baseline: %w", err)
+	}
+	return &b, nil
+}
+
+// assertBaseline fails if the build regressed from base, or exceeded any of
+// the budgets, listing each of the failures. Either may be nil.
+func assertBaseline(opt *options, base *baseline, budgets []*budget, maxRegression float64, minDuration time.Duration) error {
+	var regressions []string
+	if base != nil {
+		regressions = checkBaseline(opt, base, maxRegression, minDuration)
+	}
+	exceeded := checkPolicy(opt, budgets)
+
+	for _, f := range regressions {
+		fmt.Fprintln(opt.stdout, f)
+	}
+	for _, f := range exceeded {
+		fmt.Fprintln(opt.stdout, f)
+	}
+	switch {
+	case len(regressions) > 0 && len(exceeded) > 0:
+		return fmt.Errorf("%d regressions over %g%%, %d budgets exceeded", len(regressions), maxRegression, len(exceeded))
+	case len(regressions) > 0:
+		return fmt.Errorf("%d regressions over %g%%", len(regressions), maxRegression)
+	case len(exceeded) > 0:
+		return fmt.Errorf("%d budgets exceeded", len(exceeded))
+	}
+	return nil
+}
+
+// checkBaseline returns a description of the total and each of the packages
+// which regressed from base by more than maxRegression percent.
+func checkBaseline(opt *options, base *baseline, maxRegression float64, minDuration time.Duration) []string {
+	cur := newBaseline(opt)
+	// Baselines saved by earlier versions may list them unsorted.
+	saved := append([]string(nil), base.Instrumentation...)
+	sort.Strings(saved)
+	if a, b := strings.Join(saved, ", "), strings.Join(cur.Instrumentation, ", "); a != b {
+		fmt.Fprintf(opt.stderr, "actiongraph: warning: the builds were instrumented differently (%s vs %s), so their timings aren't comparable\n", a, b)
+	}
+
+	var failures []string
+	check := func(name string, was, now time.Duration) {
+		if was <= 0 || now <= was {
+			return
+		}
+		if change := 100 * float64(now-was) / float64(was); change > maxRegression {
+			failures = append(failures, fmt.Sprintf("%s: %.3fs -> %.3fs (+%.1f%%)", name, was.Seconds(), now.Seconds(), change))
+		}
+	}
+
+	check("total", base.Total, cur.Total)
+	keys := make([]string, 0, len(cur.Packages))
+	for key := range cur.Packages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		was, now := base.Packages[key], cur.Packages[key]
+		if was < minDuration && now < minDuration {
+			continue
+		}
+		check(key, was, now)
+	}
+	return failures
+}
+
+// parsePercent parses a percentage such as "10%" or "10".
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return v, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addBenchCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "bench [--count 5] [--clean-cache] [--dir DIR] -- go build ./...",
+		Short:   "Run a go command repeatedly and summarise the build times of each package",
+		Long: `Run a go command --count times with -debug-actiongraph added, and then list the
+packages which took longest to build on average over the runs, with the
+spread of their durations, as aggregate does:
+
+    actiongraph bench --count 5 --clean-cache -- go build ./...
+
+With --clean-cache, go clean -cache is run before each run, so that every
+package is built each time rather than taken from the cache left by the run
+before.
+
+The actions of each run are written to run-N.json in the --dir, or else in a
+directory in the temporary directory which is kept for later reports, such as
+by aggregate. Its path is printed to stderr.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := rejectStoreFlags(cmd); err != nil {
+				return err
+			}
+			opt := newOptions(cmd)
+
+			flags := cmd.Flags()
+			count, err := flags.GetInt("count")
+			if err != nil {
+				return err
+			}
+			if count < 2 {
+				return errors.New("--count must be at least 2")
+			}
+			clean, err := flags.GetBool("clean-cache")
+			if err != nil {
+				return err
+			}
+			dir, err := flags.GetString("dir")
+			if err != nil {
+				return err
+			}
+			aopt, err := aggregateFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			if dir == "" {
+				dir, err = os.MkdirTemp("", "actiongraph-bench-*")
+			} else {
+				err = os.MkdirAll(dir, 0o777)
+			}
+			if err != nil {
+				return err
+			}
+			files, err := bench(cmd, opt, args, count, clean, dir)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(opt.stderr, "actiongraph: wrote %d runs to %s\n", len(files), dir)
+
+			runs, err := loadStoreFiles(files...)
+			if err != nil {
+				return err
+			}
+			out, err := newRowWriter(cmd, opt, aggregateFormats)
+			if err != nil {
+				return err
+			}
+			return aggregate(opt, runs, aopt, out)
+		},
+	}
+	// Leave the go command's flags to it, even without --.
+	cmd.Flags().SetInterspersed(false)
+	flags := cmd.Flags()
+	flags.Int("count", 5, "number of times to run the go command")
+	flags.Bool("clean-cache", false, "run go clean -cache before each run")
+	flags.String("dir", "", "directory to write the actions of each run to (default a new temporary directory)")
+	addAggregateFlags(&cmd)
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+// bench runs the go command given by args count times, writing the actions
+// of each run to dir and returning the paths of the files written.
+func bench(cmd *cobra.Command, opt *options, args []string, count int, clean bool, dir string) ([]string, error) {
+	files := make([]string, count)
+	for i := range files {
+		if clean {
+			c := exec.CommandContext(cmd.Context(), args[0], "clean", "-cache")
+			c.Stdout, c.Stderr = opt.stderr, opt.stderr
+			if err := c.Run(); err != nil {
+				return nil, fmt.Errorf("%s clean -cache: %w", args[0], err)
+			}
+		}
+
+		files[i] = filepath.Join(dir, fmt.Sprintf("run-%d.json", i+1))
+		start := time.Now()
+		if err := goDebugActiongraph(cmd, opt, args, files[i]); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(opt.stderr, "actiongraph: run %d of %d took %.3fs\n", i+1, count, time.Since(start).Seconds())
+	}
+	return files, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func addBuildIDsCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "buildids [-f compile.json] [-n limit]",
+		Short:   "Find inconsistent build and action IDs",
+		Long: `Group the actions by the action IDs which the go command uses as the keys of
+its build cache, and by the build IDs of their outputs, to find what stopped
+the cache from being reused:
+
+  configs  a package built with several action IDs: its inputs or flags
+           differed, as the Differences of the flags given to some but not
+           all of the builds may show
+  rebuilt  an action ID built more than once, as in the files of several
+           builds merged by test --merge, whose later builds weren't taken
+           from the cache: it was trimmed or not shared
+  outputs  an action ID whose builds produced different outputs, so that the
+           actions depending on it can't be reused either: the build isn't
+           reproducible`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, buildIDFormats)
+			if err != nil {
+				return err
+			}
+			return buildIDs(opt, limit, out)
+		},
+	}
+	cmd.Flags().IntP("limit", "n", 20, "number of anomalies to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var buildIDFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Actions", tpl: `{{ .Actions }}`},
+		{name: "Kind", tpl: `{{ .Kind }}`, left: true},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Differences", tpl: `{{ .Differences | join " " }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Kind", tpl: `{{ .Kind }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Min", tpl: `{{ .Min | seconds }}`},
+		{name: "Max", tpl: `{{ .Max | seconds }}`},
+		{name: "Actions", tpl: `{{ .Actions }}`},
+		{name: "IDs", tpl: `{{ .IDs }}`, left: true},
+		{name: "Kind", tpl: `{{ .Kind }}`, left: true},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Action IDs", tpl: `{{ .ActionIDs | join " " }}`, left: true},
+		{name: "Differences", tpl: `{{ .Differences | join " " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "kind", tpl: `{{ .Kind }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "actions", tpl: `{{ .Actions }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "min", tpl: `{{ .Min.Seconds | printf "%.3f" }}`},
+		{name: "max", tpl: `{{ .Max.Seconds | printf "%.3f" }}`},
+		{name: "action_ids", tpl: `{{ .ActionIDs | join " " }}`},
+		{name: "build_ids", tpl: `{{ .BuildIDs | join " " }}`},
+		{name: "differences", tpl: `{{ .Differences | join " " }}`},
+	},
+}
+
+// buildIDAnomaly is a group of actions whose action or build IDs show that
+// the build cache couldn't be reused.
+type buildIDAnomaly struct {
+	Kind        string // configs, rebuilt or outputs.
+	Mode        string
+	Package     string
+	Actions     int
+	Duration    time.Duration // Of all of the actions.
+	Min, Max    time.Duration // Of the actions which weren't cached.
+	ActionIDs   []string      // Distinct action IDs of the actions.
+	BuildIDs    []string      // Distinct build IDs of the actions.
+	Differences []string      // Flags given to some but not all of the actions.
+	IDs         []int
+}
+
+// buildIDs lists the anomalies found among the actions with action IDs,
+// slowest first, followed by the number of each kind when written as text.
+func buildIDs(opt *options, limit int, out *rowWriter) error {
+	type key struct{ mode, pkg string }
+	byPkg := make(map[key][]*action)
+	byActionID := make(map[string][]*action)
+	for _, act := range opt.actions() {
+		if act.ActionID == "" {
+			continue
+		}
+		k := key{act.Mode, act.Package}
+		byPkg[k] = append(byPkg[k], act)
+		byActionID[act.ActionID] = append(byActionID[act.ActionID], act)
+	}
+
+	var rows []*buildIDAnomaly
+	counts := make(map[string]int)
+	add := func(kind string, acts []*action) {
+		a := &buildIDAnomaly{Kind: kind, Mode: acts[0].Mode, Package: acts[0].Package, Actions: len(acts)}
+		for _, act := range acts {
+			a.Duration += act.Duration
+			a.IDs = append(a.IDs, act.ID)
+			if !slices.Contains(a.ActionIDs, act.ActionID) {
+				a.ActionIDs = append(a.ActionIDs, act.ActionID)
+			}
+			if act.BuildID != "" && !slices.Contains(a.BuildIDs, act.BuildID) {
+				a.BuildIDs = append(a.BuildIDs, act.BuildID)
+			}
+			if !act.Cached {
+				if a.Min == 0 || act.Duration < a.Min {
+					a.Min = act.Duration
+				}
+				if act.Duration > a.Max {
+					a.Max = act.Duration
+				}
+			}
+		}
+		if kind == "configs" {
+			a.Differences = flagDifferences(acts)
+		}
+		rows = append(rows, a)
+		counts[kind]++
+	}
+
+	for _, acts := range byPkg {
+		var ids []string
+		for _, act := range acts {
+			if !slices.Contains(ids, act.ActionID) {
+				ids = append(ids, act.ActionID)
+			}
+		}
+		if len(ids) > 1 {
+			add("configs", acts)
+		}
+	}
+	for _, acts := range byActionID {
+		var ran int
+		outputs := make(map[string]bool)
+		for _, act := range acts {
+			if !act.Cached {
+				ran++
+			}
+			if _, content, ok := strings.Cut(act.BuildID, "/"); ok {
+				outputs[content] = true
+			}
+		}
+		if ran > 1 {
+			add("rebuilt", acts)
+		}
+		if len(outputs) > 1 {
+			add("outputs", acts)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Duration != rows[j].Duration {
+			return rows[i].Duration > rows[j].Duration
+		}
+		if rows[i].Package != rows[j].Package {
+			return rows[i].Package < rows[j].Package
+		}
+		return rows[i].Kind < rows[j].Kind
+	})
+	for i, a := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if err := out.row(a); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+	fmt.Fprintf(opt.stdout, "%d packages built with several action IDs, %d action IDs rebuilt, %d action IDs with different outputs\n",
+		counts["configs"], counts["rebuilt"], counts["outputs"])
+	return nil
+}
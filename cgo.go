@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addCgoCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "cgo [-f compile.json]",
+		Short:   "Summarise time spent building packages using cgo",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, cgoFormats)
+			if err != nil {
+				return err
+			}
+
+			return cgo(opt, out)
+		},
+	}
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var cgoFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "ID", tpl: `{{ .ID }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Files", tpl: `{{ .SourceFiles }} files`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "id", tpl: `{{ .ID }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "source_files", tpl: `{{ .SourceFiles }}`},
+	},
+}
+
+// cgo lists the actions which ran cgo, followed by their total when written as
+// text. The time attributed to cgo is the whole of each action, which includes
+// compiling the Go parts of the package too.
+func cgo(opt *options, out *rowWriter) error {
+	var total time.Duration
+	var count int
+	for _, act := range opt.sorted(byDuration) {
+		if !act.Cgo {
+			continue
+		}
+		total += act.Duration
+		count++
+
+		if err := out.row(act); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	fmt.Fprintf(opt.stdout, "%.3fs  %.2f%%  total of %d cgo actions\n",
+		total.Seconds(), opt.store.percent(total), count)
+	return nil
+}
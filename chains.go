@@ -0,0 +1,234 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addChainsCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "chains [-f compile.json] [-n limit] [--by duration|hops]",
+		Short:   "List the longest chains of dependent packages",
+		Long: `List the longest chains of actions each depending on the one before, from a
+package without dependencies to one without dependents, either by their total
+duration or by the number of actions on them. However many workers the build
+has, each chain takes at least its duration, so shortening the longest chains
+by splitting or removing the packages along them shortens the build.
+
+Only the actions of --mode are followed, which by default are those compiling
+packages, so that the chains follow the packages' imports.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			var copt chainsOptions
+			copt.limit, err = flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			if copt.limit <= 0 {
+				// There can be exponentially many chains.
+				return errors.New("--limit must be positive")
+			}
+			copt.mode, err = flags.GetString("mode")
+			if err != nil {
+				return err
+			}
+			switch by, err := flags.GetString("by"); {
+			case err != nil:
+				return err
+			case by == "hops":
+				copt.byHops = true
+			case by != "duration":
+				return fmt.Errorf("--by: expected duration or hops, got %q", by)
+			}
+
+			out, err := newRowWriter(cmd, opt, chainFormats)
+			if err != nil {
+				return err
+			}
+			return chains(opt, copt, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 10, "number of chains to show")
+	flags.String("by", "duration", "measure the chains by their total duration or by the number of actions on them: duration or hops")
+	flags.String("mode", "build", "mode of the actions to follow, or empty for all of them")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var chainFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Hops", tpl: `{{ .Hops }}`},
+		{name: "Chain", tpl: `{{ .Packages | join " > " }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Hops", tpl: `{{ .Hops }}`},
+		{name: "Last", tpl: `{{ .Last }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Hops", tpl: `{{ .Hops }}`},
+		{name: "IDs", tpl: `{{ .IDs }}`, left: true},
+		{name: "Chain", tpl: `{{ .Packages | join " > " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "hops", tpl: `{{ .Hops }}`},
+		{name: "first", tpl: `{{ .First }}`},
+		{name: "last", tpl: `{{ .Last }}`},
+		{name: "packages", tpl: `{{ .Packages | join " " }}`},
+	},
+}
+
+type chainsOptions struct {
+	limit  int
+	mode   string
+	byHops bool
+}
+
+// chain is a sequence of actions, each depending on the one before.
+type chain struct {
+	Duration time.Duration // Total of the actions on the chain.
+	Percent  float64       // Duration as a percentage of the total of all actions.
+	Hops     int           // Number of actions on the chain.
+	First    string        // Package of the first action, which has no dependencies.
+	Last     string        // Package of the last action, which has no dependents.
+	Packages []string
+	IDs      []int
+}
+
+// chainLink is a chain being built from its last action back towards the
+// first. Links share the tails they extend.
+type chainLink struct {
+	act      *action
+	next     *chainLink // Dependent of act on the chain.
+	length   time.Duration
+	hops     int
+	priority time.Duration // Of the longest complete chain extending the link.
+}
+
+// chainQueue orders links by priority, highest first.
+type chainQueue []*chainLink
+
+func (q chainQueue) Len() int           { return len(q) }
+func (q chainQueue) Less(i, j int) bool { return q[i].priority > q[j].priority }
+func (q chainQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *chainQueue) Push(x any)        { *q = append(*q, x.(*chainLink)) }
+
+func (q *chainQueue) Pop() any {
+	old := *q
+	link := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return link
+}
+
+// chains lists the longest chains of the actions, longest first. It searches
+// back from the actions without dependents, always extending the link with
+// the longest chain of dependencies still possible before it, so that the
+// chains are completed in order of their length.
+func chains(opt *options, copt chainsOptions, out *rowWriter) error {
+	byID := make(map[int]*action)
+	var acts []*action
+	for _, act := range opt.actions() {
+		if copt.mode == "" || act.Mode == copt.mode {
+			byID[act.ID] = act
+			acts = append(acts, act)
+		}
+	}
+
+	// weight is what each action adds to the length of a chain.
+	weight := func(act *action) time.Duration {
+		if copt.byHops {
+			return 1
+		}
+		return act.Duration
+	}
+
+	// before is the length of the longest chain ending with each action.
+	before := make(map[int]time.Duration, len(acts))
+	dependents := make(map[int]int)
+	var visit func(act *action) time.Duration
+	visit = func(act *action) time.Duration {
+		if l, ok := before[act.ID]; ok {
+			return l
+		}
+		before[act.ID] = 0 // Guard against cycles.
+		var longest time.Duration
+		for _, id := range act.Deps {
+			if dep, ok := byID[id]; ok {
+				if l := visit(dep); l > longest {
+					longest = l
+				}
+			}
+		}
+		before[act.ID] = longest + weight(act)
+		return before[act.ID]
+	}
+	for _, act := range acts {
+		visit(act)
+		for _, id := range act.Deps {
+			if _, ok := byID[id]; ok {
+				dependents[id]++
+			}
+		}
+	}
+
+	var q chainQueue
+	for _, act := range acts {
+		if dependents[act.ID] == 0 {
+			q = append(q, &chainLink{act: act, length: weight(act), hops: 1, priority: before[act.ID]})
+		}
+	}
+	heap.Init(&q)
+
+	for n := 0; q.Len() > 0 && n < copt.limit; {
+		link := heap.Pop(&q).(*chainLink)
+		var extended bool
+		for _, id := range link.act.Deps {
+			dep, ok := byID[id]
+			if !ok {
+				continue
+			}
+			extended = true
+			heap.Push(&q, &chainLink{
+				act:      dep,
+				next:     link,
+				length:   link.length + weight(dep),
+				hops:     link.hops + 1,
+				priority: link.length + before[id],
+			})
+		}
+		if extended {
+			continue
+		}
+
+		c := chain{Hops: link.hops}
+		for l := link; l != nil; l = l.next {
+			c.Duration += l.act.Duration
+			c.Packages = append(c.Packages, l.act.Package)
+			c.IDs = append(c.IDs, l.act.ID)
+		}
+		c.Percent = opt.store.percent(c.Duration)
+		c.First, c.Last = c.Packages[0], c.Packages[len(c.Packages)-1]
+		if err := out.row(c); err != nil {
+			return err
+		}
+		n++
+	}
+	return out.flush()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// cmdLines returns the command lines recorded in an action's Cmd field, which
+// is null for cached actions and otherwise a list of strings.
+func cmdLines(cmd any) []string {
+	list, _ := cmd.([]any)
+	lines := make([]string, 0, len(list))
+	for _, l := range list {
+		if s, ok := l.(string); ok {
+			lines = append(lines, s)
+		}
+	}
+	return lines
+}
+
+// usesCgo reports whether the action involved cgo, either by its mode or by
+// running the cgo tool. It requires the action's Commands to be parsed.
+func usesCgo(act *action) bool {
+	if strings.Contains(act.Mode, "cgo") {
+		return true
+	}
+	for _, c := range act.Commands {
+		if c.Tool == "cgo" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitCmd splits a command line into its arguments, removing the quotes
+// which the go command adds around arguments containing spaces. Arguments
+// without quotes are slices of line, rather than copies.
+func splitCmd(line string) []string {
+	args := make([]string, 0, strings.Count(line, " ")+1)
+	var arg strings.Builder
+	var quote byte
+	start, quoted := -1, false // Of the current argument, and whether it's in arg.
+	end := func(i int) {
+		if quoted {
+			args = append(args, arg.String())
+			arg.Reset()
+		} else {
+			args = append(args, line[start:i])
+		}
+		start, quoted = -1, false
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteByte(c)
+		case c == '"' || c == '\'':
+			if start < 0 {
+				start = i
+			}
+			if !quoted {
+				arg.WriteString(line[start:i])
+				quoted = true
+			}
+			quote = c
+		case c == ' ' || c == '\t':
+			if start >= 0 {
+				end(i)
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+			if quoted {
+				arg.WriteByte(c)
+			}
+		}
+	}
+	if start >= 0 {
+		end(len(line))
+	}
+	return args
+}
+
+// instrumentation records the sanitizers an action was built with.
+type instrumentation struct {
+	Race bool
+	Msan bool
+	Asan bool
+}
+
+// flags returns the sanitizer flags, or "none". It isn't String, as action
+// embeds instrumentation and would then print as it.
+func (in instrumentation) flags() string {
+	var flags []string
+	if in.Race {
+		flags = append(flags, "-race")
+	}
+	if in.Msan {
+		flags = append(flags, "-msan")
+	}
+	if in.Asan {
+		flags = append(flags, "-asan")
+	}
+	if len(flags) == 0 {
+		return "none"
+	}
+	return strings.Join(flags, " ")
+}
+
+// cmdInstrumentation finds the sanitizer flags passed to the compiler or
+// linker by the action. It requires the action's Commands to be parsed.
+func cmdInstrumentation(act *action) instrumentation {
+	var in instrumentation
+	for _, c := range act.Commands {
+		if c.Tool != "compile" && c.Tool != "link" {
+			continue
+		}
+		for _, arg := range c.Flags {
+			switch arg {
+			case "-race":
+				in.Race = true
+			case "-msan":
+				in.Msan = true
+			case "-asan":
+				in.Asan = true
+			}
+		}
+	}
+	return in
+}
+
+// command is a command line run by an action.
+type command struct {
+	Tool  string   // Base name of the program, e.g. compile.
+	Args  []string // Arguments following the program.
+	Flags []string // Arguments starting with a dash, e.g. -N or -lang=go1.20.
+	Files []string // Source file arguments.
+}
+
+// sourceExts are the extensions of the source files passed to the toolchain.
+var sourceExts = map[string]bool{
+	".go": true, ".s": true, ".S": true, ".c": true, ".cc": true, ".cpp": true,
+	".cxx": true, ".m": true, ".f": true, ".F": true, ".for": true, ".f90": true,
+	".swig": true, ".swigcxx": true, ".syso": true,
+}
+
+func parseCmd(line string) command {
+	args := splitCmd(line)
+	if len(args) == 0 {
+		return command{}
+	}
+	c := command{Tool: path.Base(args[0]), Args: args[1:]}
+	var flags, files int
+	for _, arg := range c.Args {
+		if strings.HasPrefix(arg, "-") {
+			flags++
+		} else if sourceExts[path.Ext(arg)] {
+			files++
+		}
+	}
+	// Size the slices exactly, as there are many of them.
+	if flags > 0 {
+		c.Flags = make([]string, 0, flags)
+	}
+	if files > 0 {
+		c.Files = make([]string, 0, files)
+	}
+	for _, arg := range c.Args {
+		if strings.HasPrefix(arg, "-") {
+			c.Flags = append(c.Flags, arg)
+		} else if sourceExts[path.Ext(arg)] {
+			c.Files = append(c.Files, arg)
+		}
+	}
+	return c
+}
+
+// parseCmds parses each command line of an action's Cmd.
+func parseCmds(cmd any) []command {
+	lines := cmdLines(cmd)
+	if len(lines) == 0 {
+		return nil
+	}
+	cmds := make([]command, len(lines))
+	for i, line := range lines {
+		cmds[i] = parseCmd(line)
+	}
+	return cmds
+}
+
+// mainCommand returns the index of the command doing the work of the action:
+// the compiler or linker if they were run, else the first command.
+func mainCommand(cmds []command) int {
+	for i, c := range cmds {
+		if c.Tool == "compile" || c.Tool == "link" {
+			return i
+		}
+	}
+	if len(cmds) > 0 {
+		return 0
+	}
+	return -1
+}
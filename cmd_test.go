@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestSplitCmd(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		// Whether each argument is a slice of the line, rather than a copy.
+		shared []bool
+	}{
+		{"", []string{}, nil},
+		{"   ", []string{}, nil},
+		{"compile -o out.a", []string{"compile", "-o", "out.a"}, []bool{true, true, true}},
+		{"  compile\t-N  -l ", []string{"compile", "-N", "-l"}, []bool{true, true, true}},
+		{`compile -D "a b" x.go`, []string{"compile", "-D", "a b", "x.go"}, []bool{true, true, false, true}},
+		{`gcc 'a "b" c' x.c`, []string{"gcc", `a "b" c`, "x.c"}, []bool{true, false, true}},
+		{`gcc -Da"b c"d e`, []string{"gcc", "-Dab cd", "e"}, []bool{true, false, true}},
+		{`gcc -D'a b'"c d"`, []string{"gcc", "-Da bc d"}, []bool{true, false}},
+		{`gcc "" x`, []string{"gcc", "", "x"}, []bool{true, false, true}},
+		{`gcc "unterminated x`, []string{"gcc", "unterminated x"}, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		got := splitCmd(tt.line)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCmd(%q) = %q, want %q", tt.line, got, tt.want)
+			continue
+		}
+		for i, arg := range got {
+			if shared := sliceOf(tt.line, arg); shared != tt.shared[i] {
+				t.Errorf("splitCmd(%q)[%d] = %q: slice of the line is %v, want %v", tt.line, i, arg, shared, tt.shared[i])
+			}
+		}
+	}
+}
+
+// sliceOf reports whether sub shares the memory of s.
+func sliceOf(s, sub string) bool {
+	if len(sub) == 0 || len(s) == 0 {
+		return false
+	}
+	p, q := uintptr(unsafe.Pointer(unsafe.StringData(s))), uintptr(unsafe.Pointer(unsafe.StringData(sub)))
+	return q >= p && q+uintptr(len(sub)) <= p+uintptr(len(s))
+}
+
+func TestParseCmd(t *testing.T) {
+	tests := []struct {
+		line string
+		want command
+	}{
+		{"", command{}},
+		{
+			`/usr/local/go/pkg/tool/linux_amd64/compile -o $WORK/b001/_pkg_.a -p main -lang=go1.20 "-D=" ./main.go ./a.s`,
+			command{
+				Tool:  "compile",
+				Args:  []string{"-o", "$WORK/b001/_pkg_.a", "-p", "main", "-lang=go1.20", "-D=", "./main.go", "./a.s"},
+				Flags: []string{"-o", "-p", "-lang=go1.20", "-D="},
+				Files: []string{"./main.go", "./a.s"},
+			},
+		},
+		{"link -o a.out", command{Tool: "link", Args: []string{"-o", "a.out"}, Flags: []string{"-o"}}},
+	}
+	for _, tt := range tests {
+		if got := parseCmd(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCmd(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// codeowners holds the rules of a CODEOWNERS file. The last matching rule
+// determines the owners of a path.
+type codeowners struct {
+	rules []codeownersRule
+}
+
+type codeownersRule struct {
+	pattern string
+	re      *regexp.Regexp
+	owners  []string
+}
+
+func parseCodeowners(r io.Reader) (*codeowners, error) {
+	var co codeowners
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") || strings.HasPrefix(line, "^[") {
+			continue // GitLab section headings.
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		re, err := codeownersRegexp(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("CODEOWNERS line %d: %w", n, err)
+		}
+		co.rules = append(co.rules, codeownersRule{
+			pattern: fields[0],
+			re:      re,
+			owners:  fields[1:],
+		})
+	}
+	return &co, s.Err()
+}
+
+// codeownersRegexp converts a gitignore-style CODEOWNERS pattern into a
+// regular expression matching the slash-separated paths it applies to, and
+// everything beneath them unless it ends in /*.
+func codeownersRegexp(pattern string) (*regexp.Regexp, error) {
+	p := strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	var re strings.Builder
+	if anchored {
+		re.WriteString("^")
+	} else {
+		re.WriteString("(^|.*/)")
+	}
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case strings.HasPrefix(p[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "/**") && i+3 == len(p):
+			re.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	// A trailing /* matches only the files directly within the directory.
+	if !strings.HasSuffix(p, "/*") {
+		re.WriteString("(/.*)?")
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// dirOwners returns the owners of the Go files in dir, a slash-separated path
+// relative to the repository root.
+func (co *codeowners) dirOwners(dir string) []string {
+	file := strings.TrimPrefix(dir+"/file.go", "./")
+	for i := len(co.rules) - 1; i >= 0; i-- {
+		if co.rules[i].re.MatchString(file) {
+			return co.rules[i].owners
+		}
+	}
+	return nil
+}
+
+// codeownersRoot returns the root of the repository containing the CODEOWNERS
+// file at path, which may be in the root or in a .github or docs directory.
+func codeownersRoot(path string) string {
+	dir := filepath.Dir(path)
+	switch filepath.Base(dir) {
+	case ".github", "docs", ".gitlab":
+		return filepath.Dir(dir)
+	}
+	return dir
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodeownersRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"docs/*", "docs/a.go", true},
+		{"docs/*", "docs/a/b.go", false},
+		{"docs/*", "x/docs/a.go", false},
+		{"docs/", "docs/a/b.go", true},
+		{"docs/", "x/docs/a.go", true},
+		{"/docs", "docs/a/b.go", true},
+		{"/docs", "x/docs/a.go", false},
+		{"docs/**", "docs/a/b.go", true},
+		{"docs/**/*", "docs/a/b.go", true},
+		{"**/logs", "a/logs/b.go", true},
+		{"*.go", "a/b.go", true},
+		{"*.go", "a/b.go.txt", false},
+		{"*", "a/b.go", true},
+		{"/cmd/app?/", "cmd/app1/main.go", true},
+		{"/cmd/app?/", "cmd/app12/main.go", false},
+	}
+	for _, tt := range tests {
+		re, err := codeownersRegexp(tt.pattern)
+		if err != nil {
+			t.Fatalf("codeownersRegexp(%q): %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.match {
+			t.Errorf("%q matches %q: %v, want %v (%s)", tt.pattern, tt.path, got, tt.match, re)
+		}
+	}
+}
+
+func TestDirOwners(t *testing.T) {
+	co, err := parseCodeowners(strings.NewReader(`# Owners
+* @all
+/docs/ @docs # The documentation.
+docs/* @docs-top
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]string{
+		".":        "@all",
+		"cmd":      "@all",
+		"docs":     "@docs-top",
+		"docs/api": "@docs",
+	} {
+		if got := strings.Join(co.dirOwners(dir), " "); got != want {
+			t.Errorf("dirOwners(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
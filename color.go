@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiRed    = "\x1b[31m"
+	ansiReset  = "\x1b[0m"
+)
+
+func addColorFlags(prog *cobra.Command) {
+	prog.PersistentFlags().String("color", "auto", "colour durations in the output: auto, always or never (auto colours only terminals, unless NO_COLOR is set)")
+	prog.PersistentFlags().DurationSlice("color-thresholds", []time.Duration{time.Second, 5 * time.Second}, "durations from which colordur shows yellow and red")
+}
+
+// colorDuration returns the colordur template function, which formats a
+// duration using seconds, coloured green, yellow or red by the --color-thresholds.
+func colorDuration(cmd *cobra.Command, w io.Writer, seconds func(time.Duration) string) (func(time.Duration) string, error) {
+	// The badge command has a --color of its own, so read the global ones.
+	flags := cmd.Root().PersistentFlags()
+	mode, err := flags.GetString("color")
+	if err != nil {
+		return nil, err
+	}
+	thresholds, err := flags.GetDurationSlice("color-thresholds")
+	if err != nil {
+		return nil, err
+	}
+	if len(thresholds) != 2 {
+		return nil, errors.New("--color-thresholds takes the yellow and red durations, such as 1s,5s")
+	}
+	color, err := useColor(mode, w)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(d time.Duration) string {
+		s := seconds(d)
+		if !color {
+			return s
+		}
+		c := ansiGreen
+		if d >= thresholds[1] {
+			c = ansiRed
+		} else if d >= thresholds[0] {
+			c = ansiYellow
+		}
+		return c + s + ansiReset
+	}, nil
+}
+
+// useColor reports whether output to w is coloured in the --color mode.
+func useColor(mode string, w io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && isTerminal(w), nil
+	}
+	return false, fmt.Errorf("--color must be auto, always or never, not %q", mode)
+}
+
+// heatCell returns the heat template function, which shows a percentage as
+// one of the shades ░▒▓█, coloured green, yellow or red when colour is used.
+func heatCell(cmd *cobra.Command, w io.Writer) (func(float64) string, error) {
+	mode, err := cmd.Root().PersistentFlags().GetString("color")
+	if err != nil {
+		return nil, err
+	}
+	color, err := useColor(mode, w)
+	if err != nil {
+		return nil, err
+	}
+	colors := []string{ansiGreen, ansiGreen, ansiYellow, ansiRed}
+	return func(v float64) string {
+		i := level(v, len(heatShades))
+		if !color {
+			return heatShades[i]
+		}
+		return colors[i] + heatShades[i] + ansiReset
+	}, nil
+}
+
+// isTerminal reports whether rw, a reader or writer, is a terminal.
+func isTerminal(rw any) bool {
+	f, ok := rw.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+var ansiRegexp = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// visibleLen returns the number of characters of s shown on a terminal,
+// ignoring colour escape sequences.
+func visibleLen(s string) int {
+	return utf8.RuneCountInString(ansiRegexp.ReplaceAllString(s, ""))
+}
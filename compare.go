@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pkgDelta compares the actions for one mode and package between two builds.
+type pkgDelta struct {
+	Mode    string
+	Package string
+	Base    time.Duration // Zero when missing from the base build.
+	Head    time.Duration // Zero when missing from the head build.
+	Delta   time.Duration
+	Percent float64 // Delta relative to Base, or 0 if there was no Base.
+
+	InBase, InHead bool
+	BaseCached     bool // Whether all of the base actions were cached.
+	HeadCached     bool // Whether all of the head actions were cached.
+}
+
+// Rebuilt reports whether the package was built in head but had been cached,
+// or not built at all, in base.
+func (d pkgDelta) Rebuilt() bool {
+	return d.InHead && !d.HeadCached && (!d.InBase || d.BaseCached)
+}
+
+// compareStores pairs up the actions of the two builds by mode and package.
+// The deltas are ordered by the largest regression first.
+func compareStores(base, head *store) []pkgDelta {
+	deltas := make(map[string]*pkgDelta)
+	get := func(act *action) *pkgDelta {
+		key := act.Mode + " " + act.Package
+		d := deltas[key]
+		if d == nil {
+			d = &pkgDelta{Mode: act.Mode, Package: act.Package, BaseCached: true, HeadCached: true}
+			deltas[key] = d
+		}
+		return d
+	}
+	for i := range base.actions {
+		act := &base.actions[i]
+		d := get(act)
+		d.InBase = true
+		d.Base += act.Duration
+		d.BaseCached = d.BaseCached && act.Cached
+	}
+	for i := range head.actions {
+		act := &head.actions[i]
+		d := get(act)
+		d.InHead = true
+		d.Head += act.Duration
+		d.HeadCached = d.HeadCached && act.Cached
+	}
+
+	list := make([]pkgDelta, 0, len(deltas))
+	for _, d := range deltas {
+		if !d.InBase {
+			d.BaseCached = false
+		}
+		if !d.InHead {
+			d.HeadCached = false
+		}
+		d.Delta = d.Head - d.Base
+		if d.Base > 0 {
+			d.Percent = 100 * float64(d.Delta) / float64(d.Base)
+		}
+		list = append(list, *d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Delta != list[j].Delta {
+			return list[i].Delta > list[j].Delta
+		}
+		if list[i].Package != list[j].Package {
+			return list[i].Package < list[j].Package
+		}
+		return list[i].Mode < list[j].Mode
+	})
+	return list
+}
+
+// instrumentationWarning describes the difference in instrumentation between
+// two builds, whose timings can't then be compared fairly.
+func instrumentationWarning(base, head *store) string {
+	a, b := strings.Join(base.instrumentations(), ", "), strings.Join(head.instrumentations(), ", ")
+	if a == b {
+		return ""
+	}
+	return "the builds were instrumented differently (" + a + " vs " + b + "), so their timings aren't comparable"
+}
+
+// signedSeconds formats a change in duration with its sign.
+func signedSeconds(d time.Duration) string {
+	return fmt.Sprintf("%+.3fs", d.Seconds())
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/icio/actiongraph/actiongraph"
+)
+
+func TestInstrumentationWarning(t *testing.T) {
+	build := func(cmds ...string) *store {
+		acts := make([]actiongraph.Action, len(cmds))
+		for i, cmd := range cmds {
+			acts[i] = actiongraph.Action{ID: i, Mode: "build", Package: "x", Cmd: []any{cmd}}
+		}
+		g, err := actiongraph.New(acts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newStore(g)
+	}
+	plain, race := "compile -p x", "compile -race -p x"
+
+	if w := instrumentationWarning(build(plain, race), build(race, plain)); w != "" {
+		t.Errorf("builds of the same instrumentation, in a different order, gave the warning %q", w)
+	}
+	if w := instrumentationWarning(build(plain), build(race)); w == "" {
+		t.Error("builds of different instrumentation gave no warning")
+	}
+}
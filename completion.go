@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// completePackages completes the packages built in the --file given on the
+// command-line or by $ACTIONGRAPH_FILE. With dirs, the packages are given by
+// their path in the tree, and the directories containing them are offered too.
+func completePackages(dirs bool) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		fn, err := cmd.Flags().GetString("file")
+		if err != nil || fn == "" || fn == "-" {
+			// Don't wait on stdin while completing.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		s, err := loadStoreFile(fn)
+		if err != nil {
+			cobra.CompDebugln(err.Error(), true)
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		seen := make(map[string]bool)
+		var pkgs []string
+		add := func(pkg string) {
+			if !seen[pkg] && strings.HasPrefix(pkg, toComplete) {
+				seen[pkg] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+		for _, act := range s.actions {
+			if act.Mode != "build" || act.Package == "" {
+				continue
+			}
+			if !dirs {
+				add(act.Package)
+				continue
+			}
+			for dir := treePath(act.Package, act.Std); dir != "."; dir = path.Dir(dir) {
+				add(dir)
+			}
+		}
+		sort.Strings(pkgs)
+		return pkgs, cobra.ShellCompDirectiveNoFileComp
+	}
+}
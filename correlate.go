@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addCorrelateCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "correlate [-f compile.json] [-n limit]",
+		Short:   "Compare compile times with package sizes",
+		Long: `Compare the time spent compiling each package with the amount of Go source
+in it, listing the packages which are slowest for their size first.
+
+The sources are read from the files given to the compiler, and so this must
+be run on the machine which performed the build. Packages which were cached,
+or whose sources can't be read, are skipped.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			minLines, err := flags.GetInt("min-lines")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, correlateFormats)
+			if err != nil {
+				return err
+			}
+
+			return correlate(opt, limit, minLines, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of packages to show")
+	flags.Int("min-lines", 100, "ignore packages with fewer lines of Go, whose timings are dominated by overheads")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var correlateFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Per kLOC", tpl: `{{ .PerKLOC | seconds }}/kloc`},
+		{name: "Ratio", tpl: `{{ .Ratio | printf "%.1fx" }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Lines", tpl: `{{ .Lines }} lines`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Per kLOC", tpl: `{{ .PerKLOC | seconds }}/kloc`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Per kLOC", tpl: `{{ .PerKLOC | seconds }}/kloc`},
+		{name: "Ratio", tpl: `{{ .Ratio | printf "%.1fx" }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Lines", tpl: `{{ .Lines }} lines`},
+		{name: "Bytes", tpl: `{{ .Bytes }} bytes`},
+		{name: "Files", tpl: `{{ .SourceFiles }} files`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "lines", tpl: `{{ .Lines }}`},
+		{name: "bytes", tpl: `{{ .Bytes }}`},
+		{name: "per_kloc", tpl: `{{ .PerKLOC.Seconds | printf "%.3f" }}`},
+		{name: "ratio", tpl: `{{ .Ratio | printf "%.2f" }}`},
+	},
+}
+
+func correlate(opt *options, limit, minLines int, out *rowWriter) error {
+	var rows []correlateAction
+	skipped := 0
+	for _, act := range opt.actions() {
+		if act.Mode != "build" || act.Cached {
+			continue
+		}
+		lines, size, ok := sourceSize(act)
+		if !ok {
+			skipped++
+			continue
+		}
+		if lines < minLines {
+			continue
+		}
+		rows = append(rows, correlateAction{
+			action:  *act,
+			Lines:   lines,
+			Bytes:   size,
+			PerKLOC: time.Duration(float64(act.Duration) * 1000 / float64(lines)),
+		})
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no package sources could be read (%d skipped)", skipped)
+	}
+
+	// Compare each package with the median rate.
+	sort.Slice(rows, func(i, j int) bool { return rows[i].PerKLOC > rows[j].PerKLOC })
+	median := rows[len(rows)/2].PerKLOC
+	for i := range rows {
+		rows[i].Ratio = float64(rows[i].PerKLOC) / float64(median)
+	}
+
+	for i, row := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if err := out.row(row); err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		fmt.Fprintf(opt.stderr, "actiongraph: skipped %d packages whose sources couldn't be read\n", skipped)
+	}
+	return out.flush()
+}
+
+type correlateAction struct {
+	action
+	Lines   int           // Lines of Go source compiled.
+	Bytes   int64         // Bytes of Go source compiled.
+	PerKLOC time.Duration // Duration per thousand lines.
+	Ratio   float64       // PerKLOC relative to the median package.
+}
+
+// sourceSize counts the lines and bytes of the Go files given to the compiler
+// by act. Relative paths can only be resolved when the actions were enriched
+// by go list.
+func sourceSize(act *action) (lines int, size int64, ok bool) {
+	for _, c := range act.Commands {
+		if c.Tool != "compile" {
+			continue
+		}
+		for _, fn := range c.Files {
+			if filepath.Ext(fn) != ".go" {
+				continue
+			}
+			if !filepath.IsAbs(fn) {
+				if act.Dir == "" {
+					return 0, 0, false
+				}
+				fn = filepath.Join(act.Dir, fn)
+			}
+			src, err := os.ReadFile(fn)
+			if err != nil {
+				return 0, 0, false
+			}
+			lines += bytes.Count(src, []byte("\n"))
+			size += int64(len(src))
+			ok = true
+		}
+	}
+	return lines, size, ok
+}
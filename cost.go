@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addCostCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "cost [-f compile.json] --per-cpu-hour PRICE",
+		Short:   "Estimate the monetary cost of the build",
+		Long: `Estimate the cost of the build from the CPU time of its commands, broken
+down by action mode and by directory.
+
+The CPU time of each action is the user and system time of its commands, as
+recorded by the go command, or its duration where that wasn't recorded.
+Cached actions are free. Given --cpus, the cost of occupying a machine with
+that many CPUs for the wall-clock time of the build is shown too.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			var copt costOptions
+			copt.perCPUHour, err = flags.GetFloat64("per-cpu-hour")
+			if err != nil {
+				return err
+			}
+			if copt.perCPUHour <= 0 {
+				return fmt.Errorf("--per-cpu-hour must be positive")
+			}
+			copt.cpus, err = flags.GetInt("cpus")
+			if err != nil {
+				return err
+			}
+			copt.level, err = flags.GetInt("level")
+			if err != nil {
+				return err
+			}
+
+			tplStr, err := tplFlag(flags)
+			if err != nil {
+				return err
+			}
+			copt.currency, err = flags.GetString("currency")
+			if err != nil {
+				return err
+			}
+			funcs := template.FuncMap{"money": func(v float64) string {
+				return fmt.Sprintf("%s%.4f", copt.currency, v)
+			}}
+			tpl, err := template.New("cost").Funcs(opt.funcs).Funcs(funcs).Parse(tplStr)
+			if err != nil {
+				return fmt.Errorf("parsing tpl: %w", err)
+			}
+
+			return cost(opt, copt, tpl)
+		},
+	}
+	flags := cmd.Flags()
+	flags.Float64("per-cpu-hour", 0, "price of one CPU for an hour")
+	flags.Int("cpus", 0, "number of CPUs of the build machine, to estimate the cost of its wall-clock time")
+	flags.IntP("level", "L", 1, "directory depth of the per-directory breakdown")
+	flags.String("currency", "$", "currency symbol")
+	addTplFlags(flags, `{{ .CPU | seconds | right 10 }}{{ .Percent | percent | right 8 }}{{ .Cost | money | right 11 }}  {{.Name}}`, "template for each row of the breakdowns")
+	prog.AddCommand(&cmd)
+}
+
+type costOptions struct {
+	perCPUHour float64
+	cpus       int
+	level      int
+	currency   string
+}
+
+func cost(opt *options, copt costOptions, tpl *template.Template) error {
+	price := func(d time.Duration) float64 { return d.Hours() * copt.perCPUHour }
+
+	var total time.Duration
+	byMode := map[string]time.Duration{}
+	byDir := map[string]time.Duration{}
+	for _, act := range opt.actions() {
+		cpu := act.cpuTime()
+		total += cpu
+		byMode[act.Mode] += cpu
+		if act.Package != "" {
+			byDir[treeDir(treePath(act.Package, act.Std), copt.level)] += cpu
+		}
+	}
+
+	fmt.Fprintf(opt.stdout, "CPU time:  %s (%s%.4f)\n", total.Round(time.Millisecond), copt.currency, price(total))
+	if copt.cpus > 0 {
+		wall := opt.store.wall()
+		machine := wall * time.Duration(copt.cpus)
+		fmt.Fprintf(opt.stdout, "Wall time: %s on %d CPUs (%s%.4f)\n", wall.Round(time.Millisecond), copt.cpus, copt.currency, price(machine))
+	}
+
+	for _, section := range []struct {
+		title  string
+		totals map[string]time.Duration
+	}{
+		{"By mode:", byMode},
+		{"By directory:", byDir},
+	} {
+		fmt.Fprintf(opt.stdout, "\n%s\n", section.title)
+		names := make([]string, 0, len(section.totals))
+		for name := range section.totals {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			a, b := section.totals[names[i]], section.totals[names[j]]
+			if a != b {
+				return a > b
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			cpu := section.totals[name]
+			row := costRow{Name: name, CPU: cpu, Cost: price(cpu)}
+			if total > 0 {
+				row.Percent = 100 * float64(cpu) / float64(total)
+			}
+			if err := tpl.Execute(opt.stdout, row); err != nil {
+				return err
+			}
+			fmt.Fprintln(opt.stdout)
+		}
+	}
+	return nil
+}
+
+type costRow struct {
+	Name    string
+	CPU     time.Duration
+	Percent float64 // Percentage of the total CPU time.
+	Cost    float64
+}
+
+// treeDir truncates the tree path to at most level directories.
+func treeDir(path string, level int) string {
+	if level <= 0 {
+		return path
+	}
+	parts := strings.SplitN(path, "/", level+1)
+	if len(parts) > level {
+		parts = parts[:level]
+	}
+	return strings.Join(parts, "/")
+}
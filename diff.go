@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addDiffCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "diff --base base.json --head head.json [--tree [-L level]]",
+		Short:   "Compare the build times of each package between two builds",
+		Long: `List the packages whose build time changed the most between the base build
+and the head build, as the change in the total duration of the actions of
+each mode and package.
+
+With --tree, the packages' compiles are arranged by directory instead, as by
+the tree command, with the base, head and change in the total build time of
+each directory, so that a regression can be traced to the subtree it's in.
+The directories which changed the most are listed first, and those which
+didn't change are left out.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := rejectStoreFlags(cmd); err != nil {
+				return err
+			}
+			opt := newOptions(cmd)
+
+			flags := cmd.Flags()
+			basePath, err := flags.GetString("base")
+			if err != nil {
+				return err
+			}
+			headPath, err := flags.GetString("head")
+			if err != nil {
+				return err
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			asTree, err := flags.GetBool("tree")
+			if err != nil {
+				return err
+			}
+			level, err := flags.GetInt("level")
+			if err != nil {
+				return err
+			}
+
+			builds, err := loadStoreFiles(basePath, headPath)
+			if err != nil {
+				return err
+			}
+			base, head := builds[0], builds[1]
+			if warning := instrumentationWarning(base, head); warning != "" {
+				fmt.Fprintf(opt.stderr, "actiongraph: warning: %s\n", warning)
+			}
+
+			opt.funcs["signed"] = signedSeconds
+			if asTree {
+				out, err := newRowWriter(cmd, opt, diffTreeFormats)
+				if err != nil {
+					return err
+				}
+				return diffTree(base, head, level, out)
+			}
+			out, err := newRowWriter(cmd, opt, diffFormats)
+			if err != nil {
+				return err
+			}
+			return diff(base, head, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("base", "", "actiongraph JSON file of the base build")
+	flags.String("head", "", "actiongraph JSON file of the head build")
+	flags.IntP("limit", "n", 20, "number of packages to show")
+	flags.Bool("tree", false, "compare the total build times of each directory, as a tree")
+	flags.IntP("level", "L", -1, "with --tree, descend only level directories deep (-ve for unlimited)")
+	cmd.MarkFlagRequired("base")
+	cmd.MarkFlagRequired("head")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var diffFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Change", tpl: `{{ .Delta | signed }}`},
+		{name: "Percent", tpl: `{{ if .InBase }}{{ printf "%+.1f%%" .Percent }}{{ else }}new{{ end }}`},
+		{name: "Base", tpl: `{{ if .InBase }}{{ .Base | seconds }}{{ else }}-{{ end }}`},
+		{name: "Head", tpl: `{{ if .InHead }}{{ .Head | seconds }}{{ else }}-{{ end }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Change", tpl: `{{ .Delta | signed }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Change", tpl: `{{ .Delta | signed }}`},
+		{name: "Percent", tpl: `{{ if .InBase }}{{ printf "%+.1f%%" .Percent }}{{ else }}new{{ end }}`},
+		{name: "Base", tpl: `{{ if .InBase }}{{ .Base | seconds }}{{ if .BaseCached }} (cached){{ end }}{{ else }}-{{ end }}`},
+		{name: "Head", tpl: `{{ if .InHead }}{{ .Head | seconds }}{{ if .HeadCached }} (cached){{ end }}{{ else }}-{{ end }}`},
+		{name: "Rebuilt", tpl: `{{ if .Rebuilt }}rebuilt{{ end }}`, left: true},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "base", tpl: `{{ .Base.Seconds | printf "%.3f" }}`},
+		{name: "head", tpl: `{{ .Head.Seconds | printf "%.3f" }}`},
+		{name: "delta", tpl: `{{ .Delta.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "in_base", tpl: `{{ .InBase }}`},
+		{name: "in_head", tpl: `{{ .InHead }}`},
+	},
+}
+
+// diff lists the packages whose build time changed the most, in either
+// direction. Those which didn't change at all are left out.
+func diff(base, head *store, limit int, out *rowWriter) error {
+	deltas := compareStores(base, head)
+	sort.SliceStable(deltas, func(i, j int) bool {
+		return abs(deltas[i].Delta) > abs(deltas[j].Delta)
+	})
+	n := 0
+	for _, d := range deltas {
+		if d.Delta == 0 {
+			continue
+		}
+		if n++; limit > 0 && n > limit {
+			break
+		}
+		if err := out.row(d); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+var diffTreeFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Base", tpl: `{{ if .InBase }}{{ .Base | seconds }}{{ else }}-{{ end }}`},
+		{name: "Head", tpl: `{{ if .InHead }}{{ .Head | seconds }}{{ else }}-{{ end }}`},
+		{name: "Change", tpl: `{{ .Delta | signed }}`},
+		{name: "Percent", tpl: `{{ if .InBase }}{{ printf "%+.1f%%" .Percent }}{{ else }}new{{ end }}`},
+		{name: "Package", tpl: `{{ .Indent }}{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Change", tpl: `{{ .Delta | signed }}`},
+		{name: "Package", tpl: `{{ .Indent }}{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Base", tpl: `{{ if .InBase }}{{ .Base | seconds }}{{ else }}-{{ end }}`},
+		{name: "Head", tpl: `{{ if .InHead }}{{ .Head | seconds }}{{ else }}-{{ end }}`},
+		{name: "Change", tpl: `{{ .Delta | signed }}`},
+		{name: "Percent", tpl: `{{ if .InBase }}{{ printf "%+.1f%%" .Percent }}{{ else }}new{{ end }}`},
+		{name: "Base #", tpl: `{{ .BaseCount }}`},
+		{name: "Head #", tpl: `{{ .HeadCount }}`},
+		{name: "Package", tpl: `{{ .Indent }}{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "depth", tpl: `{{ .Depth }}`},
+		{name: "base", tpl: `{{ .Base.Seconds | printf "%.3f" }}`},
+		{name: "head", tpl: `{{ .Head.Seconds | printf "%.3f" }}`},
+		{name: "delta", tpl: `{{ .Delta.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "base_count", tpl: `{{ .BaseCount }}`},
+		{name: "head_count", tpl: `{{ .HeadCount }}`},
+	},
+}
+
+// treeDelta compares the total build time of a directory between two builds.
+type treeDelta struct {
+	Package string
+	Depth   int
+	Indent  string
+	Base    time.Duration // Zero when missing from the base build.
+	Head    time.Duration // Zero when missing from the head build.
+	Delta   time.Duration
+	Percent float64 // Delta relative to Base, or 0 if there was no Base.
+
+	InBase, InHead       bool
+	BaseCount, HeadCount int // Number of packages compiled within the directory.
+}
+
+// diffTree writes the tree of directories in which the build time of any
+// package changed, even if their total didn't, depth-first, with the largest
+// changes first within each directory. Unless level is negative, directories
+// deeper than it are left out.
+func diffTree(base, head *store, level int, out *rowWriter) error {
+	var walk func(b, h *pkgtree, path string, depth int) error
+	walk = func(b, h *pkgtree, path string, depth int) error {
+		d := treeDelta{
+			Package: path,
+			Depth:   depth,
+			Indent:  strings.Repeat("  ", depth),
+			InBase:  b != nil,
+			InHead:  h != nil,
+		}
+		var kids []string
+		seen := make(map[string]bool)
+		for _, n := range []*pkgtree{b, h} {
+			if n == nil {
+				continue
+			}
+			for k := range n.dir {
+				if !seen[k] {
+					seen[k] = true
+					kids = append(kids, k)
+				}
+			}
+		}
+		if b != nil {
+			d.Base, d.BaseCount = b.d, b.count
+		}
+		if h != nil {
+			d.Head, d.HeadCount = h.d, h.count
+		}
+		d.Delta = d.Head - d.Base
+		if d.Base > 0 {
+			d.Percent = 100 * float64(d.Delta) / float64(d.Base)
+		}
+		if unchanged(b, h) {
+			return nil
+		}
+		if err := out.row(d); err != nil {
+			return err
+		}
+		if level >= 0 && depth >= level {
+			return nil
+		}
+
+		child := func(n *pkgtree, k string) *pkgtree {
+			if n == nil {
+				return nil
+			}
+			return n.dir[k]
+		}
+		change := func(k string) time.Duration {
+			var d time.Duration
+			if n := child(h, k); n != nil {
+				d += n.d
+			}
+			if n := child(b, k); n != nil {
+				d -= n.d
+			}
+			return abs(d)
+		}
+		sort.Slice(kids, func(i, j int) bool {
+			if ci, cj := change(kids[i]), change(kids[j]); ci != cj {
+				return ci > cj
+			}
+			return kids[i] < kids[j]
+		})
+		for _, k := range kids {
+			if err := walk(child(b, k), child(h, k), k, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(buildTree(base.view(), nil, nil), buildTree(head.view(), nil, nil), "(root)", 0); err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// unchanged returns whether the subtrees b and h took the same time in each
+// of their packages. Those whose total is unchanged may still have packages
+// taking longer and others taking less time.
+func unchanged(b, h *pkgtree) bool {
+	if b == nil || h == nil {
+		return b == h
+	}
+	if b.d != h.d || b.self != h.self || b.count != h.count || len(b.dir) != len(h.dir) {
+		return false
+	}
+	for k, bk := range b.dir {
+		if !unchanged(bk, h.dir[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs[T ~int64](v T) T {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
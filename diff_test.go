@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDiffTreeOffsetting checks that the packages of a directory whose changes
+// cancel out are still listed.
+func TestDiffTreeOffsetting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if err := run("diff", "--tree",
+		"--base", filepath.Join("testdata", "shift-base.json"),
+		"--head", filepath.Join("testdata", "shift-head.json"),
+		"--output", path,
+	); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"\nexample.com/a,2,", "\nexample.com/a/x,3,", "\nexample.com/a/y,3,"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("diff --tree didn't list %s:\n%s", strings.TrimSuffix(want[1:], ","), out)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// discoverFile returns the most recently written of the actiongraph files in
+// the conventional places: compile.json in the current directory, the files
+// kept by exec and test in the temporary directory, and the JSON files in the
+// artifacts directory, if any.
+func discoverFile(artifacts string) (string, error) {
+	patterns := []string{
+		"compile.json",
+		filepath.Join(os.TempDir(), "actiongraph-*.json"),
+	}
+	if artifacts != "" {
+		patterns = append(patterns, filepath.Join(artifacts, "*.json"))
+	}
+
+	var newest string
+	var newestTime time.Time
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		for _, fn := range matches {
+			fi, err := os.Stat(fn)
+			if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 {
+				continue
+			}
+			if fi.ModTime().After(newestTime) {
+				newest, newestTime = fn, fi.ModTime()
+			}
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no --file given, and no actiongraph file found in %s", strings.Join(patterns, ", "))
+	}
+	return newest, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addDominatorsCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "dominators [-f compile.json] [-n limit]",
+		Short:   "List the packages through which most of the build is reached",
+		Long: `List the actions which dominate the most build time: an action dominates
+another if every chain of dependencies from the build's root to the other
+passes through it, so that the other is needed only because of it. Were the
+package no longer imported, or replaced by a stub without its imports, none of
+the actions it dominates would need to run.
+
+The actions are ordered by the time of those they dominate, not counting their
+own. Each action's immediate dominator, the closest of those dominating it,
+gives its parent in the dominator tree.
+
+Only the actions of --mode are followed, which by default are those compiling
+packages, so that the dependencies are the packages' imports: linking depends
+on every package directly, and so would dominate them all.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			mode, err := flags.GetString("mode")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, dominatorFormats)
+			if err != nil {
+				return err
+			}
+			return dominators(opt, mode, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of actions to show")
+	flags.String("mode", "build", "mode of the actions to follow, or empty for all of them")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var dominatorFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Dominated", tpl: `{{ .Dominated | colordur }}`},
+		{name: "Percent", tpl: `{{ .DominatedPercent | percent }}`},
+		{name: "Actions", tpl: `{{ .Count }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Dominated", tpl: `{{ .Dominated | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "ID", tpl: `{{ .ID }}`},
+		{name: "Dominated", tpl: `{{ .Dominated | colordur }}`},
+		{name: "Percent", tpl: `{{ .DominatedPercent | percent }}`},
+		{name: "Actions", tpl: `{{ .Count }}`},
+		{name: "Own", tpl: `{{ .Duration | seconds }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Dominator", tpl: `{{ if ge .Idom 0 }}{{ .IdomMode }} {{ .IdomPackage }}{{ end }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "id", tpl: `{{ .ID }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "dominated", tpl: `{{ .Dominated.Seconds | printf "%.3f" }}`},
+		{name: "dominated_percent", tpl: `{{ .DominatedPercent | printf "%.2f" }}`},
+		{name: "count", tpl: `{{ .Count }}`},
+		{name: "idom", tpl: `{{ .Idom }}`},
+	},
+}
+
+// dominator is an action with the actions it dominates.
+type dominator struct {
+	ID               int
+	Mode             string
+	Package          string
+	Duration         time.Duration // Of the action itself.
+	Dominated        time.Duration // Of the actions it dominates, not counting itself.
+	DominatedPercent float64       // Dominated as a percentage of the total of all actions.
+	Count            int           // Number of actions it dominates, not counting itself.
+	Idom             int           // ID of the immediate dominator, or -1 for the actions without dependents.
+	IdomMode         string
+	IdomPackage      string
+}
+
+// dominators lists the actions of the mode, with a package, which dominate
+// the most time. The actions without dependents are all taken to depend on a
+// single root, which dominates everything and isn't listed.
+func dominators(opt *options, mode string, limit int, out *rowWriter) error {
+	var acts []*action
+	for _, act := range opt.actions() {
+		if mode == "" || act.Mode == mode {
+			acts = append(acts, act)
+		}
+	}
+	if len(acts) == 0 {
+		return errors.New("no actions to follow")
+	}
+	idom := dominatorTree(acts)
+
+	// Total the subtrees of the dominator tree from the leaves up, ordering
+	// each action after its dominator.
+	root := len(acts)
+	order := make([]int, 0, len(acts))
+	visited := make([]bool, len(acts))
+	var walk func(i int)
+	walk = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		if p := idom[i]; p != root {
+			walk(p)
+		}
+		order = append(order, i)
+	}
+	for i := range acts {
+		walk(i)
+	}
+	sub := make([]time.Duration, len(acts)+1)
+	count := make([]int, len(acts)+1)
+	for j := len(order) - 1; j >= 0; j-- {
+		i := order[j]
+		sub[i] += acts[i].Duration
+		count[i]++
+		sub[idom[i]] += sub[i]
+		count[idom[i]] += count[i]
+	}
+
+	var rows []*dominator
+	for i, act := range acts {
+		if act.Package == "" || count[i] < 2 {
+			continue
+		}
+		d := &dominator{
+			ID:        act.ID,
+			Mode:      act.Mode,
+			Package:   act.Package,
+			Duration:  act.Duration,
+			Dominated: sub[i] - act.Duration,
+			Count:     count[i] - 1,
+			Idom:      -1,
+		}
+		d.DominatedPercent = opt.store.percent(d.Dominated)
+		if p := idom[i]; p != root {
+			d.Idom, d.IdomMode, d.IdomPackage = acts[p].ID, acts[p].Mode, acts[p].Package
+		}
+		rows = append(rows, d)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Dominated > rows[j].Dominated
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	for _, d := range rows {
+		if err := out.row(d); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+// dominatorTree returns the index of the immediate dominator of each of the
+// actions, following their dependencies from a root on which those without
+// dependents depend, whose index is len(acts). It's found by the algorithm of
+// Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm".
+func dominatorTree(acts []*action) []int {
+	root := len(acts)
+	index := make(map[int]int, len(acts))
+	for i, act := range acts {
+		index[act.ID] = i
+	}
+	succs := make([][]int, len(acts)+1)
+	preds := make([][]int, len(acts)+1)
+	for i, act := range acts {
+		for _, id := range act.Deps {
+			if j, ok := index[id]; ok {
+				succs[i] = append(succs[i], j)
+				preds[j] = append(preds[j], i)
+			}
+		}
+	}
+	for i := range acts {
+		if len(preds[i]) == 0 {
+			succs[root] = append(succs[root], i)
+			preds[i] = append(preds[i], root)
+		}
+	}
+
+	// Number the actions in postorder from the root.
+	post := make([]int, len(acts)+1)
+	for i := range post {
+		post[i] = -1
+	}
+	var order []int
+	var visit func(i int)
+	visit = func(i int) {
+		post[i] = -2 // Visiting.
+		for _, j := range succs[i] {
+			if post[j] == -1 {
+				visit(j)
+			}
+		}
+		post[i] = len(order)
+		order = append(order, i)
+	}
+	visit(root)
+
+	idom := make([]int, len(acts)+1)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[root] = root
+	intersect := func(a, b int) int {
+		for a != b {
+			for post[a] < post[b] {
+				a = idom[a]
+			}
+			for post[b] < post[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for k := len(order) - 2; k >= 0; k-- { // In reverse postorder, after the root.
+			i := order[k]
+			d := -1
+			for _, p := range preds[i] {
+				if idom[p] < 0 || post[p] < 0 {
+					continue
+				}
+				if d < 0 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d >= 0 && idom[i] != d {
+				idom[i] = d
+				changed = true
+			}
+		}
+	}
+
+	// Actions only reachable through a cycle are left to the root.
+	for i := range acts {
+		if idom[i] < 0 {
+			idom[i] = root
+		}
+	}
+	return idom[:len(acts)]
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addDuplicatesCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "duplicates [-f compile.json] [-n limit]",
+		Short:   "List packages compiled more than once",
+		Long: `List the packages which were compiled more than once, such as for their tests
+as well as the program, or with the conflicting flags of files joined by test
+--merge, ordered by the time duplicated: that beyond their slowest compile.
+The flags given to some but not all of a package's compiles point to the
+configuration which made them differ.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, duplicateFormats)
+			if err != nil {
+				return err
+			}
+			return duplicates(opt, limit, out)
+		},
+	}
+	cmd.Flags().IntP("limit", "n", 20, "number of packages to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var duplicateFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duplicated", tpl: `{{ .Duplicated | colordur }}`},
+		{name: "Total", tpl: `{{ .Duration | seconds }}`},
+		{name: "Builds", tpl: `{{ .Builds }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Differences", tpl: `{{ .Differences | join " " }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duplicated", tpl: `{{ .Duplicated | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Duplicated", tpl: `{{ .Duplicated | colordur }}`},
+		{name: "Total", tpl: `{{ .Duration | seconds }}`},
+		{name: "Builds", tpl: `{{ .Builds }}`},
+		{name: "Cached", tpl: `{{ .Cached }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Differences", tpl: `{{ .Differences | join " " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "builds", tpl: `{{ .Builds }}`},
+		{name: "cached", tpl: `{{ .Cached }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "duplicated", tpl: `{{ .Duplicated.Seconds | printf "%.3f" }}`},
+		{name: "differences", tpl: `{{ .Differences | join " " }}`},
+	},
+}
+
+// duplicate is a package compiled more than once.
+type duplicate struct {
+	Package     string
+	Builds      int
+	Cached      int           // Number of the builds taken from the cache.
+	Duration    time.Duration // Of all of the builds.
+	Duplicated  time.Duration // Of the builds but the slowest.
+	Differences []string      // Flags given to some but not all of the builds.
+	IDs         []int         // Of the builds.
+}
+
+// duplicates lists the packages compiled more than once, followed by the total
+// time duplicated when written as text. The go command names the variant of a
+// package compiled for its tests as the package itself, so the two are
+// counted together.
+func duplicates(opt *options, limit int, out *rowWriter) error {
+	byPkg := make(map[string]*duplicate)
+	builds := make(map[string][]*action)
+	for _, act := range opt.actions() {
+		if act.Mode != "build" || act.Package == "" {
+			continue
+		}
+		pkg := act.Package
+		d, ok := byPkg[pkg]
+		if !ok {
+			d = &duplicate{Package: pkg}
+			byPkg[pkg] = d
+		}
+		d.Builds++
+		if act.Cached {
+			d.Cached++
+		}
+		d.Duration += act.Duration
+		d.IDs = append(d.IDs, act.ID)
+		builds[pkg] = append(builds[pkg], act)
+	}
+
+	var rows []*duplicate
+	var duplicated time.Duration
+	for pkg, d := range byPkg {
+		if d.Builds < 2 {
+			continue
+		}
+		var slowest time.Duration
+		for _, act := range builds[pkg] {
+			if act.Duration > slowest {
+				slowest = act.Duration
+			}
+		}
+		d.Duplicated = d.Duration - slowest
+		d.Differences = flagDifferences(builds[pkg])
+		duplicated += d.Duplicated
+		rows = append(rows, d)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Duplicated != rows[j].Duplicated {
+			return rows[i].Duplicated > rows[j].Duplicated
+		}
+		return rows[i].Package < rows[j].Package
+	})
+
+	for i, d := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if err := out.row(d); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+	fmt.Fprintf(opt.stdout, "%.3fs (%.2f%%) duplicated by %d packages compiled more than once\n",
+		duplicated.Seconds(), opt.store.percent(duplicated), len(rows))
+	return nil
+}
+
+// flagDifferences returns the flags of the main commands given to some but
+// not all of the actions, in order. Cached actions ran no commands, so they
+// aren't compared.
+func flagDifferences(acts []*action) []string {
+	count := make(map[string]int)
+	var ran int
+	for _, act := range acts {
+		if act.Cached {
+			continue
+		}
+		ran++
+		seen := make(map[string]bool)
+		for _, f := range act.Flags {
+			if !seen[f] {
+				seen[f] = true
+				count[f]++
+			}
+		}
+	}
+	var diff []string
+	for f, n := range count {
+		if n < ran {
+			diff = append(diff, f)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
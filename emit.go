@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addEmitCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "emit [-f compile.json] --statsd host:8125 [--prefix actiongraph]",
+		Short:   "Send build timings to StatsD",
+		Long: `Send the total time of the actions, the wall time of the build and the time
+spent in each top-level directory to a StatsD server as timings, in
+milliseconds, over UDP:
+
+    actiongraph emit -f compile.json --statsd localhost:8125 --prefix ci.build
+
+The directories are named within the metric, such as ci.build.dir.std, unless
+--dogstatsd is set, which tags a single ci.build.dir metric with the dir
+instead, along with any --tag given, as understood by the Datadog agent.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			var eopt emitOptions
+			eopt.addr, err = flags.GetString("statsd")
+			if err != nil {
+				return err
+			}
+			eopt.prefix, err = flags.GetString("prefix")
+			if err != nil {
+				return err
+			}
+			eopt.level, err = flags.GetInt("level")
+			if err != nil {
+				return err
+			}
+			eopt.dogstatsd, err = flags.GetBool("dogstatsd")
+			if err != nil {
+				return err
+			}
+			eopt.tags, err = flags.GetStringArray("tag")
+			if err != nil {
+				return err
+			}
+			if len(eopt.tags) > 0 && !eopt.dogstatsd {
+				return errors.New("--tag requires --dogstatsd")
+			}
+
+			conn, err := net.Dial("udp", eopt.addr)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			n, err := emitStatsd(conn, opt, eopt)
+			if err != nil {
+				return fmt.Errorf("sending to %s: %w", eopt.addr, err)
+			}
+			fmt.Fprintf(opt.stderr, "actiongraph: sent %d metrics to %s\n", n, eopt.addr)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("statsd", "", "host:port of the StatsD server")
+	cmd.MarkFlagRequired("statsd")
+	flags.String("prefix", "actiongraph", "prefix of the metric names")
+	flags.IntP("level", "L", 1, "directory depth of the per-directory metrics")
+	flags.Bool("dogstatsd", false, "tag the per-directory metrics with their dir, as understood by DogStatsD")
+	flags.StringArray("tag", nil, "with --dogstatsd, a key:value tag to add to every metric")
+	prog.AddCommand(&cmd)
+}
+
+type emitOptions struct {
+	addr      string
+	prefix    string
+	level     int
+	dogstatsd bool
+	tags      []string
+}
+
+// statsdPacketSize is the most bytes of metrics sent in each packet, to fit
+// within the MTU of most networks.
+const statsdPacketSize = 1432
+
+// statsdEscaper replaces the characters which separate the fields of the
+// StatsD line protocol, and of the elements of its metric names.
+var statsdEscaper = strings.NewReplacer(".", "_", "/", ".", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// dogstatsdTagEscaper replaces the characters which separate DogStatsD tags.
+var dogstatsdTagEscaper = strings.NewReplacer("|", "_", ",", "_", "\n", "_")
+
+// emitStatsd writes the build's timings to w, as packets of the StatsD line
+// protocol, returning the number of metrics written.
+func emitStatsd(w io.Writer, opt *options, eopt emitOptions) (int, error) {
+	var total time.Duration
+	byDir := map[string]time.Duration{}
+	for _, act := range opt.actions() {
+		total += act.Duration
+		if act.Package != "" {
+			byDir[treeDir(treePath(act.Package, act.Std), eopt.level)] += act.Duration
+		}
+	}
+
+	name := func(s string) string {
+		if eopt.prefix == "" {
+			return s
+		}
+		return eopt.prefix + "." + s
+	}
+	var lines []string
+	timing := func(metric string, d time.Duration, tags []string) {
+		line := fmt.Sprintf("%s:%d|ms", metric, d.Milliseconds())
+		if tags = append(tags, eopt.tags...); len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+		lines = append(lines, line)
+	}
+	timing(name("total"), total, nil)
+	timing(name("wall"), opt.store.wall(), nil)
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if eopt.dogstatsd {
+			timing(name("dir"), byDir[dir], []string{"dir:" + dogstatsdTagEscaper.Replace(dir)})
+		} else {
+			timing(name("dir."+statsdEscaper.Replace(dir)), byDir[dir], nil)
+		}
+	}
+
+	var packet bytes.Buffer
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := w.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			if err := send(); err != nil {
+				return 0, err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if err := send(); err != nil {
+		return 0, err
+	}
+	return len(lines), nil
+}
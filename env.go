@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix prefixes the names of the environment variables which give the
+// defaults of flags: ACTIONGRAPH_FILE for --file, ACTIONGRAPH_NO_STD for
+// --no-std, and so on. ACTIONGRAPH_FORMAT is ignored by the commands whose
+// --format doesn't accept it.
+const envPrefix = "ACTIONGRAPH_"
+
+// applyEnvDefaults sets the defaults of the flags of cmd and its subcommands
+// from the environment. Flags given on the command-line take precedence.
+func applyEnvDefaults(cmd *cobra.Command) error {
+	var err error
+	apply := func(f *pflag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if formats, ok := f.Annotations[formatsAnnotation]; ok && !oneOf(v, formats) {
+			// ACTIONGRAPH_FORMAT applies only to the commands accepting it.
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			// Setting a slice would have the command-line append to it.
+			err = sv.Replace(strings.Split(v, ","))
+		} else {
+			err = f.Value.Set(v)
+		}
+		if err != nil {
+			err = fmt.Errorf("parsing $%s: %w", envName(f.Name), err)
+			return
+		}
+		f.DefValue = v
+		delete(f.Annotations, cobra.BashCompOneRequiredFlag)
+	}
+	cmd.PersistentFlags().VisitAll(apply)
+	cmd.LocalNonPersistentFlags().VisitAll(apply)
+	for _, sub := range cmd.Commands() {
+		if err == nil {
+			err = applyEnvDefaults(sub)
+		}
+	}
+	return err
+}
+
+// oneOf returns whether s is among values.
+func oneOf(s string, values []string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
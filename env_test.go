@@ -0,0 +1,18 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestEnvFormat checks that $ACTIONGRAPH_FORMAT applies to the commands
+// accepting its format, and is ignored by the others.
+func TestEnvFormat(t *testing.T) {
+	t.Setenv("ACTIONGRAPH_FORMAT", "csv")
+	if out := runOutput(t, demoFile, ".txt", "top", "-n", "1"); !bytes.HasPrefix(out, []byte("id,")) {
+		t.Errorf("top didn't write csv:\n%s", out)
+	}
+	if out := runOutput(t, demoFile, ".txt", "tree", "-L", "1"); !bytes.Contains(out, []byte("(root)")) {
+		t.Errorf("tree didn't write its text:\n%s", out)
+	}
+}
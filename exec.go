@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/spf13/cobra"
+)
+
+func addExecCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "exec [-f compile.json] [--then REPORT] -- go build ./...",
+		Short:   "Run a go command with -debug-actiongraph and report on it",
+		Long: `Run a go command with -debug-actiongraph added, and then run a report on the
+actions it wrote, given by --then as the arguments to actiongraph:
+
+    actiongraph exec --then 'top -n 30' -- go build ./...
+
+The actions are written to the --file, or else to a file in the temporary
+directory which is kept for later reports. Its path is printed to stderr.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt := newOptions(cmd)
+
+			flags := cmd.Flags()
+			file, err := flags.GetString("file")
+			if err != nil {
+				return err
+			}
+			then, err := flags.GetString("then")
+			if err != nil {
+				return err
+			}
+
+			temp := file == "" || file == "-"
+			if temp {
+				f, err := os.CreateTemp("", "actiongraph-*.json")
+				if err != nil {
+					return err
+				}
+				f.Close()
+				file = f.Name()
+			}
+
+			if err := goDebugActiongraph(cmd, opt, args, file); err != nil {
+				if temp {
+					os.Remove(file)
+				}
+				return err
+			}
+			fmt.Fprintf(opt.stderr, "actiongraph: wrote %s\n", file)
+
+			if then == "" {
+				return nil
+			}
+			return run(append(splitCmd(then), "--file", file)...)
+		},
+	}
+	// Leave the go command's flags to it, even without --.
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().String("then", "top", "report to run on the actions, as arguments to actiongraph (empty for none)")
+	prog.AddCommand(&cmd)
+}
+
+// goDebugActiongraph runs the go command given by args, such as go build
+// ./..., having it write its actions to file.
+func goDebugActiongraph(cmd *cobra.Command, opt *options, args []string, file string) error {
+	// The flag belongs to the go subcommand, after its name.
+	goArgs := append([]string{args[1], "-debug-actiongraph=" + file}, args[2:]...)
+
+	c := exec.CommandContext(cmd.Context(), args[0], goArgs...)
+	c.Stdin, c.Stdout, c.Stderr = opt.stdin, opt.stdout, opt.stderr
+	if err := c.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &exitError{exitErr.ExitCode(), fmt.Errorf("%s %s: %w", args[0], args[1], err)}
+		}
+		return fmt.Errorf("%s %s: %w", args[0], args[1], err)
+	}
+	return nil
+}
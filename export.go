@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
+)
+
+func addExportCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "export [-f compile.json] --sqlite build.db",
+		Short:   "Export the actions to a SQLite database",
+		Long: `Append the build to a SQLite database, creating it if needed, so that the
+timings of many builds can be accumulated and queried with SQL.
+
+Each build is a row of the builds table, and its actions, their dependencies
+and the flags of their commands are rows of the actions, deps and flags tables,
+keyed by build_id. Durations are in seconds.
+
+The commit, branch and dirty state of the working tree which was built are
+given by flags, or found by running git with --git, and written to the git
+table.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			path, err := flags.GetString("sqlite")
+			if err != nil {
+				return err
+			}
+			git, err := gitFlags(cmd)
+			if err != nil {
+				return err
+			}
+			name, err := flags.GetString("name")
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				name = git.Commit
+			}
+			if name == "" {
+				name, err = flags.GetString("file")
+				if err != nil {
+					return err
+				}
+			}
+
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			id, err := exportSQLite(cmd.Context(), db, opt, name, git)
+			if err != nil {
+				return fmt.Errorf("exporting to %s: %w", path, err)
+			}
+			fmt.Fprintf(opt.stderr, "actiongraph: exported build %d to %s\n", id, path)
+			return db.Close()
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("sqlite", "", "SQLite database file to write to")
+	cmd.MarkFlagRequired("sqlite")
+	flags.String("name", "", "name of the build (default the commit, or else the --file path)")
+	addGitFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+// sqliteSchema creates the tables written by exportSQLite.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS builds (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	exported_at TEXT NOT NULL,
+	actions INTEGER NOT NULL,
+	total REAL NOT NULL,
+	wall REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS actions (
+	build_id INTEGER NOT NULL REFERENCES builds(id),
+	id INTEGER NOT NULL,
+	mode TEXT NOT NULL,
+	package TEXT NOT NULL,
+	objdir TEXT NOT NULL,
+	target TEXT NOT NULL,
+	priority INTEGER NOT NULL,
+	built TEXT NOT NULL,
+	action_id TEXT NOT NULL,
+	time_ready TEXT,
+	time_start TEXT,
+	time_done TEXT,
+	duration REAL NOT NULL,
+	percent REAL NOT NULL,
+	cpu REAL NOT NULL,
+	need_build INTEGER NOT NULL,
+	cached INTEGER NOT NULL,
+	cgo INTEGER NOT NULL,
+	std INTEGER NOT NULL,
+	race INTEGER NOT NULL,
+	msan INTEGER NOT NULL,
+	asan INTEGER NOT NULL,
+	tool TEXT NOT NULL,
+	flag_count INTEGER NOT NULL,
+	source_files INTEGER NOT NULL,
+	PRIMARY KEY (build_id, id)
+);
+CREATE TABLE IF NOT EXISTS deps (
+	build_id INTEGER NOT NULL REFERENCES builds(id),
+	action_id INTEGER NOT NULL,
+	dep_id INTEGER NOT NULL,
+	PRIMARY KEY (build_id, action_id, dep_id)
+);
+CREATE TABLE IF NOT EXISTS flags (
+	build_id INTEGER NOT NULL REFERENCES builds(id),
+	action_id INTEGER NOT NULL,
+	flag TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS git (
+	build_id INTEGER PRIMARY KEY REFERENCES builds(id),
+	commit_hash TEXT,
+	branch TEXT,
+	dirty INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS actions_package ON actions (package);
+`
+
+// exportSQLite writes the actions passing the filters to db as a new build,
+// returning its ID. The git state is written only if known.
+func exportSQLite(ctx context.Context, db *sql.DB, opt *options, name string, git gitInfo) (int64, error) {
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		return 0, fmt.Errorf("creating tables: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	actions := opt.actions()
+	var total time.Duration
+	for _, act := range actions {
+		total += act.Duration
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO builds (name, exported_at, actions, total, wall) VALUES (?, ?, ?, ?, ?)`,
+		name, sqliteTime(time.Now()), len(actions), total.Seconds(), opt.store.wall().Seconds())
+	if err != nil {
+		return 0, err
+	}
+	build, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if git != (gitInfo{}) {
+		_, err := tx.ExecContext(ctx, `INSERT INTO git VALUES (?, ?, ?, ?)`,
+			build, sqlNullString(git.Commit), sqlNullString(git.Branch), git.Dirty)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	insertAction, err := tx.PrepareContext(ctx, `INSERT INTO actions VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insertAction.Close()
+	insertDep, err := tx.PrepareContext(ctx, `INSERT INTO deps VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insertDep.Close()
+	insertFlag, err := tx.PrepareContext(ctx, `INSERT INTO flags VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insertFlag.Close()
+
+	for _, act := range actions {
+		_, err := insertAction.ExecContext(ctx,
+			build, act.ID, act.Mode, act.Package, act.Objdir, act.Target, act.Priority, act.Built, act.ActionID,
+			sqliteTime(act.TimeReady), sqliteTime(act.TimeStart), sqliteTime(act.TimeDone),
+			act.Duration.Seconds(), act.Percent, act.cpuTime().Seconds(),
+			act.NeedBuild, act.Cached, act.Cgo, act.Std, act.Race, act.Msan, act.Asan,
+			act.Tool, act.FlagCount, act.SourceFiles,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("action %d: %w", act.ID, err)
+		}
+		for _, dep := range act.Deps {
+			if _, err := insertDep.ExecContext(ctx, build, act.ID, dep); err != nil {
+				return 0, fmt.Errorf("action %d: %w", act.ID, err)
+			}
+		}
+		for _, flag := range act.Flags {
+			if _, err := insertFlag.ExecContext(ctx, build, act.ID, flag); err != nil {
+				return 0, fmt.Errorf("action %d: %w", act.ID, err)
+			}
+		}
+	}
+	return build, tx.Commit()
+}
+
+// sqlNullString returns nil for the empty string, to be written as NULL.
+func sqlNullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sqliteTime formats t for SQLite's date and time functions, or returns nil
+// when t is unset.
+func sqliteTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05.000000")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+)
+
+// exprOptions configures the expressions given to --filter and --define, which
+// are evaluated against each action.
+func exprOptions() []expr.Option {
+	return []expr.Option{
+		expr.Env(&action{}),
+
+		// expr doesn't divide durations, which we want for ratios like
+		// CmdUser/Duration.
+		expr.Function("divideDuration", func(params ...any) (any, error) {
+			d, ok := params[1].(time.Duration)
+			if !ok {
+				f, _ := toFloat(params[1])
+				return time.Duration(float64(params[0].(time.Duration)) / f), nil
+			}
+			n, _ := toFloat(params[0])
+			return n / float64(d), nil
+		},
+			new(func(time.Duration, time.Duration) float64),
+			new(func(int64, time.Duration) float64),
+			new(func(int, time.Duration) float64),
+			new(func(float64, time.Duration) float64),
+			new(func(time.Duration, int64) time.Duration),
+			new(func(time.Duration, int) time.Duration),
+			new(func(time.Duration, float64) time.Duration),
+		),
+		expr.Operator("/", "divideDuration"),
+	}
+}
+
+// filterActions evaluates the --filter expression against each of the
+// actions, returning a filter which keeps those it matched. The expression
+// can refer to any of the fields of the action, as in
+//
+//	Mode == "build" && Duration > duration("1s") && !Cached
+func filterActions(s *store, src string) (func(*action) bool, error) {
+	program, err := expr.Compile(src, append(exprOptions(), expr.AsBool())...)
+	if err != nil {
+		return nil, fmt.Errorf("compiling --filter: %w", err)
+	}
+
+	keep := make([]bool, len(s.actions))
+	for i := range s.actions {
+		out, err := expr.Run(program, &s.actions[i])
+		if err != nil {
+			return nil, fmt.Errorf("evaluating --filter on action %d: %w", s.actions[i].ID, err)
+		}
+		keep[i] = out.(bool)
+	}
+	return func(act *action) bool { return keep[act.ID] }, nil
+}
+
+// defineFields evaluates each --define NAME=EXPR for every action, storing
+// the results in the actions' Fields for use in templates, sorting and
+// filters. Later definitions can refer to earlier ones as Fields.NAME.
+func defineFields(s *store, defines []string) ([]string, error) {
+	names := make([]string, 0, len(defines))
+	for _, def := range defines {
+		name, src, ok := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !fieldNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("--define %q is not NAME=EXPR", def)
+		}
+		program, err := expr.Compile(src, exprOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("compiling --define %s: %w", name, err)
+		}
+		for i := range s.actions {
+			act := &s.actions[i]
+			out, err := expr.Run(program, act)
+			if err != nil {
+				return nil, fmt.Errorf("evaluating --define %s on action %d: %w", name, act.ID, err)
+			}
+			if act.Fields == nil {
+				act.Fields = make(map[string]any, len(defines))
+			}
+			act.Fields[name] = out
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+var fieldNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// byField orders the actions with the largest value of the defined field
+// first. Numbers are compared numerically and everything else as text.
+func byField(name string) func(a, b *action) bool {
+	return func(a, b *action) bool {
+		av, bv := a.Fields[name], b.Fields[name]
+		if af, ok := toFloat(av); ok {
+			if bf, ok := toFloat(bv); ok {
+				return af > bf
+			}
+		}
+		return fmt.Sprint(av) > fmt.Sprint(bv)
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case time.Duration:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// atomicFile is written alongside path and replaces any existing file there
+// only once committed, so that readers never see it partially written.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+// createAtomic creates the file to replace path with. It's given the mode of
+// any existing file at path, or else that of a new file, 0666 less the umask,
+// rather than the 0600 of os.CreateTemp.
+func createAtomic(path string) (*atomicFile, error) {
+	existing, err := os.Stat(path)
+	if err != nil {
+		existing = nil
+	}
+	prefix := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".")
+	for try := 0; ; try++ {
+		name := prefix + strconv.FormatUint(uint64(rand.Uint32()), 10)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) && try < 10000 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// The umask only applies to new files.
+		if existing != nil {
+			if err := f.Chmod(existing.Mode().Perm()); err != nil {
+				f.Close()
+				os.Remove(name)
+				return nil, err
+			}
+		}
+		return &atomicFile{File: f, path: path}, nil
+	}
+}
+
+// commit closes the file and moves it into place.
+func (f *atomicFile) commit() error {
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// abort closes and removes the file, leaving any existing file at path.
+func (f *atomicFile) abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// writeFileAtomic writes the file at path using write, replacing any existing
+// file only once the writing has succeeded.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addFlagMissesCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "flagmisses [-f merged.json] [-n limit]",
+		Short:   "List packages rebuilt only because their builds were given different flags",
+		Long: `List the packages which were compiled from the same sources with different
+flags, such as by the separate jobs of a CI pipeline merged by test --merge
+where one job used -race, and which would otherwise have shared a compile
+through the build cache:
+
+    actiongraph test --merge unit.json --merge race.json -f merged.json
+    actiongraph flagmisses -f merged.json
+
+A package's compiles are compared by the flags of the compiler, other than
+-c which only sets the compiler's concurrency. The flags used by most of its
+compiles are taken as the package's own, and the time of each compile using
+others is charged to the flags given to some but not all of them. Cached
+compiles ran no commands and so can't be compared, and compiles of different
+sources, such as with a package's test files, are left apart.
+
+The time lost to each of the differing flags follows the table when written
+as text, pointing to the configuration which would be worth making
+consistent.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, flagMissFormats)
+			if err != nil {
+				return err
+			}
+			return flagMisses(opt, limit, out)
+		},
+	}
+	cmd.Flags().IntP("limit", "n", 20, "number of packages to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var flagMissFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Cost", tpl: `{{ .Cost | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Misses", tpl: `{{ .Misses }}/{{ .Builds }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Differences", tpl: `{{ .Differences | join " " }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Cost", tpl: `{{ .Cost | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Cost", tpl: `{{ .Cost | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Total", tpl: `{{ .Duration | seconds }}`},
+		{name: "Misses", tpl: `{{ .Misses }}/{{ .Builds }}`},
+		{name: "Sets", tpl: `{{ .Sets }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Differences", tpl: `{{ .Differences | join " " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "builds", tpl: `{{ .Builds }}`},
+		{name: "misses", tpl: `{{ .Misses }}`},
+		{name: "sets", tpl: `{{ .Sets }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "cost", tpl: `{{ .Cost.Seconds | printf "%.3f" }}`},
+		{name: "differences", tpl: `{{ .Differences | join " " }}`},
+	},
+}
+
+// flagMiss is a package compiled from the same sources with different flags.
+type flagMiss struct {
+	Package     string
+	Builds      int           // Number of uncached compiles of the sources.
+	Misses      int           // Number of the compiles not using the most common flags.
+	Sets        int           // Number of different sets of flags used.
+	Duration    time.Duration // Of all of the compiles.
+	Cost        time.Duration // Of the compiles not using the most common flags.
+	Percent     float64       // Cost as a percentage of the total of all actions.
+	Differences []string      // Flags given to some but not all of the compiles.
+	IDs         []int         // Of the compiles not using the most common flags.
+}
+
+// flagMisses lists the packages compiled from the same sources with different
+// flags, by the time spent on the compiles not using their most common flags.
+// When written as text, the time is then totalled by the differing flags.
+func flagMisses(opt *options, limit int, out *rowWriter) error {
+	type key struct{ pkg, files string }
+	groups := make(map[key][]*action)
+	for _, act := range opt.actions() {
+		if act.Mode != "build" || act.Package == "" || act.Cached || act.Tool != "compile" {
+			continue
+		}
+		k := key{act.Package, compiledFiles(act)}
+		groups[k] = append(groups[k], act)
+	}
+
+	var rows []*flagMiss
+	var cost time.Duration
+	byFlag := make(map[string]time.Duration)
+	for k, acts := range groups {
+		// Group the compiles by their flags, in the order first seen.
+		var sets []string
+		bySet := make(map[string][]*action)
+		for _, act := range acts {
+			set := strings.Join(cacheFlags(act.Flags), " ")
+			if _, ok := bySet[set]; !ok {
+				sets = append(sets, set)
+			}
+			bySet[set] = append(bySet[set], act)
+		}
+		if len(sets) < 2 {
+			continue
+		}
+		common := sets[0]
+		for _, set := range sets[1:] {
+			if len(bySet[set]) > len(bySet[common]) {
+				common = set
+			}
+		}
+
+		m := &flagMiss{Package: k.pkg, Builds: len(acts), Sets: len(sets)}
+		inCommon := make(map[string]bool)
+		for _, f := range strings.Fields(common) {
+			inCommon[f] = true
+		}
+		count := make(map[string]int)
+		for _, set := range sets {
+			flags := strings.Fields(set)
+			var miss time.Duration
+			for _, act := range bySet[set] {
+				m.Duration += act.Duration
+				if set != common {
+					m.Misses++
+					miss += act.Duration
+					m.IDs = append(m.IDs, act.ID)
+				}
+			}
+			m.Cost += miss
+			for _, f := range flags {
+				count[f]++
+			}
+			if set == common {
+				continue
+			}
+			// Charge the misses to the flags which set them apart.
+			inSet := make(map[string]bool, len(flags))
+			for _, f := range flags {
+				inSet[f] = true
+				if !inCommon[f] {
+					byFlag[f] += miss
+				}
+			}
+			for f := range inCommon {
+				if !inSet[f] {
+					byFlag["no "+f] += miss
+				}
+			}
+		}
+		for f, n := range count {
+			if n < len(sets) {
+				m.Differences = append(m.Differences, f)
+			}
+		}
+		sort.Strings(m.Differences)
+		sort.Ints(m.IDs)
+		m.Percent = opt.store.percent(m.Cost)
+		cost += m.Cost
+		rows = append(rows, m)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Cost != rows[j].Cost {
+			return rows[i].Cost > rows[j].Cost
+		}
+		return rows[i].Package < rows[j].Package
+	})
+
+	for i, m := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if err := out.row(m); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+	fmt.Fprintf(opt.stdout, "%.3fs (%.2f%%) spent recompiling %d packages only because of their flags\n",
+		cost.Seconds(), opt.store.percent(cost), len(rows))
+
+	flags := make([]string, 0, len(byFlag))
+	for f := range byFlag {
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		if byFlag[flags[i]] != byFlag[flags[j]] {
+			return byFlag[flags[i]] > byFlag[flags[j]]
+		}
+		return flags[i] < flags[j]
+	})
+	for _, f := range flags {
+		fmt.Fprintf(opt.stdout, "  %s: %.3fs\n", f, byFlag[f].Seconds())
+	}
+	return nil
+}
+
+// cacheFlags returns the flags which would change the output of the compiler,
+// sorted, leaving out those like -c=4 which vary between machines without
+// doing so.
+func cacheFlags(flags []string) []string {
+	kept := make([]string, 0, len(flags))
+	for _, f := range flags {
+		if f == "-c" || strings.HasPrefix(f, "-c=") {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	sort.Strings(kept)
+	return kept
+}
+
+// compiledFiles returns the base names of the source files given to the
+// compiler by the action, sorted and joined by spaces.
+func compiledFiles(act *action) string {
+	var files []string
+	for _, c := range act.Commands {
+		if c.Tool != "compile" {
+			continue
+		}
+		for _, fn := range c.Files {
+			files = append(files, path.Base(fn))
+		}
+	}
+	sort.Strings(files)
+	return strings.Join(files, " ")
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// formatPreset describes the built-in layouts of a command's rows, selected
+// with --format instead of writing a --tpl. Each layout is a list of columns.
+type formatPreset struct {
+	table, short, wide []formatColumn // Aligned text tables. The table is used for md too.
+	columns            []formatColumn // Data for csv.
+}
+
+// formatColumn is a column of a layout, whose cells are produced by a
+// template. Text tables right-align columns unless left is set.
+type formatColumn struct {
+	name, tpl string
+	left      bool
+}
+
+var formatNames = []string{"table", "short", "wide", "csv", "json", "markdown"}
+
+// formatsAnnotation annotates the --format flag of each command with the
+// formats it accepts, as the commands accept different ones.
+const formatsAnnotation = "actiongraph_formats"
+
+// annotateFormats records the formats accepted by the --format flag in flags.
+func annotateFormats(flags *pflag.FlagSet, formats ...string) {
+	flags.SetAnnotation("format", formatsAnnotation, formats)
+}
+
+// addTplFlags adds the --tpl flag with its default template, and the
+// --tpl-file flag for reading it from a file instead.
+func addTplFlags(flags *pflag.FlagSet, tpl, usage string) {
+	flags.String("tpl", tpl, usage)
+	flags.String("tpl-file", "", "file to read the --tpl template from, which may span many lines and define other templates")
+}
+
+// tplFlag returns the template given by --tpl, or read from --tpl-file. A
+// single trailing newline in the file is removed, as each row is written on
+// its own line already.
+func tplFlag(flags *pflag.FlagSet) (string, error) {
+	path, err := flags.GetString("tpl-file")
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return flags.GetString("tpl")
+	}
+	if flags.Changed("tpl") {
+		return "", errors.New("--tpl and --tpl-file are mutually exclusive")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// addFormatFlags adds the --format flag for choosing how a command's rows are
+// written, and the --tpl flags for writing them with a template instead.
+func addFormatFlags(cmd *cobra.Command) {
+	addTplFlags(cmd.Flags(), "", "template for output, instead of a --format")
+	cmd.Flags().StringP("format", "o", "table", "preset output format: "+strings.Join(formatNames, ", ")+" (json writes an object per line)")
+	cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(formatNames, cobra.ShellCompDirectiveNoFileComp))
+	annotateFormats(cmd.Flags(), append(formatNames, "md")...)
+}
+
+// rowWriter writes a command's rows using either its --tpl or a --format
+// preset.
+type rowWriter struct {
+	w    io.Writer
+	tpl  *template.Template
+	rows int
+
+	columns []*template.Template
+	header  []string
+	table   [][]string // Cells of the text table, written by flush.
+	left    []bool     // Whether each column of the text table is left-aligned.
+	csv     *csv.Writer
+	md      bool
+	json    *json.Encoder
+	footer  string // Written beneath the text table.
+}
+
+func newRowWriter(cmd *cobra.Command, opt *options, preset formatPreset) (*rowWriter, error) {
+	flags := cmd.Flags()
+	format, err := flags.GetString("format")
+	if err != nil {
+		return nil, err
+	}
+
+	rw := &rowWriter{w: opt.stdout}
+	if n := opt.hiddenCached(); n > 0 {
+		rw.footer = fmt.Sprintf("%d cached actions hidden by --hide-cached", n)
+	}
+	parse := func(s string) (*template.Template, error) {
+		return template.New(cmd.Name()).Funcs(opt.funcs).Parse(s)
+	}
+	if flags.Changed("tpl") || flags.Changed("tpl-file") {
+		if flags.Changed("format") {
+			return nil, errors.New("--format and --tpl are mutually exclusive")
+		}
+		tplStr, err := tplFlag(flags)
+		if err != nil {
+			return nil, err
+		}
+		rw.tpl, err = parse(tplStr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing tpl: %w", err)
+		}
+		return rw, nil
+	}
+
+	var columns []formatColumn
+	switch format {
+	case "table":
+		columns = preset.table
+	case "short":
+		columns = preset.short
+	case "wide":
+		columns = preset.wide
+	case "csv":
+		columns = preset.columns
+		rw.csv = csv.NewWriter(opt.stdout)
+	case "markdown", "md":
+		// Markdown isn't shown on a terminal, so is never coloured.
+		columns = preset.table
+		rw.md = true
+		parse = func(s string) (*template.Template, error) {
+			return template.New(cmd.Name()).Funcs(opt.funcs).Funcs(template.FuncMap{"colordur": opt.funcs["seconds"]}).Parse(s)
+		}
+	case "json":
+		rw.json = json.NewEncoder(opt.stdout)
+	default:
+		return nil, fmt.Errorf("unknown --format %q: expected one of %s", format, strings.Join(formatNames, ", "))
+	}
+	for _, col := range columns {
+		tpl, err := parse(col.tpl)
+		if err != nil {
+			return nil, err
+		}
+		rw.columns = append(rw.columns, tpl)
+		rw.header = append(rw.header, col.name)
+		rw.left = append(rw.left, col.left)
+	}
+	if rw.csv == nil && !rw.md && rw.json == nil {
+		rw.table = [][]string{}
+	}
+	return rw, nil
+}
+
+// text reports whether the rows are written as plain text, which summaries
+// can follow without breaking the output.
+func (rw *rowWriter) text() bool {
+	return rw.tpl != nil || rw.table != nil
+}
+
+func (rw *rowWriter) row(v any) error {
+	defer func() { rw.rows++ }()
+	switch {
+	case rw.tpl != nil:
+		if err := rw.tpl.Execute(rw.w, v); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(rw.w)
+		return err
+	case rw.json != nil:
+		return rw.json.Encode(v)
+	}
+
+	cells := make([]string, len(rw.columns))
+	for i, col := range rw.columns {
+		var b strings.Builder
+		if err := col.Execute(&b, v); err != nil {
+			return err
+		}
+		cells[i] = b.String()
+	}
+	if rw.table != nil {
+		rw.table = append(rw.table, cells)
+		return nil
+	}
+	if rw.rows == 0 {
+		rw.writeHeader()
+	}
+	if rw.csv != nil {
+		return rw.csv.Write(cells)
+	}
+	for i := range cells {
+		cells[i] = strings.ReplaceAll(cells[i], "|", `\|`)
+	}
+	_, err := fmt.Fprintf(rw.w, "| %s |\n", strings.Join(cells, " | "))
+	return err
+}
+
+func (rw *rowWriter) writeHeader() {
+	switch {
+	case rw.csv != nil:
+		rw.csv.Write(rw.header)
+	case rw.md:
+		fmt.Fprintf(rw.w, "| %s |\n", strings.Join(rw.header, " | "))
+		for _, left := range rw.left {
+			if left {
+				fmt.Fprint(rw.w, "| --- ")
+			} else {
+				fmt.Fprint(rw.w, "| ---: ")
+			}
+		}
+		fmt.Fprintln(rw.w, "|")
+	}
+}
+
+func (rw *rowWriter) flush() error {
+	if rw.table != nil {
+		return rw.writeTable()
+	}
+	if rw.rows == 0 {
+		rw.writeHeader()
+	}
+	if rw.csv != nil {
+		rw.csv.Flush()
+		return rw.csv.Error()
+	}
+	return nil
+}
+
+// writeTable writes the text table with each column as wide as its widest
+// cell. The last column isn't padded, so that long package names don't widen
+// the table.
+func (rw *rowWriter) writeTable() error {
+	widths := make([]int, len(rw.columns))
+	for _, cells := range rw.table {
+		for i, cell := range cells {
+			if l := visibleLen(cell); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, cells := range rw.table {
+		b.Reset()
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			pad := strings.Repeat(" ", widths[i]-visibleLen(cell))
+			switch {
+			case !rw.left[i]:
+				b.WriteString(pad + cell)
+			case i < len(cells)-1:
+				b.WriteString(cell + pad)
+			default:
+				b.WriteString(cell)
+			}
+		}
+		if _, err := fmt.Fprintln(rw.w, strings.TrimRight(b.String(), " ")); err != nil {
+			return err
+		}
+	}
+	rw.table = rw.table[:0]
+	if rw.footer != "" {
+		if _, err := fmt.Fprintln(rw.w, rw.footer); err != nil {
+			return err
+		}
+		rw.footer = ""
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	txttpl "text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+// templateFuncs returns the functions available to --tpl templates. Those
+// taking a string, list or number take it last, so that it can be piped in:
+// {{ .Package | trimPrefix "github.com/" }}.
+func templateFuncs() txttpl.FuncMap {
+	return txttpl.FuncMap{
+		// Paths.
+		"base": filepath.Base,
+		"dir":  filepath.Dir,
+
+		// Formatting.
+		"seconds": func(d time.Duration) string {
+			return fmt.Sprintf("%.3fs", d.Seconds())
+		},
+		"human": humanDuration,
+		"ms": func(d time.Duration) string {
+			return fmt.Sprintf("%.0fms", float64(d)/float64(time.Millisecond))
+		},
+		"percent": func(v float64) string {
+			return fmt.Sprintf("%.2f%%", v)
+		},
+		"date": func(layout string, t time.Time) string {
+			return t.Format(layout)
+		},
+		"iso8601": func(t time.Time) string {
+			return t.Format("2006-01-02T15:04:05.000Z07:00")
+		},
+		"colordur": func(d time.Duration) string {
+			return fmt.Sprintf("%.3fs", d.Seconds())
+		},
+		"spark": func(v float64) string {
+			return sparks[level(v, len(sparks))]
+		},
+		"heat": func(v float64) string {
+			return heatShades[level(v, len(heatShades))]
+		},
+		"right": func(n int, s string) string {
+			if l := visibleLen(s); l < n {
+				return strings.Repeat(" ", n-l) + s
+			}
+			return s
+		},
+		"left": func(n int, s string) string {
+			if l := visibleLen(s); l < n {
+				return s + strings.Repeat(" ", n-l)
+			}
+			return s
+		},
+		"trunc": func(n int, s string) string {
+			if utf8.RuneCountInString(s) <= n {
+				return s
+			}
+			return string([]rune(s)[:n])
+		},
+
+		// Strings.
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"repeat":     func(n int, s string) string { return strings.Repeat(s, n) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+
+		// Arithmetic on any kind of number, including durations.
+		"add": func(a, b any) (float64, error) { return arith(a, b, func(a, b float64) float64 { return a + b }) },
+		"sub": func(a, b any) (float64, error) { return arith(a, b, func(a, b float64) float64 { return a - b }) },
+		"mul": func(a, b any) (float64, error) { return arith(a, b, func(a, b float64) float64 { return a * b }) },
+		"div": func(a, b any) (float64, error) { return arith(a, b, func(a, b float64) float64 { return a / b }) },
+		"min": func(a, b any) (float64, error) { return arith(a, b, math.Min) },
+		"max": func(a, b any) (float64, error) { return arith(a, b, math.Max) },
+	}
+}
+
+// sparks and heatShades are the characters shown by the spark and heat
+// template functions, from the smallest percentage to the largest.
+var (
+	sparks     = []string{"▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"}
+	heatShades = []string{"░", "▒", "▓", "█"}
+)
+
+// level returns which of n equal steps from 0 to 100 the percentage v is in.
+func level(v float64, n int) int {
+	i := int(v / 100 * float64(n))
+	if i < 0 || math.IsNaN(v) {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+func arith(a, b any, op func(a, b float64) float64) (float64, error) {
+	af, ok := toFloat(a)
+	if !ok {
+		return 0, fmt.Errorf("%v is not a number", a)
+	}
+	bf, ok := toFloat(b)
+	if !ok {
+		return 0, fmt.Errorf("%v is not a number", b)
+	}
+	return op(af, bf), nil
+}
+
+// buildFuncs configures the template functions which depend on the flags and
+// the build: seconds and colordur use the --precision, heat the --color, and
+// since gives the offset of a time from the start of the build.
+func buildFuncs(cmd *cobra.Command, opt *options) error {
+	precision, err := cmd.Flags().GetInt("precision")
+	if err != nil {
+		return err
+	}
+	if precision < 0 {
+		return errors.New("--precision must not be negative")
+	}
+	seconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', precision, 64) + "s"
+	}
+	opt.funcs["seconds"] = seconds
+
+	opt.funcs["colordur"], err = colorDuration(cmd, opt.stdout, seconds)
+	if err != nil {
+		return err
+	}
+	opt.funcs["heat"], err = heatCell(cmd, opt.stdout)
+	if err != nil {
+		return err
+	}
+
+	start := opt.store.start()
+	opt.funcs["since"] = func(t time.Time) time.Duration {
+		return t.Sub(start)
+	}
+	return nil
+}
+
+// humanDuration formats d to a precision suited to its size: 850ms, 4.5s,
+// 1m32s.
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Millisecond).String()
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// gitInfo describes the state of the git working tree which was built.
+type gitInfo struct {
+	Commit string `json:",omitempty"`
+	Branch string `json:",omitempty"`
+	Dirty  bool   `json:",omitempty"` // Whether there were uncommitted changes.
+}
+
+// addGitFlags adds the flags describing the commit which was built, which
+// commands can attach to their output.
+func addGitFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.Bool("git", false, "find the commit, branch and dirty state by running git in the current directory")
+	flags.String("commit", "", "commit which was built")
+	flags.String("branch", "", "branch which was built")
+	flags.Bool("dirty", false, "whether the working tree which was built had uncommitted changes")
+}
+
+// gitFlags returns the git state given by the flags added by addGitFlags,
+// asking git for any not given when --git is set.
+func gitFlags(cmd *cobra.Command) (gitInfo, error) {
+	flags := cmd.Flags()
+	var info gitInfo
+	if useGit, err := flags.GetBool("git"); err != nil {
+		return info, err
+	} else if useGit {
+		info, err = readGit(cmd.Context())
+		if err != nil {
+			return info, err
+		}
+	}
+
+	if flags.Changed("commit") || info.Commit == "" {
+		commit, err := flags.GetString("commit")
+		if err != nil {
+			return info, err
+		}
+		if commit != "" {
+			info.Commit = commit
+		}
+	}
+	if flags.Changed("branch") || info.Branch == "" {
+		branch, err := flags.GetString("branch")
+		if err != nil {
+			return info, err
+		}
+		if branch != "" {
+			info.Branch = branch
+		}
+	}
+	if flags.Changed("dirty") {
+		dirty, err := flags.GetBool("dirty")
+		if err != nil {
+			return info, err
+		}
+		info.Dirty = dirty
+	}
+	return info, nil
+}
+
+// readGit asks git for the state of the working tree in the current
+// directory. The branch is left empty when HEAD is detached, as it often is
+// in CI.
+func readGit(ctx context.Context) (gitInfo, error) {
+	var info gitInfo
+	var err error
+	info.Commit, err = runGit(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return info, err
+	}
+	info.Branch, err = runGit(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return info, err
+	}
+	if info.Branch == "HEAD" {
+		info.Branch = ""
+	}
+	status, err := runGit(ctx, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return info, err
+	}
+	info.Dirty = status != ""
+	return info, nil
+}
+
+func runGit(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// pkgGlob matches package paths against a pattern of slash-separated
+// segments. Within a segment the syntax of path.Match applies, while a "**"
+// segment matches any number of segments and a trailing "/..." matches the
+// package and everything beneath it, as with the go command.
+type pkgGlob struct {
+	pattern string
+	segs    []string
+}
+
+func parsePkgGlob(pattern string) (pkgGlob, error) {
+	p := strings.Trim(pattern, "/")
+	if p == "..." {
+		p = "**"
+	} else if strings.HasSuffix(p, "/...") {
+		p = strings.TrimSuffix(p, "...") + "**"
+	}
+	segs := strings.Split(p, "/")
+	for _, seg := range segs {
+		if _, err := path.Match(seg, ""); err != nil {
+			return pkgGlob{}, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+	}
+	return pkgGlob{pattern: pattern, segs: segs}, nil
+}
+
+func parsePkgGlobs(patterns []string) ([]pkgGlob, error) {
+	globs := make([]pkgGlob, len(patterns))
+	for i, p := range patterns {
+		var err error
+		globs[i], err = parsePkgGlob(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return globs, nil
+}
+
+func (g pkgGlob) String() string {
+	return g.pattern
+}
+
+// Match reports whether pkg is matched by the pattern in its entirety.
+func (g pkgGlob) Match(pkg string) bool {
+	return matchSegs(g.segs, strings.Split(pkg, "/"), false)
+}
+
+// Within reports whether pkg or any of its parent directories are matched by
+// the pattern, i.e. whether pkg is within a matching subtree.
+func (g pkgGlob) Within(pkg string) bool {
+	return matchSegs(g.segs, strings.Split(pkg, "/"), true)
+}
+
+func matchSegs(pat, segs []string, prefix bool) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegs(pat[1:], segs[i:], prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return prefix || len(segs) == 0
+}
+
+// matchAny reports whether any of globs match pkg or one of its parents.
+func matchAny(globs []pkgGlob, pkg string) bool {
+	for _, g := range globs {
+		if g.Within(pkg) {
+			return true
+		}
+	}
+	return false
+}
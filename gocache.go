@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addGoCacheCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "gocache [-f compile.json] [-n limit] [--gocache DIR]",
+		Short:   "Find the actions' entries in the build cache",
+		Long: `Look up the actions' entries in the go command's build cache, by their
+action IDs, to see why they were built rather than taken from the cache. Each
+action is listed, slowest first, with the status of its entry:
+
+  hit      the action was cached, and its entry is still in the cache
+  written  the action ran, and wrote its entry: there was none before, as
+           when the go command had trimmed it, having gone unused for 5 days,
+           or the action had never been built with these inputs
+  stale    the action ran although its entry predates the build, as when its
+           output had been removed, or the build was given -a
+  missing  there is no entry: the cache was trimmed since, or the build used
+           another GOCACHE, as on another machine
+
+The cache is found by go env GOCACHE, unless given by --gocache. Caches kept
+by a GOCACHEPROG aren't read.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			dir, err := flags.GetString("gocache")
+			if err != nil {
+				return err
+			}
+			if dir == "" {
+				dir, err = goEnvGOCACHE(cmd.Context())
+				if err != nil {
+					return err
+				}
+			}
+
+			out, err := newRowWriter(cmd, opt, goCacheFormats)
+			if err != nil {
+				return err
+			}
+			return goCache(opt, dir, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of actions to show")
+	flags.String("gocache", "", "build cache `directory` (default from go env GOCACHE)")
+	cmd.MarkFlagDirname("gocache")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var goCacheFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Status", tpl: `{{ .Status }}`, left: true},
+		{name: "Unused", tpl: `{{ if .Found }}{{ .Unused | human }}{{ end }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Status", tpl: `{{ .Status }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "ID", tpl: `{{ .ID }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Status", tpl: `{{ .Status }}`, left: true},
+		{name: "Written", tpl: `{{ if .Found }}{{ .Written | iso8601 }}{{ end }}`},
+		{name: "Unused", tpl: `{{ if .Found }}{{ .Unused | human }}{{ end }}`},
+		{name: "Size", tpl: `{{ if .Found }}{{ .Size }} bytes{{ end }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Entry", tpl: `{{ .Entry }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "id", tpl: `{{ .ID }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "cached", tpl: `{{ .Cached }}`},
+		{name: "status", tpl: `{{ .Status }}`},
+		{name: "written", tpl: `{{ if .Found }}{{ .Written | iso8601 }}{{ end }}`},
+		{name: "unused", tpl: `{{ if .Found }}{{ .Unused.Seconds | printf "%.0f" }}{{ end }}`},
+		{name: "size", tpl: `{{ .Size }}`},
+		{name: "entry", tpl: `{{ .Entry }}`},
+	},
+}
+
+// cacheEntry is an action with its entry in the build cache.
+type cacheEntry struct {
+	ID       int
+	Mode     string
+	Package  string
+	Duration time.Duration
+	Cached   bool
+	Status   string        // hit, written, stale or missing.
+	Found    bool          // Whether the entry was found.
+	Entry    string        // Path of the entry's file.
+	Written  time.Time     // When the entry was written.
+	Unused   time.Duration // Since the entry was last used, by its file's modification time.
+	Size     int64         // Of the output recorded by the entry.
+}
+
+// goEnvGOCACHE asks the go command for its build cache directory.
+func goEnvGOCACHE(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "go", "env", "GOCACHE").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env GOCACHE: %w", err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" || dir == "off" {
+		return "", errors.New("go env GOCACHE: the build cache is off")
+	}
+	return dir, nil
+}
+
+// cacheEntryPath returns the prefix of the path of the entry of the action ID
+// in the cache. The go command writes the first bytes of the ID's hash as the
+// action ID, but names the entry with the whole of it.
+func cacheEntryPath(dir, actionID string) (string, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(actionID)
+	if err != nil || len(b) == 0 {
+		return "", false
+	}
+	h := hex.EncodeToString(b)
+	return filepath.Join(dir, h[:2], h), true
+}
+
+// readCacheEntry reads the entry of the build cache at path, which is of the
+// form "v1 <action ID> <output ID> <size> <time in nanoseconds>".
+func readCacheEntry(path string) (size int64, written time.Time, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	f := strings.Fields(string(b))
+	if len(f) != 5 || f[0] != "v1" {
+		return 0, time.Time{}, fmt.Errorf("%s: not a cache entry", path)
+	}
+	size, err = strconv.ParseInt(f[3], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	ns, err := strconv.ParseInt(f[4], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return size, time.Unix(0, ns), nil
+}
+
+// goCache lists the actions with their entries in the build cache, slowest
+// first, followed by the number with each status and when the cache was last
+// trimmed when written as text.
+func goCache(opt *options, dir string, limit int, out *rowWriter) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	now := time.Now()
+	start := opt.store.start()
+
+	var rows []*cacheEntry
+	counts := make(map[string]int)
+	for _, act := range opt.sorted(byDuration) {
+		prefix, ok := cacheEntryPath(dir, act.ActionID)
+		if !ok {
+			continue
+		}
+		e := &cacheEntry{
+			ID:       act.ID,
+			Mode:     act.Mode,
+			Package:  act.Package,
+			Duration: act.Duration,
+			Cached:   act.Cached,
+			Status:   "missing",
+		}
+		if matches, _ := filepath.Glob(prefix + "*-a"); len(matches) == 1 {
+			size, written, err := readCacheEntry(matches[0])
+			if err != nil {
+				return err
+			}
+			fi, err := os.Stat(matches[0])
+			if err != nil {
+				return err
+			}
+			e.Found, e.Entry, e.Size, e.Written, e.Unused = true, matches[0], size, written, now.Sub(fi.ModTime())
+			switch {
+			case act.Cached:
+				e.Status = "hit"
+			case !written.Before(start):
+				e.Status = "written"
+			default:
+				e.Status = "stale"
+			}
+		}
+		counts[e.Status]++
+		rows = append(rows, e)
+	}
+	if len(rows) == 0 {
+		return errors.New("none of the actions have action IDs")
+	}
+
+	for i, e := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if err := out.row(e); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+	fmt.Fprintf(opt.stdout, "%d hit, %d written, %d stale, %d missing of %d actions in %s\n",
+		counts["hit"], counts["written"], counts["stale"], counts["missing"], len(rows), dir)
+	if b, err := os.ReadFile(filepath.Join(dir, "trim.txt")); err == nil {
+		if sec, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err == nil {
+			fmt.Fprintf(opt.stdout, "last trimmed %s, of the entries unused for 5 days\n", time.Unix(sec, 0).Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// goListPackage holds the fields of `go list -json` which are joined onto
+// actions by --enrich-golist.
+type goListPackage struct {
+	ImportPath string
+	Dir        string
+	ForTest    string
+	GoFiles    []string
+	CgoFiles   []string
+	Imports    []string
+	Module     *struct {
+		Path string
+	}
+}
+
+// goListInfo is the package metadata joined onto an action from go list.
+type goListInfo struct {
+	Listed      bool   // Whether go list described the action's package.
+	GoFiles     int    // Number of Go files in the package, including cgo files.
+	Module      string // Path of the module containing the package.
+	Dir         string // Directory containing the package's sources.
+	ForTest     string // Package being tested, for test variants.
+	TestVariant bool
+	PerFile     time.Duration // Duration per Go file.
+	Imports     []string      // Packages imported by the package's own files, not its tests.
+}
+
+// runGoList describes the packages in actions using `go list -json` in the
+// current directory.
+func runGoList(ctx context.Context, actions []action) (io.Reader, error) {
+	pkgs := make([]string, 0, len(actions))
+	seen := make(map[string]bool)
+	test := false
+	for _, act := range actions {
+		pkg, variant, _ := strings.Cut(act.Package, " ")
+		if pkg == "" || seen[pkg] {
+			continue
+		}
+		test = test || variant != "" || strings.HasSuffix(pkg, ".test")
+		if strings.HasSuffix(pkg, ".test") {
+			continue
+		}
+		seen[pkg] = true
+		pkgs = append(pkgs, pkg)
+	}
+
+	args := []string{"list", "-e", "-json=ImportPath,Dir,ForTest,GoFiles,CgoFiles,Imports,Module"}
+	if test {
+		args = append(args, "-test")
+	}
+	cmd := exec.CommandContext(ctx, "go", append(args, pkgs...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("go list: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+	return strings.NewReader(string(out)), nil
+}
+
+// decodeGoList reads the stream of JSON objects written by `go list -json`.
+func decodeGoList(r io.Reader) (map[string]goListPackage, error) {
+	pkgs := make(map[string]goListPackage)
+	dec := json.NewDecoder(r)
+	for {
+		var p goListPackage
+		err := dec.Decode(&p)
+		if err == io.EOF {
+			return pkgs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding go list output: %w", err)
+		}
+		pkgs[p.ImportPath] = p
+	}
+}
+
+// enrich joins the go list metadata onto the actions with the same package.
+func (s *store) enrich(pkgs map[string]goListPackage) {
+	for i := range s.actions {
+		act := &s.actions[i]
+		p, ok := pkgs[act.Package]
+		if !ok {
+			continue
+		}
+		act.Listed = true
+		act.GoFiles = len(p.GoFiles) + len(p.CgoFiles)
+		act.Dir = p.Dir
+		act.ForTest = p.ForTest
+		act.TestVariant = p.ForTest != ""
+		act.Imports = p.Imports
+		if p.Module != nil {
+			act.Module = p.Module.Path
+		}
+		if act.GoFiles > 0 {
+			act.PerFile = act.Duration / time.Duration(act.GoFiles)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addTestCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "test [-f tests.json] [--merge FILE]... [-n limit] -- [go test flags] [packages]",
+		Short:   "Run go test with -debug-actiongraph and list the slowest test binaries",
+		Long: `Run go test with -debug-actiongraph added, and list the test binaries whose
+compiling and linking took longest. A test binary is charged for the link and
+for the actions which only it needed; the actions shared between test binaries
+are totalled beneath the table.
+
+Each go command writes its own file, so the actions of earlier invocations,
+such as a CI job's separate runs, can be merged in with --merge. Without go
+test arguments, only the merged files are reported:
+
+    actiongraph test --merge unit.json --merge integration.json
+
+The merged actions are written to the --file, or else to a file in the
+temporary directory which is kept for later reports. Its path is printed to
+stderr.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt := newOptions(cmd)
+
+			flags := cmd.Flags()
+			file, err := flags.GetString("file")
+			if err != nil {
+				return err
+			}
+			files, err := flags.GetStringArray("merge")
+			if err != nil {
+				return err
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 && len(files) == 0 {
+				return errors.New("nothing to report: give arguments for go test, or files to --merge")
+			}
+
+			// Failing tests still leave the build to report on, so their
+			// error is returned only once we're done.
+			var testErr error
+			if len(args) > 0 {
+				f, err := os.CreateTemp("", "actiongraph-*.json")
+				if err != nil {
+					return err
+				}
+				f.Close()
+				defer os.Remove(f.Name())
+
+				testErr = goDebugActiongraph(cmd, opt, append([]string{"go", "test"}, args...), f.Name())
+				var exit *exitError
+				if testErr != nil && !errors.As(testErr, &exit) {
+					return testErr
+				}
+				files = append(files, f.Name())
+			}
+
+			if file == "" || file == "-" {
+				f, err := os.CreateTemp("", "actiongraph-*.json")
+				if err != nil {
+					return err
+				}
+				f.Close()
+				file = f.Name()
+			}
+			if err := writeFileAtomic(file, func(w io.Writer) error { return mergeActionFiles(w, files) }); err != nil {
+				return errors.Join(testErr, err)
+			}
+			fmt.Fprintf(opt.stderr, "actiongraph: wrote %s\n", file)
+
+			// Report on the merged file as any other command would.
+			if err := flags.Set("file", file); err != nil {
+				return err
+			}
+			opt, err = loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+			out, err := newRowWriter(cmd, opt, testFormats)
+			if err != nil {
+				return err
+			}
+			if err := testBinaries(opt, limit, out); err != nil {
+				return err
+			}
+			return testErr
+		},
+	}
+	// Leave the go command's flags to it, even without --.
+	cmd.Flags().SetInterspersed(false)
+	flags := cmd.Flags()
+	flags.StringArray("merge", nil, "actiongraph file of an earlier go command to report on too")
+	flags.IntP("limit", "n", 20, "number of slowest test binaries to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var testFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Total", tpl: `{{ .Total | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Build", tpl: `{{ .Build | seconds }}`},
+		{name: "Link", tpl: `{{ .Link | seconds }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Total", tpl: `{{ .Total | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Total", tpl: `{{ .Total | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Build", tpl: `{{ .Build | seconds }}`},
+		{name: "Actions", tpl: `{{ .Actions }}`},
+		{name: "Link", tpl: `{{ .Link | seconds }}`},
+		{name: "Vet", tpl: `{{ .Vet | seconds }}`},
+		{name: "Run", tpl: `{{ .Run | seconds }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "total", tpl: `{{ .Total.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "build", tpl: `{{ .Build.Seconds | printf "%.3f" }}`},
+		{name: "actions", tpl: `{{ .Actions }}`},
+		{name: "link", tpl: `{{ .Link.Seconds | printf "%.3f" }}`},
+		{name: "vet", tpl: `{{ .Vet.Seconds | printf "%.3f" }}`},
+		{name: "run", tpl: `{{ .Run.Seconds | printf "%.3f" }}`},
+	},
+}
+
+// testBinary is the time spent producing and running the test binary of a
+// package.
+type testBinary struct {
+	Package string
+	Build   time.Duration // Time of the actions which only this test binary needed.
+	Actions int           // Number of the actions which only this test binary needed.
+	Link    time.Duration
+	Total   time.Duration // Build and Link.
+	Percent float64       // Total as a percentage of the duration of all actions.
+	Vet     time.Duration
+	Run     time.Duration
+}
+
+// testBinaries lists the test binaries which took longest to build, followed
+// by the time shared between them when written as text.
+func testBinaries(opt *options, limit int, out *rowWriter) error {
+	actions := opt.store.actions
+	bins := make(map[string]*testBinary)
+	bin := func(pkg string) *testBinary {
+		b, ok := bins[pkg]
+		if !ok {
+			b = &testBinary{Package: pkg}
+			bins[pkg] = b
+		}
+		return b
+	}
+
+	// Count the test binaries needing each action.
+	needed := make([]int, len(actions))
+	reach := make(map[*action][]int)
+	for i := range actions {
+		act := &actions[i]
+		if act.Mode != "link" || !strings.HasSuffix(act.Package, ".test") {
+			continue
+		}
+		deps := dependencies(actions, act.ID)
+		for _, id := range deps {
+			needed[id]++
+		}
+		reach[act] = deps
+	}
+
+	for link, deps := range reach {
+		b := bin(strings.TrimSuffix(link.Package, ".test"))
+		if opt.include(link) {
+			b.Link += link.Duration
+		}
+		for _, id := range deps {
+			if act := &actions[id]; needed[id] == 1 && opt.include(act) {
+				b.Build += act.Duration
+				b.Actions++
+			}
+		}
+	}
+	var shared time.Duration
+	for i := range actions {
+		act := &actions[i]
+		if !opt.include(act) {
+			continue
+		}
+		switch {
+		case needed[i] > 1:
+			shared += act.Duration
+		case act.Mode == "vet":
+			if b, ok := bins[act.Package]; ok {
+				b.Vet += act.Duration
+			}
+		case act.Mode == "test run":
+			if b, ok := bins[act.Package]; ok {
+				b.Run += act.Duration
+			}
+		}
+	}
+
+	rows := make([]*testBinary, 0, len(bins))
+	var total time.Duration
+	for _, b := range bins {
+		b.Total = b.Build + b.Link
+		b.Percent = opt.store.percent(b.Total)
+		total += b.Total
+		rows = append(rows, b)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Total != rows[j].Total {
+			return rows[i].Total > rows[j].Total
+		}
+		return rows[i].Package < rows[j].Package
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	for _, b := range rows {
+		if err := out.row(b); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	fmt.Fprintf(opt.stdout, "%.3fs  %.2f%%  total of %d test binaries\n",
+		total.Seconds(), opt.store.percent(total), len(bins))
+	fmt.Fprintf(opt.stdout, "%.3fs  %.2f%%  shared between test binaries\n",
+		shared.Seconds(), opt.store.percent(shared))
+	return nil
+}
+
+// dependencies returns the IDs of the actions which the action id depends on,
+// directly or indirectly.
+func dependencies(actions []action, id int) []int {
+	seen := make(map[int]bool)
+	var deps []int
+	stack := append([]int(nil), actions[id].Deps...)
+	for len(stack) > 0 {
+		dep := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[dep] {
+			continue
+		}
+		seen[dep] = true
+		deps = append(deps, dep)
+		stack = append(stack, actions[dep].Deps...)
+	}
+	return deps
+}
+
+// mergeActionFiles writes the actions of each of the actiongraph files as a
+// single graph, renumbering their IDs to follow on from the previous file's.
+// The actions are otherwise written as they were read.
+func mergeActionFiles(w io.Writer, files []string) error {
+	var merged []map[string]any
+	for _, fn := range files {
+		f, err := openFile(fn)
+		if err != nil {
+			return err
+		}
+		var actions []map[string]any
+		dec := json.NewDecoder(f)
+		dec.UseNumber()
+		err = dec.Decode(&actions)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: decoding input: %w", fn, err)
+		}
+
+		offset := len(merged)
+		renumber := func(v any) (json.Number, error) {
+			n, ok := v.(json.Number)
+			if !ok {
+				return "", fmt.Errorf("%s: action ID %v is not a number", fn, v)
+			}
+			id, err := n.Int64()
+			if err != nil {
+				return "", fmt.Errorf("%s: action ID %v: %w", fn, v, err)
+			}
+			return json.Number(fmt.Sprint(int(id) + offset)), nil
+		}
+		for _, act := range actions {
+			if act["ID"], err = renumber(act["ID"]); err != nil {
+				return err
+			}
+			deps, _ := act["Deps"].([]any)
+			for i := range deps {
+				if deps[i], err = renumber(deps[i]); err != nil {
+					return err
+				}
+			}
+		}
+		merged = append(merged, actions...)
+	}
+	return json.NewEncoder(w).Encode(merged)
+}
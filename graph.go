@@ -1,17 +1,25 @@
 package main
 
 import (
+	_ "embed"
 	"errors"
 	"fmt"
+	"html"
+	htmltpl "html/template"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
 )
 
 func addGraphCommand(prog *cobra.Command) {
 	cmd := cobra.Command{
 		GroupID: "actiongraph",
-		Use:     "graph [-f compile.json] [--why PKG]",
+		Use:     "graph [-f compile.json] [--why PKG [--depth N] | --top N]",
 		Short:   "Graphviz visaualisation of the build steps",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			opt, err := loadOptions(cmd)
@@ -19,20 +27,115 @@ func addGraphCommand(prog *cobra.Command) {
 				return err
 			}
 
-			why, err := cmd.Flags().GetString("why")
+			flags := cmd.Flags()
+			why, err := flags.GetString("why")
 			if err != nil {
 				return err
 			}
+			format, err := flags.GetString("format")
+			if err != nil {
+				return err
+			}
+			if format != "dot" && format != "html" {
+				return fmt.Errorf("unknown --format %q: expected dot or html", format)
+			}
+			depth, err := flags.GetInt("depth")
+			if err != nil {
+				return err
+			}
+			from, err := flags.GetString("depth-from")
+			if err != nil {
+				return err
+			}
+			top, err := flags.GetInt("top")
+			if err != nil {
+				return err
+			}
+			shortest, err := flags.GetBool("shortest")
+			if err != nil {
+				return err
+			}
+			legend, err := flags.GetBool("legend")
+			if err != nil {
+				return err
+			}
+			title, err := flags.GetString("title")
+			if err != nil {
+				return err
+			}
+			metadata, err := flags.GetBool("metadata")
+			if err != nil {
+				return err
+			}
+			switch {
+			case shortest && why == "":
+				return errors.New("--shortest requires --why")
+			case from != "target" && from != "root":
+				return fmt.Errorf("unknown --depth-from %q: expected target or root", from)
+			case why != "" && top > 0:
+				return errors.New("--why and --top are mutually exclusive")
+			case depth >= 0 && from == "target" && why == "":
+				return errors.New("--depth counts the hops from the --why package, unless --depth-from root")
+			}
 
-			return graph(opt, why)
+			return graph(opt, graphOptions{
+				why:      why,
+				top:      top,
+				shortest: shortest,
+				depth:    depth,
+				fromRoot: from == "root",
+				format:   format,
+				legend:   legend,
+				title:    title,
+				metadata: metadata,
+			})
 		},
 	}
 	cmd.Flags().String("why", "", "show only paths to the given package")
+	cmd.Flags().Bool("shortest", false, "with --why, show only the shortest path to the package")
+	cmd.Flags().Int("top", 0, "show only the slowest actions, with the shortest paths to them from the root")
+	cmd.Flags().Int("depth", -1, "show only the actions this many dependency hops from the --why package (-ve for unlimited)")
+	cmd.Flags().String("depth-from", "target", "count the --depth from the target package, or from the root of the graph")
+	cmd.Flags().StringP("format", "o", "dot", "output format: dot, or html for a page exploring the graph from its root")
+	annotateFormats(cmd.Flags(), "dot", "html")
+	cmd.Flags().Bool("legend", false, "colour the actions by their share of the time shown and shape them by mode, with a legend explaining both")
+	cmd.Flags().String("title", "", "title of the graph, shown with the number of actions, their time and when the build started")
+	cmd.Flags().Bool("metadata", false, "record the file read and the command line in the graph")
+	cmd.RegisterFlagCompletionFunc("why", completePackages(false))
 	prog.AddCommand(&cmd)
 }
 
-func graph(opt *options, why string) error {
-	actions := opt.actions
+type graphOptions struct {
+	// why is the package whose paths from the root are shown, or only the
+	// shortest of them if shortest is set.
+	why      string
+	shortest bool
+
+	// top is the number of the slowest actions shown, with the shortest
+	// path to each of them from the roots.
+	top int
+
+	// depth, if not negative, is the largest number of hops from the why
+	// package, or from the root if fromRoot is set, of the actions shown.
+	depth    int
+	fromRoot bool
+
+	// format is either "dot" or "html".
+	format string
+
+	// legend colours and shapes the actions, explaining both in a legend.
+	legend bool
+
+	// title, if set, heads the graph, followed by a summary of the actions
+	// shown. With metadata, the file read and the command line are recorded
+	// too.
+	title    string
+	metadata bool
+}
+
+func graph(opt *options, gopt graphOptions) error {
+	actions := opt.store.actions
+	why, depth := gopt.why, gopt.depth
 
 	// show is a shortcut set of actions with Deps leading to the destination.
 	show := make([]int, len(actions))
@@ -61,13 +164,39 @@ func graph(opt *options, why string) error {
 		}
 	}
 
-	if shown == 0 {
+	target := -1
+	if shown > 0 {
+		target = slices.Index(show, follow)
+	}
+
+	// slowest are the actions chosen by --top, drawn in bold.
+	slowest := make(map[int]bool, gopt.top)
+	if gopt.top > 0 {
+		for _, act := range opt.sorted(byDuration) {
+			if len(slowest) == gopt.top {
+				break
+			}
+			if show[act.ID] != avoid {
+				slowest[act.ID] = true
+			}
+		}
+		shortestPaths(show, slowest, actions)
+	} else if shown == 0 {
 		// If there are no specific nodes we want, show them all.
 		for i, g := range show {
 			if g != avoid {
 				show[i] = follow
 			}
 		}
+	} else if gopt.shortest {
+		// Follow the imports, since the links depend on every package.
+		g := newImportGraph(opt.store)
+		for i := range show {
+			show[i] = avoid
+		}
+		for _, n := range g.shortestPath(g.roots(), target) {
+			show[n] = follow
+		}
 	} else if shown > 0 {
 		// Find the first build step.
 		start := -1
@@ -85,26 +214,306 @@ func graph(opt *options, why string) error {
 		pathfind(start, show, func(n int) []int { return actions[n].Deps })
 	}
 
+	if depth >= 0 {
+		if gopt.fromRoot {
+			limitDepth(show, rootsOf(show, actions), depth, func(n int) []int { return actions[n].Deps })
+		} else {
+			limitDepth(show, []int{target}, depth, opt.store.dependents.of)
+		}
+	}
+
+	info := newGraphInfo(opt, gopt, show)
+	if gopt.format == "html" {
+		return graphHTML(opt, gopt, info, show)
+	}
+
 	fmt.Fprintln(opt.stdout, "digraph {")
+	if gopt.metadata {
+		fmt.Fprintf(opt.stdout, "comment=%s;\n", dotString(info.Metadata))
+	}
+	if gopt.title != "" {
+		label := `<FONT POINT-SIZE="28">` + html.EscapeString(gopt.title) + `</FONT><BR/><FONT POINT-SIZE="14">` + html.EscapeString(info.Summary) + `</FONT>`
+		if gopt.metadata {
+			label += `<BR/><FONT POINT-SIZE="10">` + html.EscapeString(info.Metadata) + `</FONT>`
+		}
+		fmt.Fprintf(opt.stdout, "label=<%s>; labelloc=t;\n", label)
+	}
 	for i, g := range show {
 		if g != follow {
 			continue
 		}
 		act := actions[i]
-		fmt.Fprintf(opt.stdout, "%d [label=<%s>; shape=box];\n", i, "<FONT POINT-SIZE=\"12\">"+filepath.Dir(act.Package)+"</FONT><BR/><FONT POINT-SIZE=\"22\">"+filepath.Base(act.Package)+"</FONT><BR/>"+act.Mode+" "+act.TimeDone.Sub(act.TimeStart).String())
+		shape, attrs := "box", ""
+		var styles []string
+		if gopt.legend {
+			shape = modeShape(act.Mode)
+			styles = append(styles, "filled")
+			attrs += fmt.Sprintf("; fillcolor=%q", heatColor(act.Duration, info.total))
+		}
+		if slowest[i] {
+			styles = append(styles, "bold")
+		}
+		if len(styles) > 0 {
+			attrs += "; style=" + strings.Join(styles, ",")
+		}
+		fmt.Fprintf(opt.stdout, "%d [label=<%s>; shape=%s%s];\n", i, "<FONT POINT-SIZE=\"12\">"+filepath.Dir(act.Package)+"</FONT><BR/><FONT POINT-SIZE=\"22\">"+filepath.Base(act.Package)+"</FONT><BR/>"+act.Mode+" "+act.TimeDone.Sub(act.TimeStart).String(), shape, attrs)
 
 		for _, dep := range act.Deps {
 			if show[dep] != follow {
 				continue
 			}
-			fmt.Printf("\t%d -> %d;\n", i, dep)
+			fmt.Fprintf(opt.stdout, "\t%d -> %d;\n", i, dep)
 		}
 	}
+	if gopt.legend {
+		dotLegend(opt, info)
+	}
 	fmt.Fprintln(opt.stdout, "}")
 
 	return nil
 }
 
+// graphInfo describes the actions shown by a graph, for its title and legend.
+type graphInfo struct {
+	Summary  string   // Number of actions, their time and when the build started.
+	Metadata string   // The file read and the command line.
+	Modes    []string // Of the actions shown, sorted.
+	total    time.Duration
+}
+
+func newGraphInfo(opt *options, gopt graphOptions, show []int) graphInfo {
+	var info graphInfo
+	var n int
+	modes := make(map[string]bool)
+	for i, g := range show {
+		if g != follow {
+			continue
+		}
+		act := &opt.store.actions[i]
+		n++
+		info.total += act.Duration
+		if !modes[act.Mode] {
+			modes[act.Mode] = true
+			info.Modes = append(info.Modes, act.Mode)
+		}
+	}
+	sort.Strings(info.Modes)
+	info.Summary = fmt.Sprintf("%d actions taking %.3fs of %.3fs, built over %.3fs from %s",
+		n, info.total.Seconds(), opt.store.total.Seconds(), opt.store.wall().Seconds(), opt.store.start().Format(time.RFC3339))
+
+	source := "stdin"
+	if opt.file != "" && opt.file != "-" {
+		source = opt.file
+		if fi, err := os.Stat(opt.file); err == nil {
+			source += fmt.Sprintf(" (%d bytes, modified %s)", fi.Size(), fi.ModTime().Format(time.RFC3339))
+		}
+	}
+	info.Metadata = "read from " + source + " by actiongraph " + strings.Join(os.Args[1:], " ")
+	return info
+}
+
+// modeShapes are the shapes of the nodes of each mode drawn with --legend.
+// Those of other modes are ellipses.
+var modeShapes = map[string]string{
+	"build":    "box",
+	"link":     "box3d",
+	"vet":      "note",
+	"test run": "cds",
+}
+
+func modeShape(mode string) string {
+	if shape, ok := modeShapes[mode]; ok {
+		return shape
+	}
+	return "ellipse"
+}
+
+// heatShare is the share of the time shown from which actions are coloured
+// the warmest, matching the HTML page.
+const heatShare = 0.05
+
+// heatColor returns the Graphviz colour of an action taking d of total, from
+// yellow for the quickest to red for those taking heatShare or more.
+func heatColor(d, total time.Duration) string {
+	share := 0.0
+	if total > 0 {
+		share = float64(d) / float64(total) / heatShare
+	}
+	if share > 1 {
+		share = 1
+	}
+	// The hue falls from 60 degrees to 0, as hsl(hue, 80%, 75%) does.
+	return fmt.Sprintf("%.3f 0.421 0.950", (60-60*share)/360)
+}
+
+// dotLegend writes a cluster explaining the colours and shapes of the nodes.
+func dotLegend(opt *options, info graphInfo) {
+	fmt.Fprintln(opt.stdout, "subgraph cluster_legend {")
+	fmt.Fprintln(opt.stdout, "label=\"Legend\"; fontsize=14;")
+	for i, share := range []float64{0, heatShare / 4, heatShare / 2, heatShare} {
+		d := time.Duration(share * float64(info.total))
+		label := fmt.Sprintf("%.3fs (%.2f%%)", d.Seconds(), 100*share)
+		if share == heatShare {
+			label = "at least " + label
+		}
+		fmt.Fprintf(opt.stdout, "\"legend heat %d\" [label=%s; shape=box; style=filled; fillcolor=%q];\n", i, dotString(label), heatColor(d, info.total))
+	}
+	for _, mode := range info.Modes {
+		fmt.Fprintf(opt.stdout, "\"legend mode %s\" [label=%s; shape=%s];\n", mode, dotString(mode), modeShape(mode))
+	}
+	fmt.Fprintln(opt.stdout, "}")
+}
+
+// dotString quotes s as a DOT string.
+func dotString(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+//go:embed graph.html
+var graphHTMLTemplate string
+
+// graphNode is an action shown by the HTML page, with only those of its Deps
+// which are shown too.
+type graphNode struct {
+	ID       int
+	Mode     string
+	Package  string
+	Duration time.Duration
+	Deps     []int
+}
+
+// graphHTML writes a standalone HTML page which draws the actions to follow,
+// starting from those without dependents and expanding the dependencies of
+// those clicked.
+func graphHTML(opt *options, gopt graphOptions, info graphInfo, show []int) error {
+	tpl, err := htmltpl.New("graph").Parse(graphHTMLTemplate)
+	if err != nil {
+		return err
+	}
+	var nodes []graphNode
+	for i, g := range show {
+		if g != follow {
+			continue
+		}
+		act := &opt.store.actions[i]
+		n := graphNode{ID: act.ID, Mode: act.Mode, Package: act.Package, Duration: act.Duration}
+		for _, dep := range act.Deps {
+			if show[dep] == follow {
+				n.Deps = append(n.Deps, dep)
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	data := map[string]any{
+		"Title":  fmt.Sprintf("actiongraph graph: %d actions, %.3fs", len(nodes), opt.store.total.Seconds()),
+		"Nodes":  nodes,
+		"Legend": gopt.legend,
+		"Modes":  info.Modes,
+	}
+	if gopt.title != "" {
+		data["Title"], data["Summary"] = gopt.title, info.Summary
+	}
+	if gopt.metadata {
+		data["Metadata"] = info.Metadata
+	}
+	return tpl.Execute(opt.stdout, data)
+}
+
+// shortestPaths follows the targets, and the actions on the shortest path to
+// each of them from the actions which nothing depends upon. The rest are
+// avoided.
+func shortestPaths(show []int, targets map[int]bool, actions []action) {
+	depended := make([]bool, len(actions))
+	for i, act := range actions {
+		if show[i] == avoid {
+			continue
+		}
+		for _, dep := range act.Deps {
+			depended[dep] = true
+		}
+	}
+
+	// Search breadth-first from all of the roots at once, recording the
+	// action through which each was first reached.
+	via := make(map[int]int, len(actions))
+	var queue []int
+	for i := range actions {
+		if show[i] != avoid && !depended[i] {
+			via[i] = -1
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, m := range actions[n].Deps {
+			if _, seen := via[m]; !seen && show[m] != avoid {
+				via[m] = n
+				queue = append(queue, m)
+			}
+		}
+	}
+
+	for i := range show {
+		show[i] = avoid
+	}
+	for t := range targets {
+		for n := t; n >= 0; n = via[n] {
+			show[n] = follow
+		}
+	}
+}
+
+// rootsOf returns the actions to follow which no other action to follow
+// depends upon.
+func rootsOf(show []int, actions []action) []int {
+	depended := make([]bool, len(show))
+	for i, g := range show {
+		if g != follow {
+			continue
+		}
+		for _, dep := range actions[i].Deps {
+			depended[dep] = true
+		}
+	}
+	var roots []int
+	for i, g := range show {
+		if g == follow && !depended[i] {
+			roots = append(roots, i)
+		}
+	}
+	return roots
+}
+
+// limitDepth stops following the actions more than depth edges from any of
+// the starts, stepping only between actions which are followed.
+func limitDepth(show []int, starts []int, depth int, edges func(int) []int) {
+	dist := make(map[int]int, len(starts))
+	queue := make([]int, 0, len(starts))
+	for _, n := range starts {
+		dist[n] = 0
+		queue = append(queue, n)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if dist[n] == depth {
+			continue
+		}
+		for _, m := range edges(n) {
+			if _, seen := dist[m]; !seen && show[m] == follow {
+				dist[m] = dist[n] + 1
+				queue = append(queue, m)
+			}
+		}
+	}
+	for i, g := range show {
+		if _, near := dist[i]; g == follow && !near {
+			show[i] = avoid
+		}
+	}
+}
+
 const (
 	avoid   = -1
 	unknown = 0
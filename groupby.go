@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// groupings are the values of top and tree's --group-by flags.
+var groupings = []string{"directory", "module", "domain"}
+
+// codeHosts are the domains which keep their repositories beneath the
+// accounts of their owners, such that the packages of each owner are grouped
+// by --group-by domain.
+var codeHosts = map[string]bool{
+	"bitbucket.org": true,
+	"codeberg.org":  true,
+	"gitee.com":     true,
+	"github.com":    true,
+	"gitlab.com":    true,
+	"golang.org":    true, // golang.org/x.
+	"sr.ht":         true,
+}
+
+// domain returns the domain of pkg's import path, such as k8s.io, with the
+// owner of the repository for the code hosts, such as github.com/spf13. The
+// standard library is its own domain, std, and packages whose path doesn't
+// start with a domain are grouped by their first element.
+func domain(pkg string, std bool) string {
+	if std {
+		return "std"
+	}
+	first, rest, _ := strings.Cut(pkg, "/")
+	if !codeHosts[first] || rest == "" {
+		return first
+	}
+	owner, _, _ := strings.Cut(rest, "/")
+	return first + "/" + owner
+}
+
+// groupTotal is the total of the actions in a group given by --group-by.
+type groupTotal struct {
+	Group             string
+	Duration          time.Duration
+	Percent           float64
+	CumulativePercent float64
+	Count             int // Number of actions.
+	Cached            int // Number of the actions taken from the cache.
+	Packages          int // Number of distinct packages.
+}
+
+var groupFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Cumulative", tpl: `{{ .CumulativePercent | percent }}`},
+		{name: "Packages", tpl: `{{ .Packages }}`},
+		{name: "Group", tpl: `{{ .Group }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Group", tpl: `{{ .Group }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Cumulative", tpl: `{{ .CumulativePercent | percent }}`},
+		{name: "Packages", tpl: `{{ .Packages }}`},
+		{name: "Actions", tpl: `{{ .Count }}`},
+		{name: "Cached", tpl: `{{ .Cached }}`},
+		{name: "Group", tpl: `{{ .Group }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "group", tpl: `{{ .Group }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "cumulative_percent", tpl: `{{ .CumulativePercent | printf "%.2f" }}`},
+		{name: "packages", tpl: `{{ .Packages }}`},
+		{name: "count", tpl: `{{ .Count }}`},
+		{name: "cached", tpl: `{{ .Cached }}`},
+	},
+}
+
+// topGroups lists the groups of actions taking the most time, where group
+// gives the group of each action.
+func topGroups(opt *options, limit int, group func(*action) string, out *rowWriter) error {
+	totals := make(map[string]*groupTotal)
+	pkgs := make(map[string]map[string]bool)
+	for _, act := range opt.actions() {
+		name := group(act)
+		t := totals[name]
+		if t == nil {
+			t = &groupTotal{Group: name}
+			totals[name] = t
+			pkgs[name] = make(map[string]bool)
+		}
+		t.Duration += act.Duration
+		t.Count++
+		if act.Cached {
+			t.Cached++
+		}
+		if act.Package != "" {
+			pkgs[name][act.Package] = true
+		}
+	}
+
+	rows := make([]*groupTotal, 0, len(totals))
+	for name, t := range totals {
+		t.Percent = opt.store.percent(t.Duration)
+		t.Packages = len(pkgs[name])
+		rows = append(rows, t)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Duration != rows[j].Duration {
+			return rows[i].Duration > rows[j].Duration
+		}
+		return rows[i].Group < rows[j].Group
+	})
+	var cum time.Duration
+	for i, t := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		cum += t.Duration
+		t.CumulativePercent = opt.store.percent(cum)
+		if err := out.row(t); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+// actionGroup returns the function giving the group of each action by the
+// grouping, one of groupings, with the modules known to the build. Actions
+// without a package, such as the go command's nop and barrier actions, are
+// grouped by their mode.
+func actionGroup(grouping string, mods *modules) (func(*action) string, error) {
+	var group func(*action) string
+	switch grouping {
+	case "directory":
+		group = func(act *action) string {
+			first, _, _ := strings.Cut(treePath(act.Package, act.Std), "/")
+			return first
+		}
+	case "module":
+		group = func(act *action) string { return mods.module(act.importPath()) }
+	case "domain":
+		group = func(act *action) string { return domain(act.importPath(), act.Std) }
+	default:
+		return nil, fmt.Errorf("unknown --group-by %q: expected one of %s", grouping, strings.Join(groupings, ", "))
+	}
+	return func(act *action) string {
+		if act.Package == "" {
+			return "(" + act.Mode + ")"
+		}
+		return group(act)
+	}, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultHistory = "actiongraph-history.jsonl"
+
+func addRecordCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "record [-f compile.json] [--history FILE] [--git | --commit REF]",
+		Short:   "Append a summary of the build to a local history",
+		Long: `Append the total and per-package durations of the build, as saved by
+baseline save, to a history file of one JSON object per line, keyed by the
+commit built and the time of the build. The trend command then shows how the
+build times have changed.
+
+The commit, branch and dirty state of the working tree are given by flags, or
+found by running git with --git.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			path, err := cmd.Flags().GetString("history")
+			if err != nil {
+				return err
+			}
+			git, err := gitFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			return appendHistory(path, newHistoryEntry(opt, git))
+		},
+	}
+	cmd.Flags().String("history", defaultHistory, "history file to append to")
+	addGitFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+func addTrendCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "trend [--history FILE] [-n limit] [package]",
+		Short:   "Show how the build time changed over the recorded history",
+		Long: `List the builds appended to the history by record, in the order they were
+recorded, with the total duration of their actions, or of the actions of the
+given package, and a bar charting it.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt := newOptions(cmd)
+
+			flags := cmd.Flags()
+			path, err := flags.GetString("history")
+			if err != nil {
+				return err
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			var pkg string
+			if len(args) > 0 {
+				pkg = args[0]
+			}
+
+			history, err := readHistory(path)
+			if err != nil {
+				return err
+			}
+			opt.funcs["signed"] = signedSeconds
+			out, err := newRowWriter(cmd, opt, trendFormats)
+			if err != nil {
+				return err
+			}
+			return trend(history, pkg, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("history", defaultHistory, "history file written by record")
+	flags.IntP("limit", "n", 0, "number of the most recent builds to show (0 for all)")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+// historyEntry summarises a build recorded in the history.
+type historyEntry struct {
+	gitInfo
+	Time time.Time // Start of the build.
+	Wall time.Duration
+	baseline
+
+	// PackageTotals maps each package to the total duration of its actions,
+	// of every mode. Entries recorded before it have only the Packages of
+	// the baseline.
+	PackageTotals map[string]time.Duration `json:",omitempty"`
+}
+
+func newHistoryEntry(opt *options, git gitInfo) historyEntry {
+	e := historyEntry{
+		gitInfo:  git,
+		Time:     opt.store.start(),
+		Wall:     opt.store.wall(),
+		baseline: *newBaseline(opt),
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.PackageTotals = make(map[string]time.Duration)
+	for _, act := range opt.actions() {
+		if act.Package != "" {
+			e.PackageTotals[act.Package] += act.Duration
+		}
+	}
+	return e
+}
+
+func appendHistory(path string, e historyEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var history []historyEntry
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if len(strings.TrimSpace(string(b))) > 0 {
+			var e historyEntry
+			if err := json.Unmarshal(b, &e); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			history = append(history, e)
+		}
+		if err == io.EOF {
+			return history, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+var trendFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Time", tpl: `{{ .Time | date "2006-01-02 15:04" }}`, left: true},
+		{name: "Commit", tpl: `{{ .Commit | trunc 12 }}`, left: true},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Change", tpl: `{{ if .Previous }}{{ .Change | signed }}{{ end }}`},
+		{name: "Bar", tpl: `{{ .Bar }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Time", tpl: `{{ .Time | date "2006-01-02 15:04" }}`, left: true},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+	},
+	wide: []formatColumn{
+		{name: "Time", tpl: `{{ .Time | date "2006-01-02 15:04" }}`, left: true},
+		{name: "Commit", tpl: `{{ .Commit }}`, left: true},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Change", tpl: `{{ if .Previous }}{{ .Change | signed }}{{ end }}`},
+		{name: "Wall", tpl: `{{ .Wall | seconds }}`},
+		{name: "Bar", tpl: `{{ .Bar }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "time", tpl: `{{ .Time | iso8601 }}`},
+		{name: "commit", tpl: `{{ .Commit }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "change", tpl: `{{ .Change.Seconds | printf "%.3f" }}`},
+		{name: "wall", tpl: `{{ .Wall.Seconds | printf "%.3f" }}`},
+	},
+}
+
+// trendPoint is a build in the history, with the duration being charted.
+type trendPoint struct {
+	Time     time.Time
+	Commit   string
+	Duration time.Duration // Of the whole build, or of the package's actions.
+	Wall     time.Duration
+	Previous bool          // Whether there's an earlier build to compare with.
+	Change   time.Duration // Since the previous build.
+	Bar      string        `json:"-"`
+}
+
+// packageTotal returns the total duration of the actions of pkg, and whether
+// the build had any.
+func (e *historyEntry) packageTotal(pkg string) (time.Duration, bool) {
+	if e.PackageTotals != nil {
+		d, ok := e.PackageTotals[pkg]
+		return d, ok
+	}
+	// The keys of the baseline join the mode and package with a space, and
+	// both can contain spaces, as in "build check cache" and "x [x.test]", so
+	// the package can only be matched as a suffix.
+	var total time.Duration
+	var found bool
+	for key, d := range e.Packages {
+		if strings.HasSuffix(key, " "+pkg) {
+			total += d
+			found = true
+		}
+	}
+	return total, found
+}
+
+// trendBarWidth is the width of the bar of the longest duration.
+const trendBarWidth = 40
+
+// trend lists the builds of the history with the duration of pkg, or of the
+// whole build if it's empty. Builds which didn't have pkg are left out.
+func trend(history []historyEntry, pkg string, limit int, out *rowWriter) error {
+	var points []trendPoint
+	for _, e := range history {
+		p := trendPoint{Time: e.Time, Commit: e.Commit, Duration: e.Total, Wall: e.Wall}
+		if pkg != "" {
+			d, found := e.packageTotal(pkg)
+			if !found {
+				continue
+			}
+			p.Duration = d
+		}
+		if n := len(points); n > 0 {
+			p.Previous = true
+			p.Change = p.Duration - points[n-1].Duration
+		}
+		points = append(points, p)
+	}
+	if pkg != "" && len(points) == 0 {
+		return fmt.Errorf("package %q isn't in the history", pkg)
+	}
+	if limit > 0 && len(points) > limit {
+		points = points[len(points)-limit:]
+	}
+
+	var max time.Duration
+	for _, p := range points {
+		if p.Duration > max {
+			max = p.Duration
+		}
+	}
+	for _, p := range points {
+		if max > 0 {
+			p.Bar = strings.Repeat("█", int(trendBarWidth*p.Duration/max))
+		}
+		if err := out.row(p); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
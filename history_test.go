@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrendPackage(t *testing.T) {
+	history := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := run("record", "-f", variantsFile, "--history", history, "--commit", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	// An entry recorded before the totals of each package were, whose keys
+	// join the mode and package.
+	legacy := `{"Commit":"def","Time":"2023-01-02T00:00:00Z","Total":1,"Packages":{"build x":4000000000,"build x [x.test]":5000000000,"build check cache x":1000000000}}` + "\n"
+	f, err := os.OpenFile(history, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(legacy); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for pkg, want := range map[string]string{
+		"x":          "abc,2.000|def,5.000",
+		"x [x.test]": "abc,3.000|def,5.000",
+		"y":          "abc,2.000",
+	} {
+		out := runOutput(t, variantsFile, ".csv", "trend", "--history", history, pkg)
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n")[1:] {
+			fields := strings.Split(line, ",")
+			got = append(got, fields[1]+","+fields[2])
+		}
+		if strings.Join(got, "|") != want {
+			t.Errorf("trend %q gave %q, want %q", pkg, strings.Join(got, "|"), want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addIdleCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "idle [-f compile.json] [-n limit] [--parallelism N]",
+		Short:   "Find when the build left workers idle, and what it waited on",
+		Long: `List the periods in which fewer actions were running than the build's
+parallelism allowed, largest first by the worker time left idle, with the
+actions which gated them:
+
+  scheduler     actions were ready to run, their dependencies done, but
+                weren't started: the gating actions are those left waiting
+  dependencies  no actions were ready, so the build waited on the gating
+                actions which were running to finish, as the shape of the
+                graph allowed no more to run alongside them
+
+The parallelism is the most actions seen running at once, unless given by
+--parallelism, as by go build -p, which defaults to the number of CPUs.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			parallelism, err := flags.GetInt("parallelism")
+			if err != nil {
+				return err
+			}
+			if parallelism < 0 {
+				return errors.New("--parallelism must not be negative")
+			}
+			minGap, err := flags.GetDuration("min-gap")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, idleFormats)
+			if err != nil {
+				return err
+			}
+			return idle(opt, parallelism, minGap, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of periods to show")
+	flags.Int("parallelism", 0, "number of actions the build could run at once (default the most seen running)")
+	flags.Duration("min-gap", 10*time.Millisecond, "ignore periods shorter than this")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var idleFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Idle", tpl: `{{ .Idle | colordur }}`},
+		{name: "Start", tpl: `+{{ .Start | seconds }}`},
+		{name: "Duration", tpl: `{{ .Duration | seconds }}`},
+		{name: "Running", tpl: `{{ .Running | printf "%.1f" }}`},
+		{name: "Kind", tpl: `{{ .Kind }}`, left: true},
+		{name: "Gating", tpl: `{{ .Gating | join ", " }}{{ if gt .Gaters (len .Gating) }}, …{{ end }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Idle", tpl: `{{ .Idle | colordur }}`},
+		{name: "Kind", tpl: `{{ .Kind }}`, left: true},
+		{name: "Gating", tpl: `{{ .Gating | join ", " }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Idle", tpl: `{{ .Idle | colordur }}`},
+		{name: "Start", tpl: `+{{ .Start | seconds }}`},
+		{name: "Done", tpl: `+{{ .Done | seconds }}`},
+		{name: "Duration", tpl: `{{ .Duration | seconds }}`},
+		{name: "Running", tpl: `{{ .Running | printf "%.1f" }}`},
+		{name: "Ready", tpl: `{{ .Ready | printf "%.1f" }}`},
+		{name: "Gaters", tpl: `{{ .Gaters }}`},
+		{name: "Kind", tpl: `{{ .Kind }}`, left: true},
+		{name: "Gating", tpl: `{{ .Gating | join ", " }}{{ if gt .Gaters (len .Gating) }}, …{{ end }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "kind", tpl: `{{ .Kind }}`},
+		{name: "start_offset", tpl: `{{ .Start.Seconds | printf "%.3f" }}`},
+		{name: "done_offset", tpl: `{{ .Done.Seconds | printf "%.3f" }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "idle", tpl: `{{ .Idle.Seconds | printf "%.3f" }}`},
+		{name: "running", tpl: `{{ .Running | printf "%.2f" }}`},
+		{name: "ready", tpl: `{{ .Ready | printf "%.2f" }}`},
+		{name: "gaters", tpl: `{{ .Gaters }}`},
+		{name: "gating", tpl: `{{ .Gating | join " " }}`},
+	},
+}
+
+// idleGap is a period in which the build ran fewer actions than it could
+// have.
+type idleGap struct {
+	Kind     string        // scheduler or dependencies.
+	Start    time.Duration // Offset from the start of the build.
+	Done     time.Duration
+	Duration time.Duration
+	Idle     time.Duration // Worker time left idle: the parallelism less the actions running, over the period.
+	Running  float64       // Mean number of actions running.
+	Ready    float64       // Mean number of actions ready but not started.
+	Gaters   int           // Number of the gating actions.
+	Gating   []string      // Packages of the first few of the gating actions, longest in the period first.
+	IDs      []int         // Of all of the gating actions.
+}
+
+// idleGapGating is the number of gating actions named by each gap.
+const idleGapGating = 3
+
+// idle lists the periods in which the actions left workers idle, followed by
+// the total idle time of each kind when written as text.
+func idle(opt *options, parallelism int, minGap time.Duration, limit int, out *rowWriter) error {
+	acts, err := timedActions(opt)
+	if err != nil {
+		return err
+	}
+	start := opt.store.start()
+	spans, peak := concurrency(acts, start)
+	if parallelism == 0 {
+		parallelism = peak
+	}
+
+	// Merge the consecutive spans with idle workers of the same kind.
+	var gaps []*idleGap
+	idleBy := make(map[string]time.Duration)
+	var gap *idleGap
+	for _, s := range spans {
+		if s.running >= parallelism {
+			gap = nil
+			continue
+		}
+		kind := "dependencies"
+		if s.ready > 0 {
+			kind = "scheduler"
+		}
+		if gap == nil || gap.Kind != kind || gap.Done != s.start {
+			gap = &idleGap{Kind: kind, Start: s.start}
+			gaps = append(gaps, gap)
+		}
+		d := s.done - s.start
+		gap.Done = s.done
+		gap.Idle += time.Duration(parallelism-s.running) * d
+		gap.Running += float64(s.running) * float64(d)
+		gap.Ready += float64(s.ready) * float64(d)
+		idleBy[kind] += time.Duration(parallelism-s.running) * d
+	}
+
+	var rows []*idleGap
+	for _, g := range gaps {
+		g.Duration = g.Done - g.Start
+		if g.Duration < minGap || g.Duration <= 0 {
+			continue
+		}
+		g.Running /= float64(g.Duration)
+		g.Ready /= float64(g.Duration)
+		idleGating(g, acts, start)
+		rows = append(rows, g)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Idle > rows[j].Idle
+	})
+	for i, g := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if err := out.row(g); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	wall := opt.store.wall()
+	total := idleBy["scheduler"] + idleBy["dependencies"]
+	fmt.Fprintf(opt.stdout, "%.3fs of %.3fs worker time idle (%.2f%%) at a parallelism of %d: %.3fs while actions were ready, %.3fs while none were\n",
+		total.Seconds(), (wall * time.Duration(parallelism)).Seconds(), percentOf(total, wall*time.Duration(parallelism)), parallelism,
+		idleBy["scheduler"].Seconds(), idleBy["dependencies"].Seconds())
+	return nil
+}
+
+// idleGating sets the actions gating the gap: those waiting to start, for the
+// scheduler, or those running, for the dependencies. They're ordered by how
+// much of the gap they spent so.
+func idleGating(g *idleGap, acts []*action, start time.Time) {
+	type gater struct {
+		act     *action
+		overlap time.Duration
+	}
+	var gaters []gater
+	for _, act := range acts {
+		from, to := act.StartOffset, act.DoneOffset
+		if g.Kind == "scheduler" {
+			from, to = readyOffset(act, start), act.StartOffset
+		}
+		if from < g.Start {
+			from = g.Start
+		}
+		if to > g.Done {
+			to = g.Done
+		}
+		if to > from {
+			gaters = append(gaters, gater{act, to - from})
+		}
+	}
+	sort.SliceStable(gaters, func(i, j int) bool {
+		return gaters[i].overlap > gaters[j].overlap
+	})
+	g.Gaters = len(gaters)
+	for i, gt := range gaters {
+		if i < idleGapGating {
+			g.Gating = append(g.Gating, gt.act.Mode+" "+gt.act.Package)
+		}
+		g.IDs = append(g.IDs, gt.act.ID)
+	}
+}
+
+// timedActions returns the actions with start and end times.
+func timedActions(opt *options) ([]*action, error) {
+	var acts []*action
+	for _, act := range opt.actions() {
+		if !act.TimeStart.IsZero() && !act.Untimed {
+			acts = append(acts, act)
+		}
+	}
+	if len(acts) == 0 {
+		return nil, errors.New("no actions have start and end times")
+	}
+	return acts, nil
+}
+
+// readyOffset returns the offset from start at which the action's
+// dependencies had finished, or at which it started if that's unknown.
+func readyOffset(act *action, start time.Time) time.Duration {
+	if act.TimeReady.IsZero() || act.TimeReady.After(act.TimeStart) {
+		return act.StartOffset
+	}
+	return act.TimeReady.Sub(start)
+}
+
+// concurrencySpan is a period in which the numbers of actions running, and
+// ready but not yet started, didn't change.
+type concurrencySpan struct {
+	start, done    time.Duration // Offsets from the start of the build.
+	running, ready int
+}
+
+// concurrency returns the consecutive spans of the build from the first
+// action to the last, with the most actions running at once.
+func concurrency(acts []*action, start time.Time) (spans []concurrencySpan, peak int) {
+	// Sweep through the times at which the numbers running and ready change.
+	type counts struct{ running, ready int }
+	deltas := make(map[time.Duration]*counts)
+	at := func(t time.Duration) *counts {
+		c := deltas[t]
+		if c == nil {
+			c = &counts{}
+			deltas[t] = c
+		}
+		return c
+	}
+	for _, act := range acts {
+		at(readyOffset(act, start)).ready++
+		at(act.StartOffset).ready--
+		at(act.StartOffset).running++
+		at(act.DoneOffset).running--
+	}
+	times := make([]time.Duration, 0, len(deltas))
+	for t := range deltas {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	spans = make([]concurrencySpan, 0, len(times))
+	var running, ready int
+	for i, t := range times[:len(times)-1] {
+		running += deltas[t].running
+		ready += deltas[t].ready
+		if running > peak {
+			peak = running
+		}
+		spans = append(spans, concurrencySpan{t, times[i+1], running, ready})
+	}
+	return spans, peak
+}
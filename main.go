@@ -1,15 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	txttpl "text/template"
 	"time"
 
+	"github.com/icio/actiongraph/actiongraph"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +21,10 @@ func main() {
 	err := run(os.Args[1:]...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "actiongraph: %s\n", err)
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
 		os.Exit(1)
 	}
 }
@@ -28,57 +36,205 @@ func run(args ...string) error {
 		SilenceErrors: true,
 	}
 
-	prog.PersistentFlags().StringP("file", "f", "-", "JSON file to read (use - for stdin)")
+	prog.PersistentFlags().StringP("file", "f", "-", "file of actions to read, written by -debug-actiongraph, bazel aquery --output=jsonproto, ninja as .ninja_log, or the commands printed by go build -x (use - for stdin)")
 	prog.MarkFlagRequired("file")
 	prog.RegisterFlagCompletionFunc("file", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		return []string{"json"}, cobra.ShellCompDirectiveFilterFileExt
 	})
 
+	prog.PersistentFlags().String("ninja-graph", "", "join the dependencies written by `ninja -t graph` onto the steps of a .ninja_log")
+	prog.PersistentFlags().String("artifacts", "", "directory of actiongraph files, searched for the newest when --file isn't given on a terminal")
+	prog.MarkPersistentFlagDirname("artifacts")
+
+	addThresholdFlags(prog)
+	addColorFlags(prog)
+	out := addOutputFlag(prog)
+	prog.PersistentFlags().Int("precision", 3, "number of decimal places of durations formatted by seconds")
+	prog.PersistentFlags().Bool("cgo", false, "consider only actions which ran cgo")
+	prog.PersistentFlags().Bool("no-std", false, "ignore actions for standard library packages")
+	prog.PersistentFlags().Bool("include-untimed", false, "consider the actions missing a start or end time, which are otherwise ignored, for debugging")
+	prog.PersistentFlags().Bool("hide-cached", false, "ignore actions whose results were taken from the build cache, counting them beneath tables")
+	prog.PersistentFlags().StringSlice("module", nil, "path of the main module (default from go list -m)")
+	prog.PersistentFlags().Bool("own", false, "consider only actions for packages in the main module")
+	prog.PersistentFlags().Bool("deps-only", false, "consider only actions for packages outside of the main module")
+	prog.PersistentFlags().String("enrich-golist", "", "join package metadata from a `go list -json` file, or run go list when given without a file")
+	prog.PersistentFlags().Lookup("enrich-golist").NoOptDefVal = "go"
+	prog.PersistentFlags().Bool("keep-vendor", false, "keep the vendor directories in the paths of vendored packages, rather than giving the paths of their modules")
+	prog.PersistentFlags().String("aliases", "", "YAML or JSON `file` naming the components made up by package path prefixes, which replace the prefixes in the paths shown after --filter, --own and the modules are decided")
+	prog.PersistentFlags().StringArray("trim-prefix", nil, "remove a prefix, such as github.com/org/repo/, from the package paths shown, after --filter, --own and the modules are decided")
+	prog.PersistentFlags().StringArray("rewrite", nil, "rewrite the package paths shown, as 'REGEXP=>REPLACEMENT', after --trim-prefix")
+	prog.PersistentFlags().StringArray("define", nil, "add a field computed by an expression to each action, as NAME=EXPR, such as 'CPURatio=CmdUser/Duration', seeing the import paths before --aliases, --trim-prefix and --rewrite")
+	prog.PersistentFlags().Bool("merge-test-variants", false, "fold the actions of packages built for tests, like \"x [x.test]\", x.test and x_test, into those of the package x")
+	prog.PersistentFlags().CountP("verbose", "v", "log what was read and how long each step took to stderr, or with -vv the decisions made about the actions")
+	prog.PersistentFlags().String("filter", "", "consider only actions matching an expression, such as 'Mode == \"build\" && Duration > duration(\"1s\") && !Cached', against the import paths before --aliases, --trim-prefix and --rewrite")
+
 	addTopCommand(prog)
 	addTreeCommand(prog)
 	addTypesCommand(prog)
 	addGraphCommand(prog)
+	addWhyCommand(prog)
+	addPathsCommand(prog)
+	addChainsCommand(prog)
+	addDominatorsCommand(prog)
+	addPriorityCommand(prog)
+	addIdleCommand(prog)
+	addUtilizationCommand(prog)
+	addSpeedupCommand(prog)
+	addWarmCommand(prog)
+	addSplitCommand(prog)
+	addTimelineCommand(prog)
+	addBuildIDsCommand(prog)
+	addCgoCommand(prog)
+	addCorrelateCommand(prog)
+	addSizesCommand(prog)
+	addGoCacheCommand(prog)
+	addDuplicatesCommand(prog)
+	addFlagMissesCommand(prog)
+	addOwnersCommand(prog)
+	addCostCommand(prog)
+	addBaselineCommand(prog)
+	addAssertCommand(prog)
+	addDiffCommand(prog)
+	addAggregateCommand(prog)
+	addBenchCommand(prog)
+	addMatrixCommand(prog)
+	addRecordCommand(prog)
+	addTrendCommand(prog)
+	addPRCommentCommand(prog)
+	addMetricsCommand(prog)
+	addEmitCommand(prog)
+	addBadgeCommand(prog)
+	addExportCommand(prog)
+	addSQLCommand(prog)
+	addQueryCommand(prog)
+	addExecCommand(prog)
+	addTestCommand(prog)
+	addTestDepsCommand(prog)
+	addTestTimesCommand(prog)
+	addTraceCommand(prog)
+	addSchemaCommand(prog)
+	addPluginCommand(prog, args)
 
 	prog.AddGroup(&cobra.Group{
 		ID:    "actiongraph",
 		Title: "Actiongraph:",
 	})
 
+	if err := applyEnvDefaults(prog); err != nil {
+		return err
+	}
+
 	prog.SetArgs(args)
-	return prog.Execute()
+	return out.close(prog.Execute())
 }
 
 type options struct {
-	stdin   io.Reader
-	stdout  io.Writer
-	args    []string
-	funcs   txttpl.FuncMap
-	actions []action
-	total   time.Duration
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	args   []string
+	funcs  txttpl.FuncMap
+	store  *store
+
+	// file is the path of the actiongraph file read, or - for stdin.
+	file string
+
+	// modules are the main modules given by --module.
+	modules []string
+
+	// defined are the names of the fields given by --define.
+	defined []string
+
+	// filters select the actions considered by commands. An action must
+	// pass every filter.
+	filters []func(*action) bool
+
+	// hideCached leaves out the cached actions, as if by another filter.
+	hideCached bool
+
+	// verbosity is the number of times -v was given.
+	verbosity int
 }
 
-func loadOptions(cmd *cobra.Command) (*options, error) {
-	opt := options{
+// actions returns the actions passing the filters, in ID order.
+func (opt *options) actions() []*action {
+	v := opt.store.view()
+	if len(opt.filters) == 0 && !opt.hideCached {
+		return v
+	}
+	keep := v[:0]
+	for _, act := range v {
+		if opt.include(act) {
+			keep = append(keep, act)
+		}
+	}
+	return keep
+}
+
+// sorted returns the actions passing the filters, ordered by less.
+func (opt *options) sorted(less func(a, b *action) bool) []*action {
+	v := opt.actions()
+	sort.SliceStable(v, func(i, j int) bool { return less(v[i], v[j]) })
+	return v
+}
+
+// include reports whether act passes the filters.
+func (opt *options) include(act *action) bool {
+	if opt.hideCached && act.Cached {
+		return false
+	}
+	return opt.filtered(act)
+}
+
+// filtered reports whether act passes the filters, other than --hide-cached.
+func (opt *options) filtered(act *action) bool {
+	for _, f := range opt.filters {
+		if !f(act) {
+			return false
+		}
+	}
+	return true
+}
+
+// hiddenCached returns the number of actions passing the filters which were
+// left out by --hide-cached.
+func (opt *options) hiddenCached() int {
+	if !opt.hideCached {
+		return 0
+	}
+	var n int
+	for i := range opt.store.actions {
+		if act := &opt.store.actions[i]; act.Cached && opt.filtered(act) {
+			n++
+		}
+	}
+	return n
+}
+
+// newOptions returns the options for commands which don't read the actions
+// from --file.
+func newOptions(cmd *cobra.Command) *options {
+	return &options{
 		stdin:  cmd.InOrStdin(),
 		stdout: cmd.OutOrStdout(),
+		stderr: cmd.ErrOrStderr(),
 		args:   cmd.Flags().Args(),
 
-		funcs: txttpl.FuncMap{
-			"base": filepath.Base,
-			"dir":  filepath.Dir,
-			"seconds": func(d time.Duration) string {
-				return fmt.Sprintf("%.3fs", d.Seconds())
-			},
-			"percent": func(v float64) string {
-				return fmt.Sprintf("%.2f%%", v)
-			},
-			"right": func(n int, s string) string {
-				if len(s) > n {
-					return s
-				}
-				return strings.Repeat(" ", n-len(s)) + s
-			},
-		},
+		funcs: templateFuncs(),
+	}
+}
+
+func loadOptions(cmd *cobra.Command) (*options, error) {
+	opt := newOptions(cmd)
+
+	var err error
+	opt.verbosity, err = cmd.Flags().GetCount("verbose")
+	if err != nil {
+		return nil, err
+	}
+	opt.modules, err = cmd.Flags().GetStringSlice("module")
+	if err != nil {
+		return nil, err
 	}
 
 	// Open the actiongraph JSON file.
@@ -86,30 +242,297 @@ func loadOptions(cmd *cobra.Command) (*options, error) {
 	if err != nil {
 		return nil, err
 	}
+	if (fn == "" || fn == "-") && !cmd.Flags().Changed("file") && isTerminal(opt.stdin) {
+		// Rather than wait on a terminal, find the latest build.
+		artifacts, err := cmd.Flags().GetString("artifacts")
+		if err != nil {
+			return nil, err
+		}
+		fn, err = discoverFile(artifacts)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(opt.stderr, "actiongraph: reading %s\n", fn)
+	}
 	f, err := openFile(fn)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
+	opt.file = fn
 
 	// Decode the actions.
-	if err := json.NewDecoder(f).Decode(&opt.actions); err != nil {
-		return nil, fmt.Errorf("decoding input: %w", err)
+	step := time.Now()
+	r := &countingReader{r: f}
+	g, err := actiongraph.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	step = opt.timed(fmt.Sprintf("decoding %d bytes of %s", r.n, fn), step)
+
+	if graph, err := cmd.Flags().GetString("ninja-graph"); err != nil {
+		return nil, err
+	} else if graph != "" {
+		f, err := os.Open(graph)
+		if err != nil {
+			return nil, err
+		}
+		err = g.JoinNinjaGraph(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", graph, err)
+		}
+		step = opt.timed("joining "+graph, step)
+	}
+	opt.store = newStore(g)
+	step = opt.timed("parsing the commands", step)
+	logStore(opt)
+
+	if keep, err := cmd.Flags().GetBool("keep-vendor"); err != nil {
+		return nil, err
+	} else if !keep {
+		opt.store.unvendorPackages()
+	}
+
+	if err := buildFuncs(cmd, opt); err != nil {
+		return nil, err
+	}
+
+	// Join on the package metadata from go list.
+	if golist, err := cmd.Flags().GetString("enrich-golist"); err != nil {
+		return nil, err
+	} else if golist != "" {
+		if err := enrichGoList(cmd, opt.store, golist); err != nil {
+			return nil, err
+		}
+		step = opt.timed("joining go list", step)
+	}
+
+	if merge, err := cmd.Flags().GetBool("merge-test-variants"); err != nil {
+		return nil, err
+	} else if merge {
+		opt.store.mergeTestVariants()
+		step = opt.timed("merging test variants", step)
+	}
+
+	// Timings of instrumented and plain builds aren't comparable.
+	if mixed := opt.store.instrumentations(); len(mixed) > 1 {
+		fmt.Fprintf(opt.stderr, "actiongraph: warning: the build mixes actions with different instrumentation (%s), so their timings aren't comparable\n", strings.Join(mixed, ", "))
+	}
+
+	// Filter the actions.
+	if include, err := cmd.Flags().GetBool("include-untimed"); err != nil {
+		return nil, err
+	} else if !include {
+		var untimed int
+		for i := range opt.store.actions {
+			if opt.store.actions[i].Untimed {
+				untimed++
+			}
+		}
+		if untimed > 0 {
+			fmt.Fprintf(opt.stderr, "actiongraph: warning: ignoring %d actions missing a start or end time (see --include-untimed)\n", untimed)
+			opt.addFilter("ignoring untimed actions", func(act *action) bool { return !act.Untimed })
+		}
+	}
+	if cgo, err := cmd.Flags().GetBool("cgo"); err != nil {
+		return nil, err
+	} else if cgo {
+		opt.addFilter("--cgo", func(act *action) bool { return act.Cgo })
+	}
+	if noStd, err := cmd.Flags().GetBool("no-std"); err != nil {
+		return nil, err
+	} else if noStd {
+		opt.addFilter("--no-std", func(act *action) bool { return !act.Std })
+	}
+	if hide, err := cmd.Flags().GetBool("hide-cached"); err != nil {
+		return nil, err
+	} else if hide {
+		opt.hideCached = true
 	}
+	own, err := cmd.Flags().GetBool("own")
+	if err != nil {
+		return nil, err
+	}
+	depsOnly, err := cmd.Flags().GetBool("deps-only")
+	if err != nil {
+		return nil, err
+	}
+	if own || depsOnly {
+		if own && depsOnly {
+			return nil, errors.New("--own and --deps-only are mutually exclusive")
+		}
+		main, err := opt.mainModules(cmd.Context())
+		if err != nil {
+			return nil, fmt.Errorf("finding the main module (set --module): %w", err)
+		}
+		for i := range opt.store.actions {
+			opt.store.actions[i].Own = inModules(opt.store.actions[i].Package, main)
+		}
+		step = opt.timed("finding the main module", step)
+		flag := "--own"
+		if depsOnly {
+			flag = "--deps-only"
+		}
+		opt.addFilter(flag, func(act *action) bool { return act.Own == own })
+	}
+
+	// The fields and filters are evaluated against the import paths.
+	if defines, err := cmd.Flags().GetStringArray("define"); err != nil {
+		return nil, err
+	} else if len(defines) > 0 {
+		opt.defined, err = defineFields(opt.store, defines)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if filter, err := cmd.Flags().GetString("filter"); err != nil {
+		return nil, err
+	} else if filter != "" {
+		f, err := filterActions(opt.store, filter)
+		if err != nil {
+			return nil, err
+		}
+		opt.addFilter("--filter", f)
+	}
+
+	// Shorten the package paths shown once the filters needing them whole
+	// have been decided. The import paths are kept for finding modules.
+	if path, err := cmd.Flags().GetString("aliases"); err != nil {
+		return nil, err
+	} else if path != "" {
+		aliases, err := readAliases(path)
+		if err != nil {
+			return nil, err
+		}
+		opt.store.aliasPackages(aliases)
+	}
+	trims, err := cmd.Flags().GetStringArray("trim-prefix")
+	if err != nil {
+		return nil, err
+	}
+	rewrites, err := cmd.Flags().GetStringArray("rewrite")
+	if err != nil {
+		return nil, err
+	}
+	if len(trims) > 0 || len(rewrites) > 0 {
+		rewrite, err := packageRewriter(trims, rewrites)
+		if err != nil {
+			return nil, err
+		}
+		opt.store.rewritePackages(rewrite)
+	}
+
+	if opt.verbosity >= 1 {
+		opt.timed("preparing the actions", step)
+		opt.logf(1, "the filters keep %d of %d actions", len(opt.actions()), len(opt.store.actions))
+	}
+
+	// Once the command has produced its output, check whether the build
+	// exceeded any thresholds.
+	ran := time.Now()
+	cmd.PostRunE = func(cmd *cobra.Command, args []string) error {
+		opt.timed("running "+cmd.Name(), ran)
+		return checkThresholds(cmd, opt)
+	}
+	return opt, nil
+}
+
+// mainModules returns the modules whose code is being built, as given by the
+// --module flag or else found by go list.
+func (opt *options) mainModules(ctx context.Context) ([]string, error) {
+	if len(opt.modules) > 0 {
+		return opt.modules, nil
+	}
+	return goListMainModules(ctx)
+}
+
+// enrichGoList joins package metadata onto the actions, either from a file
+// written by `go list -json` or, given "go", by running go list.
+func enrichGoList(cmd *cobra.Command, s *store, golist string) error {
+	var r io.Reader
+	if golist == "go" {
+		var err error
+		r, err = runGoList(cmd.Context(), s.actions)
+		if err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(golist)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	pkgs, err := decodeGoList(r)
+	if err != nil {
+		return err
+	}
+	s.enrich(pkgs)
+	return nil
+}
 
-	// A few top-level calculations.
-	for i := range opt.actions {
-		// TODO: Flag to look at CmdReal/CmdUser instead? We can use the Cmd
-		// field being non-null to differentiate between cached and
-		// non-cached steps, too.
-		d := opt.actions[i].TimeDone.Sub(opt.actions[i].TimeStart)
-		opt.actions[i].Duration = d
-		opt.total += d
+// loadStoreFile reads the actions from the actiongraph JSON file at path.
+func loadStoreFile(path string) (*store, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := loadStore(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
-	for i := range opt.actions {
-		opt.actions[i].Percent = 100 * float64(opt.actions[i].Duration) / float64(opt.total)
+	return s, nil
+}
+
+// loadStoreFiles reads the actions from each of the actiongraph JSON files at
+// paths, decoding as many at once as there are CPUs to use. The error is that
+// of the first path which failed.
+func loadStoreFiles(paths ...string) ([]*store, error) {
+	stores := make([]*store, len(paths))
+	errs := make([]error, len(paths))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer func() { <-sem; wg.Done() }()
+			stores[i], errs[i] = loadStoreFile(path)
+		}(i, path)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stores, nil
+}
+
+// storeFlags are the global flags which loadOptions applies to the actions
+// read from --file, and which commands reading their builds by loadStoreFiles
+// don't.
+var storeFlags = []string{
+	"file", "artifacts", "ninja-graph", "fail-over", "fail-action-over",
+	"cgo", "no-std", "include-untimed", "hide-cached", "module", "own",
+	"deps-only", "enrich-golist", "keep-vendor", "aliases", "trim-prefix",
+	"rewrite", "define", "merge-test-variants", "filter",
+}
+
+// rejectStoreFlags returns an error for the first of storeFlags given to a
+// command reading its builds by loadStoreFiles, rather than ignore it.
+func rejectStoreFlags(cmd *cobra.Command) error {
+	for _, name := range storeFlags {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%s applies only to the commands reading --file, not %s", name, cmd.Name())
+		}
 	}
-	return &opt, nil
+	return nil
 }
 
 func openFile(path string) (*os.File, error) {
@@ -121,26 +544,65 @@ func openFile(path string) (*os.File, error) {
 	}
 }
 
+// action is an action of the build, with the fields derived by the commands.
 type action struct {
-	ID        int
-	Mode      string
-	Package   string
-	Deps      []int
-	Objdir    string
-	Target    string
-	Priority  int
-	Built     string
-	BuildID   string
-	TimeReady time.Time
-	TimeStart time.Time
-	TimeDone  time.Time
-	Cmd       any
-	ActionID  string
-	CmdReal   int
-	CmdUser   int64
-	CmdSys    int
-	NeedBuild bool
-
-	Duration time.Duration
-	Percent  float64
+	actiongraph.Action
+
+	Cgo bool // Whether the action ran cgo.
+	Std bool // Whether the package is part of the standard library.
+	Own bool // Whether the package is in the main module, if requested with --own or --deps-only.
+	instrumentation
+	goListInfo
+
+	// Details of the commands run, parsed from Cmd.
+	Commands    []command
+	Tool        string   // Tool of the main command, e.g. compile or link.
+	Flags       []string // Flags given to the main command.
+	FlagCount   int
+	SourceFiles int // Number of source files given to all commands.
+
+	// Variants are the actions folded into this one by --merge-test-variants.
+	Variants []testVariant
+
+	// Component is the name given by --aliases to the package's prefix.
+	Component string
+
+	// imported is the Package before --aliases, --trim-prefix and --rewrite
+	// renamed it, if they did.
+	imported string
+
+	// Fields computed by --define.
+	Fields map[string]any
+}
+
+// importPath returns the package's import path, as built, rather than as
+// renamed to be shown.
+func (a *action) importPath() string {
+	if a.imported != "" {
+		return a.imported
+	}
+	return a.Package
+}
+
+// HasFlag reports whether the main command was given flag, either alone or
+// with a value: HasFlag "-N" or HasFlag "-lang".
+func (a action) HasFlag(flag string) bool {
+	for _, f := range a.Flags {
+		if f == flag || strings.HasPrefix(f, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// cpuTime returns the user and system CPU time of the action's commands, or
+// its duration if they weren't recorded. Cached actions take no time.
+func (a *action) cpuTime() time.Duration {
+	if a.Cached {
+		return 0
+	}
+	if cpu := time.Duration(a.CmdUser) + time.Duration(a.CmdSys); cpu > 0 {
+		return cpu
+	}
+	return a.Duration
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func addMatrixCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "matrix --label NAME=FILE --label NAME=FILE...",
+		Short:   "Compare the build times of each package across several builds",
+		Long: `List the build time of each package in each of several labelled builds, such
+as those of a CI matrix of GOOS and GOARCH:
+
+    actiongraph matrix --label linux-amd64=a.json --label darwin-arm64=b.json
+
+The packages whose build times differ the most between the builds come first,
+with the label of the build in which they were slowest. Those built in only
+some of the builds are listed too, but those taking the same time in every
+build are left out.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := rejectStoreFlags(cmd); err != nil {
+				return err
+			}
+			opt := newOptions(cmd)
+
+			flags := cmd.Flags()
+			labels, err := flags.GetStringArray("label")
+			if err != nil {
+				return err
+			}
+			if len(labels) < 2 {
+				return errors.New("at least two builds must be given by --label")
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			names := make([]string, len(labels))
+			files := make([]string, len(labels))
+			for i, l := range labels {
+				name, fn, ok := strings.Cut(l, "=")
+				if !ok || name == "" || fn == "" {
+					return fmt.Errorf("parsing --label %q: expected NAME=FILE", l)
+				}
+				names[i], files[i] = name, fn
+			}
+			builds, err := loadStoreFiles(files...)
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, matrixFormats(names))
+			if err != nil {
+				return err
+			}
+			return matrix(opt, names, builds, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringArray("label", nil, "NAME=FILE of an actiongraph JSON file to compare, named for its column")
+	flags.IntP("limit", "n", 20, "number of packages to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+// matrixFormats returns the format presets with a column for each of the
+// labelled builds.
+func matrixFormats(names []string) formatPreset {
+	var f formatPreset
+	for i, name := range names {
+		tpl := fmt.Sprintf(`{{ if index .In %d }}{{ index .Durations %d | seconds }}{{ else }}-{{ end }}`, i, i)
+		f.table = append(f.table, formatColumn{name: name, tpl: tpl})
+		f.short = append(f.short, formatColumn{name: name, tpl: tpl})
+		f.wide = append(f.wide, formatColumn{name: name, tpl: tpl})
+		f.columns = append(f.columns, formatColumn{name: name, tpl: fmt.Sprintf(`{{ if index .In %d }}{{ (index .Durations %d).Seconds | printf "%%.3f" }}{{ end }}`, i, i)})
+	}
+	f.table = append(f.table,
+		formatColumn{name: "Gap", tpl: `{{ .Gap | colordur }}`},
+		formatColumn{name: "Slowest", tpl: `{{ .Slowest }}`, left: true},
+		formatColumn{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		formatColumn{name: "Package", tpl: `{{ .Package }}`, left: true},
+	)
+	f.short = append(f.short,
+		formatColumn{name: "Package", tpl: `{{ .Package }}`, left: true},
+	)
+	f.wide = append(f.wide,
+		formatColumn{name: "Gap", tpl: `{{ .Gap | colordur }}`},
+		formatColumn{name: "Ratio", tpl: `{{ if .Ratio }}{{ .Ratio | printf "%.2fx" }}{{ end }}`},
+		formatColumn{name: "Slowest", tpl: `{{ .Slowest }}`, left: true},
+		formatColumn{name: "Quickest", tpl: `{{ .Quickest }}`, left: true},
+		formatColumn{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		formatColumn{name: "Package", tpl: `{{ .Package }}`, left: true},
+	)
+	f.columns = append([]formatColumn{
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+	}, append(f.columns,
+		formatColumn{name: "gap", tpl: `{{ .Gap.Seconds | printf "%.3f" }}`},
+		formatColumn{name: "slowest", tpl: `{{ .Slowest }}`},
+		formatColumn{name: "quickest", tpl: `{{ .Quickest }}`},
+	)...)
+	return f
+}
+
+// pkgMatrix compares the actions for one mode and package across several
+// builds.
+type pkgMatrix struct {
+	Mode      string
+	Package   string
+	Durations []time.Duration // Of each build, zero when missing from it.
+	In        []bool          // Whether each build had the package.
+	Gap       time.Duration   // Between the slowest and quickest builds having the package.
+	Ratio     float64         // Of the slowest to the quickest build, or 0 if the quickest took no time.
+	Slowest   string          // Label of the slowest build.
+	Quickest  string          // Label of the quickest build having the package.
+}
+
+// matrix lists the packages whose build times differ the most across the
+// builds, followed by the builds' totals when written as text.
+func matrix(opt *options, names []string, builds []*store, limit int, out *rowWriter) error {
+	type key struct{ mode, pkg string }
+	rows := make(map[key]*pkgMatrix)
+	for i, s := range builds {
+		for j := range s.actions {
+			act := &s.actions[j]
+			k := key{act.Mode, act.Package}
+			m := rows[k]
+			if m == nil {
+				m = &pkgMatrix{
+					Mode:      act.Mode,
+					Package:   act.Package,
+					Durations: make([]time.Duration, len(builds)),
+					In:        make([]bool, len(builds)),
+				}
+				rows[k] = m
+			}
+			m.Durations[i] += act.Duration
+			m.In[i] = true
+		}
+	}
+
+	list := make([]*pkgMatrix, 0, len(rows))
+	for _, m := range rows {
+		slowest, quickest := -1, -1
+		for i, d := range m.Durations {
+			if !m.In[i] {
+				continue
+			}
+			if slowest < 0 || d > m.Durations[slowest] {
+				slowest = i
+			}
+			if quickest < 0 || d < m.Durations[quickest] {
+				quickest = i
+			}
+		}
+		m.Slowest, m.Quickest = names[slowest], names[quickest]
+		m.Gap = m.Durations[slowest] - m.Durations[quickest]
+		if m.Durations[quickest] > 0 {
+			m.Ratio = float64(m.Durations[slowest]) / float64(m.Durations[quickest])
+		}
+		if m.Gap == 0 && !slices.Contains(m.In, false) {
+			// The same everywhere.
+			continue
+		}
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Gap != list[j].Gap {
+			return list[i].Gap > list[j].Gap
+		}
+		if list[i].Package != list[j].Package {
+			return list[i].Package < list[j].Package
+		}
+		return list[i].Mode < list[j].Mode
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	for _, m := range list {
+		if err := out.row(m); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	totals := make([]string, len(builds))
+	for i, s := range builds {
+		totals[i] = fmt.Sprintf("%s %.3fs", names[i], s.total.Seconds())
+	}
+	fmt.Fprintf(opt.stdout, "total: %s\n", strings.Join(totals, ", "))
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addMetricsCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "metrics [-f compile.json] [-o metrics.txt]",
+		Short:   "Write build metrics in the OpenMetrics text format",
+		Long: `Write the total build time, the cache hit ratio, the time spent in each mode
+and top-level directory and the shape of the graph of actions in the OpenMetrics
+text format, as used by the metrics.txt report of GitLab CI:
+
+    build:
+      script:
+        - go build -debug-actiongraph=compile.json ./...
+        - actiongraph metrics -f compile.json -o metrics.txt
+      artifacts:
+        reports:
+          metrics: metrics.txt
+
+GitLab then shows how the metrics changed in each merge request. The file is
+replaced atomically, so it may also be written to the directory of the
+textfile collector of the Prometheus node_exporter, given a name ending .prom:
+
+    actiongraph metrics -f compile.json -o /var/lib/node_exporter/textfile/build.prom
+
+The shape of the graph describes how parallel the build could be: its depth is
+the number of actions on the longest chain of dependencies, and its width the
+greatest number of actions at the same depth, which could all run at once.
+The critical path is the chain of dependencies taking longest, and the ratio
+of its duration to the total of all actions is the least share of the build
+which no number of workers could run in parallel.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			out, err := flags.GetString("output")
+			if err != nil {
+				return err
+			}
+			var mopt metricsOptions
+			mopt.prefix, err = flags.GetString("prefix")
+			if err != nil {
+				return err
+			}
+			mopt.level, err = flags.GetInt("level")
+			if err != nil {
+				return err
+			}
+
+			write := func(w io.Writer) error { return writeMetrics(w, opt, mopt) }
+			if out == "" || out == "-" {
+				return write(opt.stdout)
+			}
+			return writeFileAtomic(out, write)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringP("output", "o", "-", "file to write the metrics to (use - for stdout)")
+	flags.String("prefix", "actiongraph", "prefix of the metric names")
+	flags.IntP("level", "L", 1, "directory depth of the per-directory metrics")
+	prog.AddCommand(&cmd)
+}
+
+type metricsOptions struct {
+	prefix string
+	level  int
+}
+
+func writeMetrics(w io.Writer, opt *options, mopt metricsOptions) error {
+	// Find the shape of the graph while the actions are totalled.
+	shapes := make(chan shape, 1)
+	go func() { shapes <- graphShape(opt.actions()) }()
+
+	var total time.Duration
+	var count, cached int
+	byDir := map[string]time.Duration{}
+	byMode := map[string]time.Duration{}
+	modeCount := map[string]int{}
+	for _, act := range opt.actions() {
+		total += act.Duration
+		count++
+		byMode[act.Mode] += act.Duration
+		modeCount[act.Mode]++
+		if act.Cached {
+			cached++
+		}
+		if act.Package != "" {
+			byDir[treeDir(treePath(act.Package, act.Std), mopt.level)] += act.Duration
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	name := func(s string) string {
+		if mopt.prefix == "" {
+			return s
+		}
+		return mopt.prefix + "_" + s
+	}
+	// family writes the metadata of a metric ahead of its samples, and gauge
+	// a metric without labels.
+	family := func(metric, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n", name(metric), help)
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", name(metric))
+	}
+	gauge := func(metric, help, format string, v any) {
+		family(metric, help)
+		fmt.Fprintf(bw, "%s "+format+"\n", name(metric), v)
+	}
+	gauge("build_seconds", "Total duration of the actions.", "%.3f", total.Seconds())
+	gauge("wall_seconds", "Time from the start of the first action to the end of the last.", "%.3f", opt.store.wall().Seconds())
+	gauge("actions", "Number of actions.", "%d", count)
+	if count > 0 {
+		gauge("cache_hit_ratio", "Share of the actions taken from the cache.", "%.4f", float64(cached)/float64(count))
+	}
+
+	shape := <-shapes
+	gauge("graph_nodes", "Number of actions in the graph.", "%d", shape.Nodes)
+	gauge("graph_edges", "Number of dependencies between the actions.", "%d", shape.Edges)
+	gauge("graph_depth", "Number of actions on the longest chain of dependencies.", "%d", shape.Depth)
+	gauge("graph_max_width", "Greatest number of actions at the same depth.", "%d", shape.MaxWidth)
+	gauge("graph_max_fan_in", "Greatest number of dependencies of an action.", "%d", shape.MaxFanIn)
+	gauge("graph_max_fan_out", "Greatest number of dependents of an action.", "%d", shape.MaxFanOut)
+	if shape.Nodes > 0 {
+		gauge("graph_mean_fan_in", "Mean number of dependencies of an action.", "%.4f", float64(shape.Edges)/float64(shape.Nodes))
+	}
+	gauge("critical_path_seconds", "Duration of the chain of dependent actions taking longest.", "%.3f", shape.CriticalPath.Seconds())
+	if total > 0 {
+		gauge("critical_path_ratio", "Duration of the critical path over the total of the actions.", "%.4f", float64(shape.CriticalPath)/float64(total))
+	}
+
+	modes := make([]string, 0, len(byMode))
+	for mode := range byMode {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	family("mode_seconds", "Total duration of the actions of each mode.")
+	for _, mode := range modes {
+		fmt.Fprintf(bw, "%s{mode=\"%s\"} %.3f\n", name("mode_seconds"), metricLabelEscaper.Replace(mode), byMode[mode].Seconds())
+	}
+	family("mode_actions", "Number of actions of each mode.")
+	for _, mode := range modes {
+		fmt.Fprintf(bw, "%s{mode=\"%s\"} %d\n", name("mode_actions"), metricLabelEscaper.Replace(mode), modeCount[mode])
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	family("dir_seconds", "Total duration of the actions of the packages in each directory.")
+	for _, dir := range dirs {
+		fmt.Fprintf(bw, "%s{dir=\"%s\"} %.3f\n", name("dir_seconds"), metricLabelEscaper.Replace(dir), byDir[dir].Seconds())
+	}
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
+
+// metricLabelEscaper escapes label values in the OpenMetrics text format.
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// shape describes the structure of a graph of actions.
+type shape struct {
+	Nodes        int
+	Edges        int // Between the actions of the graph, ignoring those to others.
+	Depth        int // Number of actions on the longest chain of dependencies.
+	MaxWidth     int // Greatest number of actions at the same depth.
+	MaxFanIn     int // Greatest number of dependencies of an action. The mean fan-in and fan-out are both Edges/Nodes.
+	MaxFanOut    int // Greatest number of dependents of an action.
+	CriticalPath time.Duration
+}
+
+// graphShape returns the shape of the graph of the actions, following only
+// the dependencies between them. An action's depth is one more than that of
+// its deepest dependency.
+func graphShape(acts []*action) shape {
+	byID := make(map[int]*action, len(acts))
+	for _, act := range acts {
+		byID[act.ID] = act
+	}
+
+	s := shape{Nodes: len(acts)}
+	fanOut := make(map[int]int)
+	depth := make(map[int]int, len(acts))
+	longest := make(map[int]time.Duration, len(acts))
+	var visit func(act *action)
+	visit = func(act *action) {
+		if _, ok := depth[act.ID]; ok {
+			return
+		}
+		depth[act.ID] = 0 // Guard against cycles.
+		d, l := 0, time.Duration(0)
+		for _, id := range act.Deps {
+			dep, ok := byID[id]
+			if !ok {
+				continue
+			}
+			visit(dep)
+			if depth[id] > d {
+				d = depth[id]
+			}
+			if longest[id] > l {
+				l = longest[id]
+			}
+		}
+		depth[act.ID], longest[act.ID] = d+1, l+act.Duration
+	}
+
+	width := make(map[int]int)
+	for _, act := range acts {
+		visit(act)
+		var fanIn int
+		for _, id := range act.Deps {
+			if _, ok := byID[id]; ok {
+				fanIn++
+				fanOut[id]++
+			}
+		}
+		s.Edges += fanIn
+		if fanIn > s.MaxFanIn {
+			s.MaxFanIn = fanIn
+		}
+		d := depth[act.ID]
+		width[d]++
+		if d > s.Depth {
+			s.Depth = d
+		}
+		if width[d] > s.MaxWidth {
+			s.MaxWidth = width[d]
+		}
+		if longest[act.ID] > s.CriticalPath {
+			s.CriticalPath = longest[act.ID]
+		}
+	}
+	for _, n := range fanOut {
+		if n > s.MaxFanOut {
+			s.MaxFanOut = n
+		}
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// modules identifies the module owning each package. Module paths are learned
+// from go list, when the actions have been enriched, the module cache
+// directories that appear in each action's Cmd, and the main modules.
+type modules struct {
+	paths []string // Longest first.
+}
+
+// modCacheDir matches the module cache directories in a command line, like
+// /home/me/go/pkg/mod/github.com/!burnt!sushi/toml@v1.2.1/.
+var modCacheDir = regexp.MustCompile(`([^\s"'=]+)@(v[^/\s"']+)/`)
+
+func loadModules(actions []action, main []string) *modules {
+	seen := make(map[string]bool)
+	for _, mod := range main {
+		seen[mod] = true
+	}
+	for _, act := range actions {
+		if act.Module != "" {
+			seen[act.Module] = true
+		}
+		for _, line := range cmdLines(act.Cmd) {
+			for _, m := range modCacheDir.FindAllStringSubmatch(line, -1) {
+				if mod := moduleSuffix(unescapeModulePath(m[1]), act.importPath()); mod != "" {
+					seen[mod] = true
+				}
+			}
+		}
+	}
+
+	m := modules{paths: make([]string, 0, len(seen))}
+	for mod := range seen {
+		m.paths = append(m.paths, mod)
+	}
+	sort.Slice(m.paths, func(i, j int) bool {
+		if len(m.paths[i]) != len(m.paths[j]) {
+			return len(m.paths[i]) > len(m.paths[j])
+		}
+		return m.paths[i] < m.paths[j]
+	})
+	return &m
+}
+
+// module returns the path of the module providing pkg. Standard library
+// packages belong to std, and packages from unknown modules are treated as
+// their own module.
+func (m *modules) module(pkg string) string {
+	if isStdlib(pkg) {
+		return "std"
+	}
+	for _, mod := range m.paths {
+		if pkgInModule(pkg, mod) {
+			return mod
+		}
+	}
+	return pkg
+}
+
+// goListMainModules asks the go command for the main modules of the current
+// directory, which may be several in a workspace.
+func goListMainModules(ctx context.Context) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "go", "list", "-m").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("go list -m: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("go list -m: %w", err)
+	}
+
+	// Outside of a module, go list reports the pseudo-module of the files
+	// named on the command line.
+	var mods []string
+	for _, mod := range strings.Fields(string(out)) {
+		if mod != "command-line-arguments" {
+			mods = append(mods, mod)
+		}
+	}
+	if len(mods) == 0 {
+		return nil, errors.New("go list -m: not in a module")
+	}
+	return mods, nil
+}
+
+// inModules reports whether pkg, or the package it is a test variant of, is
+// within any of mods.
+func inModules(pkg string, mods []string) bool {
+	pkg, _, _ = strings.Cut(pkg, " ")
+	pkg = strings.TrimSuffix(pkg, ".test")
+	for _, mod := range mods {
+		if pkgInModule(pkg, mod) {
+			return true
+		}
+	}
+	return false
+}
+
+func pkgInModule(pkg, mod string) bool {
+	return pkg == mod || strings.HasPrefix(pkg, mod) && pkg[len(mod)] == '/'
+}
+
+// moduleSuffix returns the longest trailing part of dir which is a module
+// containing pkg.
+func moduleSuffix(dir, pkg string) string {
+	for i := 0; i < len(dir); i++ {
+		if i > 0 && dir[i-1] != '/' {
+			continue
+		}
+		if mod := dir[i:]; pkgInModule(pkg, mod) {
+			return mod
+		}
+	}
+	return ""
+}
+
+// unescapeModulePath reverses the module cache's case-encoding of paths, in
+// which each upper-case letter is written as ! followed by its lower case.
+func unescapeModulePath(p string) string {
+	if !strings.Contains(p, "!") {
+		return p
+	}
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '!' && i+1 < len(p) {
+			i++
+			b.WriteString(strings.ToUpper(p[i : i+1]))
+			continue
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
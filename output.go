@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// outputFormats are the values of --format chosen by the extension of the
+// --output file, when --format isn't given.
+var outputFormats = map[string]string{
+	".csv":  "csv",
+	".dot":  "dot",
+	".html": "html",
+	".json": "json",
+	".md":   "markdown",
+	".svg":  "svg",
+}
+
+// output is the file named by the global --output flag, which the command's
+// output is written to in place of stdout.
+type output struct {
+	file *atomicFile
+}
+
+// addOutputFlag adds the global --output flag to prog. The file is created
+// before the command runs, and must be closed with the command's error.
+func addOutputFlag(prog *cobra.Command) *output {
+	o := &output{}
+	prog.PersistentFlags().String("output", "", "write the output to a file, replaced only once complete, choosing the --format from its extension (.json, .csv, .svg, .html, .dot)")
+	prog.PersistentPreRunE = o.open
+	return o
+}
+
+func (o *output) open(cmd *cobra.Command, args []string) error {
+	// badge, metrics and baseline save have their own --output, which
+	// shadows this one.
+	path, err := cmd.Root().PersistentFlags().GetString("output")
+	if err != nil {
+		return err
+	}
+	if path == "" || path == "-" {
+		return nil
+	}
+
+	// Setting the value directly leaves the flag unchanged, so that --tpl
+	// isn't mistaken for conflicting with it.
+	if format := cmd.Flags().Lookup("format"); format != nil && !format.Changed {
+		if v, ok := outputFormats[strings.ToLower(filepath.Ext(path))]; ok {
+			if err := format.Value.Set(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	o.file, err = createAtomic(path)
+	if err != nil {
+		return err
+	}
+	cmd.Root().SetOut(o.file)
+	return nil
+}
+
+// close moves the file into place if the command succeeded, or else removes
+// it, returning the command's error. The output of a command failing only with
+// an exit status, such as for exceeding --fail-over, is complete and so kept.
+func (o *output) close(err error) error {
+	if o.file == nil {
+		return err
+	}
+	var exit *exitError
+	if err != nil && !errors.As(err, &exit) {
+		o.file.abort()
+		return err
+	}
+	if cerr := o.file.commit(); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addOwnersCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "owners [-f compile.json] --codeowners CODEOWNERS",
+		Short:   "Total build times by CODEOWNERS team",
+		Long: `Total the time spent building the packages owned by each team in a
+CODEOWNERS file. Packages with several owners count towards each of them.
+
+Packages are located within the repository using their directory, if the
+actions were enriched by --enrich-golist, or else their path within the main
+module, which is assumed to be at the root of the repository. Packages outside
+of the repository are totalled as (external), and those within it which have
+no owner as (unowned).`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			path, err := flags.GetString("codeowners")
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			co, err := parseCodeowners(f)
+			if err != nil {
+				return err
+			}
+			root, err := filepath.Abs(codeownersRoot(path))
+			if err != nil {
+				return err
+			}
+
+			// The main module is only needed to place packages which
+			// weren't enriched with their directory.
+			main, _ := opt.mainModules(cmd.Context())
+
+			out, err := newRowWriter(cmd, opt, ownersFormats)
+			if err != nil {
+				return err
+			}
+
+			return owners(opt, co, root, main, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("codeowners", "CODEOWNERS", "path to the CODEOWNERS file")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var ownersFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Packages", tpl: `{{ .Count }}`},
+		{name: "Owner", tpl: `{{ .Owner }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Owner", tpl: `{{ .Owner }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Packages", tpl: `{{ .Count }}`},
+		{name: "Mean", tpl: `{{ .Mean | seconds }}`},
+		{name: "Owner", tpl: `{{ .Owner }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "owner", tpl: `{{ .Owner }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "count", tpl: `{{ .Count }}`},
+	},
+}
+
+func owners(opt *options, co *codeowners, root string, main []string, out *rowWriter) error {
+	totals := map[string]*ownerTotal{}
+	add := func(owner string, d time.Duration) {
+		t := totals[owner]
+		if t == nil {
+			t = &ownerTotal{Owner: owner}
+			totals[owner] = t
+		}
+		t.Duration += d
+		t.Count++
+	}
+
+	for _, act := range opt.actions() {
+		if act.Mode != "build" {
+			continue
+		}
+		dir, ok := repoDir(act, root, main)
+		if !ok {
+			add("(external)", act.Duration)
+			continue
+		}
+		owners := co.dirOwners(dir)
+		if len(owners) == 0 {
+			add("(unowned)", act.Duration)
+			continue
+		}
+		for _, owner := range owners {
+			add(owner, act.Duration)
+		}
+	}
+
+	rows := make([]*ownerTotal, 0, len(totals))
+	for _, t := range totals {
+		t.Percent = opt.store.percent(t.Duration)
+		rows = append(rows, t)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Duration != rows[j].Duration {
+			return rows[i].Duration > rows[j].Duration
+		}
+		return rows[i].Owner < rows[j].Owner
+	})
+	for _, row := range rows {
+		if err := out.row(row); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+type ownerTotal struct {
+	Owner    string
+	Duration time.Duration
+	Percent  float64
+	Count    int // Number of packages.
+}
+
+// Mean returns the mean duration of the owner's packages.
+func (t *ownerTotal) Mean() time.Duration {
+	if t.Count == 0 {
+		return 0
+	}
+	return t.Duration / time.Duration(t.Count)
+}
+
+// repoDir returns the slash-separated directory of the action's package
+// relative to the repository root, if it is within the repository.
+func repoDir(act *action, root string, main []string) (string, bool) {
+	if act.Dir != "" {
+		rel, err := filepath.Rel(root, act.Dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+		return filepath.ToSlash(rel), true
+	}
+
+	pkg, _, _ := strings.Cut(act.importPath(), " ")
+	for _, mod := range main {
+		if pkgInModule(pkg, mod) {
+			return "." + strings.TrimPrefix(pkg, mod), true
+		}
+	}
+	return "", false
+}
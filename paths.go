@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func addPathsCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "paths [-f compile.json] [--list [-n limit]] FROM TO",
+		Short:   "Count the chains of imports between two packages",
+		Long: `Count the distinct chains of imports from the package FROM to the package TO,
+to see how entangled they are before decoupling them. With --list, the chains
+are listed too, up to the --limit.
+
+Either may be a glob, as used by tree --exclude, such as example.com/app/... to
+count the chains between two components. The chains then start at a package of
+FROM and end at the first package of TO that they reach, without passing
+through any other package of FROM.`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completePackages(true),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			list, err := flags.GetBool("list")
+			if err != nil {
+				return err
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			from, err := parsePkgGlob(args[0])
+			if err != nil {
+				return err
+			}
+			to, err := parsePkgGlob(args[1])
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, importChainFormats)
+			if err != nil {
+				return err
+			}
+			return paths(opt, from, to, list, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.Bool("list", false, "list the chains as well as counting them")
+	flags.IntP("limit", "n", 100, "with --list, the number of chains to list")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+// paths counts the chains of imports from the packages matching from to those
+// matching to, listing them up to the limit if list is set.
+func paths(opt *options, from, to pkgGlob, list bool, limit int, out *rowWriter) error {
+	g := newImportGraph(opt.store)
+	var starts []int
+	isStart := make(map[int]bool)
+	ends := make(map[int]bool)
+	for _, act := range g.acts {
+		match := func(glob pkgGlob) bool {
+			return glob.Match(act.Package) || glob.Match(treePath(act.Package, act.Std))
+		}
+		if match(from) {
+			starts = append(starts, act.ID)
+			isStart[act.ID] = true
+		} else if match(to) {
+			ends[act.ID] = true
+		}
+	}
+	switch {
+	case len(starts) == 0:
+		return fmt.Errorf("could not find package %q", from)
+	case len(ends) == 0:
+		return fmt.Errorf("could not find package %q", to)
+	}
+
+	if list {
+		var listed int
+		var err error
+		g.walkPaths(starts, ends, isStart, func(path []int) bool {
+			if err = out.row(g.chain(path)); err != nil {
+				return false
+			}
+			listed++
+			return limit <= 0 || listed < limit
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+	fmt.Fprintf(opt.stdout, "%s import chains from %s to %s", g.countPaths(starts, ends, isStart), from, to)
+	if len(starts) > 1 || len(ends) > 1 {
+		fmt.Fprintf(opt.stdout, ", of %d and %d packages", len(starts), len(ends))
+	}
+	fmt.Fprintln(opt.stdout)
+	return nil
+}
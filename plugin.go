@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// pluginPrefix begins the name of the executables providing the commands
+// which actiongraph doesn't: actiongraph-foo provides the command foo.
+const pluginPrefix = "actiongraph-"
+
+// addPluginCommand adds a command running the plugin named by the first of
+// args, if it isn't one of prog's own commands and a plugin for it is found
+// on the PATH.
+func addPluginCommand(prog *cobra.Command, args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return
+	}
+	name := args[0]
+	switch name {
+	case "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return
+	}
+	for _, c := range prog.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return
+		}
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return
+	}
+
+	cmd := cobra.Command{
+		Use:   name + " [-f compile.json] [-- plugin args]",
+		Short: "Run the plugin " + path,
+		Long: `Run the plugin ` + path + `, giving it the actions on stdin as a JSON
+array, including the fields derived by actiongraph as for query. The arguments
+are passed to the plugin, but the flags are actiongraph's own, so those for the
+plugin must follow --.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+			b, err := json.Marshal(opt.actions())
+			if err != nil {
+				return err
+			}
+
+			c := exec.CommandContext(cmd.Context(), path, args...)
+			c.Stdin, c.Stdout, c.Stderr = bytes.NewReader(b), opt.stdout, opt.stderr
+			if err := c.Run(); err != nil {
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					return &exitError{exitErr.ExitCode(), fmt.Errorf("%s: %w", pluginPrefix+name, err)}
+				}
+				return fmt.Errorf("%s: %w", pluginPrefix+name, err)
+			}
+			return nil
+		},
+	}
+	prog.AddCommand(&cmd)
+}
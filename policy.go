@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// budget is an entry of the file given to assert --policy, limiting the time
+// taken by the packages matching a glob.
+type budget struct {
+	Package    string        `yaml:"package"`
+	Mode       string        `yaml:"mode"`       // Of the actions counted, by default build.
+	Self       time.Duration `yaml:"self"`       // Largest duration of each of the packages.
+	Cumulative time.Duration `yaml:"cumulative"` // Largest total duration of all of the packages.
+
+	glob pkgGlob
+}
+
+// readPolicy reads the file given by --policy: a YAML or JSON list of the
+// budgets, as described by the assert command's help.
+func readPolicy(path string) ([]*budget, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var budgets []*budget
+	if err := yaml.Unmarshal(b, &budgets); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, bud := range budgets {
+		if bud.Package == "" {
+			return nil, fmt.Errorf("%s: budget %d: no package", path, i+1)
+		}
+		if bud.Self <= 0 && bud.Cumulative <= 0 {
+			return nil, fmt.Errorf("%s: %s: no self or cumulative budget", path, bud.Package)
+		}
+		if bud.Mode == "" {
+			bud.Mode = "build"
+		}
+		bud.glob, err = parsePkgGlob(bud.Package)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return budgets, nil
+}
+
+// checkPolicy returns a description of each of the budgets which the actions
+// exceeded. The packages of the standard library are matched under std/, as
+// by tree --exclude.
+func checkPolicy(opt *options, budgets []*budget) []string {
+	var failures []string
+	for _, bud := range budgets {
+		self := make(map[string]time.Duration)
+		var total time.Duration
+		for _, act := range opt.actions() {
+			if act.Package == "" || act.Mode != bud.Mode {
+				continue
+			}
+			if pkg := treePath(act.Package, act.Std); bud.glob.Match(pkg) {
+				self[pkg] += act.Duration
+				total += act.Duration
+			}
+		}
+		if bud.Cumulative > 0 && total > bud.Cumulative {
+			failures = append(failures, fmt.Sprintf("%s: %.3fs in %d packages exceeds the cumulative budget of %s",
+				bud.Package, total.Seconds(), len(self), bud.Cumulative))
+		}
+		if bud.Self <= 0 {
+			continue
+		}
+		pkgs := make([]string, 0, len(self))
+		for pkg, d := range self {
+			if d > bud.Self {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			failures = append(failures, fmt.Sprintf("%s %s: %.3fs exceeds the budget of %s for %s",
+				bud.Mode, pkg, self[pkg].Seconds(), bud.Self, bud.Package))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addPRCommentCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "pr-comment --base base.json --head head.json",
+		Short:   "Markdown summary of the change in build time between two builds",
+		Long: `Render a Markdown comment comparing the build of a pull request (--head)
+with the build of its target branch (--base), ready to be posted to the pull
+request by CI. The comment includes the change in total build time, the
+packages which regressed or improved the most, and the packages which were
+rebuilt in head though they had been cached, or not built, in base.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := rejectStoreFlags(cmd); err != nil {
+				return err
+			}
+			opt := newOptions(cmd)
+
+			flags := cmd.Flags()
+			basePath, err := flags.GetString("base")
+			if err != nil {
+				return err
+			}
+			headPath, err := flags.GetString("head")
+			if err != nil {
+				return err
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			builds, err := loadStoreFiles(basePath, headPath)
+			if err != nil {
+				return err
+			}
+
+			return prComment(opt, builds[0], builds[1], limit)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("base", "", "actiongraph JSON file of the base build")
+	flags.String("head", "", "actiongraph JSON file of the head build")
+	flags.IntP("limit", "n", 10, "number of packages to list in each section")
+	cmd.MarkFlagRequired("base")
+	cmd.MarkFlagRequired("head")
+	prog.AddCommand(&cmd)
+}
+
+const prCommentTemplate = `### Build time {{ .Head | seconds }} ({{ .Delta | signed }}{{ with .Percent }}, {{ printf "%+.1f%%" . }}{{ end }})
+{{ with .Warning }}
+> **Warning:** {{ . }}
+{{ end }}
+| | Base | Head | Change |
+| --- | ---: | ---: | ---: |
+| Total | {{ .Base | seconds }} | {{ .Head | seconds }} | {{ .Delta | signed }} |
+| Actions | {{ .BaseCount }} | {{ .HeadCount }} | {{ printf "%+d" (sub .HeadCount .BaseCount) }} |
+{{ template "packages" (section "Largest regressions" .Regressions) }}
+{{- template "packages" (section "Largest improvements" .Improvements) }}
+{{- template "packages" (section "Newly rebuilt packages" .Rebuilt) }}
+{{- define "packages" }}{{ if .Deltas }}
+<details><summary>{{ .Title }}</summary>
+
+| Package | Mode | Base | Head | Change |
+| --- | --- | ---: | ---: | ---: |
+{{ range .Deltas }}| ` + "`{{ .Package }}`" + ` | {{ .Mode }} | {{ if .InBase }}{{ .Base | seconds }}{{ else }}-{{ end }} | {{ if .InHead }}{{ .Head | seconds }}{{ else }}-{{ end }} | {{ .Delta | signed }} |
+{{ end }}
+</details>
+{{ end }}{{ end }}`
+
+type prCommentData struct {
+	Base, Head, Delta    time.Duration
+	Percent              float64
+	BaseCount, HeadCount int
+	Warning              string
+
+	Regressions, Improvements, Rebuilt []pkgDelta
+}
+
+func prComment(opt *options, base, head *store, limit int) error {
+	data := prCommentData{
+		Base:      base.total,
+		Head:      head.total,
+		Delta:     head.total - base.total,
+		BaseCount: len(base.actions),
+		HeadCount: len(head.actions),
+		Warning:   instrumentationWarning(base, head),
+	}
+	if base.total > 0 {
+		data.Percent = 100 * float64(data.Delta) / float64(base.total)
+	}
+
+	deltas := compareStores(base, head)
+	for _, d := range deltas {
+		if d.Delta > 0 && len(data.Regressions) < limit {
+			data.Regressions = append(data.Regressions, d)
+		}
+		if d.Rebuilt() && len(data.Rebuilt) < limit {
+			data.Rebuilt = append(data.Rebuilt, d)
+		}
+	}
+	for i := len(deltas) - 1; i >= 0 && len(data.Improvements) < limit; i-- {
+		if deltas[i].Delta < 0 {
+			data.Improvements = append(data.Improvements, deltas[i])
+		}
+	}
+
+	tpl, err := template.New("pr-comment").Funcs(opt.funcs).Funcs(template.FuncMap{
+		"signed": signedSeconds,
+		"sub":    func(a, b int) int { return a - b },
+		"section": func(title string, deltas []pkgDelta) any {
+			return struct {
+				Title  string
+				Deltas []pkgDelta
+			}{title, deltas}
+		},
+	}).Parse(prCommentTemplate)
+	if err != nil {
+		return fmt.Errorf("parsing template: %w", err)
+	}
+	return tpl.Execute(opt.stdout, data)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addPriorityCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "priority [-f compile.json] [-n limit] [--all]",
+		Short:   "Compare the go command's priorities with when the actions ran",
+		Long: `List the actions on the critical path which waited for a worker once their
+dependencies had finished, with the actions off the critical path which the go
+command started while they waited. Every moment spent waiting on the critical
+path lengthens the build, so these are the cases where the go command's
+priorities, which favour actions in the order of a depth-first walk of the
+graph, delayed it. With --all, the actions off the critical path which waited
+are listed too.
+
+Beneath the table, the rank correlation of the priorities with the order in
+which the actions started shows how closely the go command kept to them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			all, err := flags.GetBool("all")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, priorityFormats)
+			if err != nil {
+				return err
+			}
+			return priorities(opt, limit, all, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of actions to show")
+	flags.Bool("all", false, "list the actions off the critical path which waited too")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var priorityFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Wait", tpl: `{{ .Wait | colordur }}`},
+		{name: "Priority", tpl: `{{ .Priority }}`},
+		{name: "Order", tpl: `{{ .Order }}`},
+		{name: "Overtaken", tpl: `{{ .Overtaken }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Wait", tpl: `{{ .Wait | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "ID", tpl: `{{ .ID }}`},
+		{name: "Wait", tpl: `{{ .Wait | colordur }}`},
+		{name: "Priority", tpl: `{{ .Priority }}`},
+		{name: "Order", tpl: `{{ .Order }}`},
+		{name: "Overtaken", tpl: `{{ .Overtaken }}`},
+		{name: "Critical", tpl: `{{ if .CriticalPath }}critical{{ end }}`, left: true},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Ahead", tpl: `{{ .Ahead | join " " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "id", tpl: `{{ .ID }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "wait", tpl: `{{ .Wait.Seconds | printf "%.3f" }}`},
+		{name: "priority", tpl: `{{ .Priority }}`},
+		{name: "order", tpl: `{{ .Order }}`},
+		{name: "overtaken", tpl: `{{ .Overtaken }}`},
+		{name: "critical_path", tpl: `{{ .CriticalPath }}`},
+	},
+}
+
+// priorityAction is an action which waited for a worker, with those which
+// the go command started ahead of it.
+type priorityAction struct {
+	ID           int
+	Mode         string
+	Package      string
+	Priority     int           // Given by the go command, which starts the ready actions with the lowest first.
+	Order        int           // Position of the action among all of them, by when they started.
+	Wait         time.Duration // From TimeReady to TimeStart.
+	CriticalPath bool
+	Overtaken    int      // Number of actions off the critical path which started while this one waited.
+	Ahead        []string // Packages of the actions, with a lower priority, which overtook this one.
+}
+
+// priorities lists the actions which waited longest for a worker, those on
+// the critical path unless all is set, followed by a summary of how the
+// priorities related to the order the actions started in when written as
+// text.
+func priorities(opt *options, limit int, all bool, out *rowWriter) error {
+	acts := opt.actions()
+	started := make([]*action, 0, len(acts))
+	for _, act := range acts {
+		if !act.TimeStart.IsZero() {
+			started = append(started, act)
+		}
+	}
+	sort.SliceStable(started, func(i, j int) bool {
+		return started[i].TimeStart.Before(started[j].TimeStart)
+	})
+	order := make(map[int]int, len(started))
+	for i, act := range started {
+		order[act.ID] = i
+	}
+
+	var rows []*priorityAction
+	var critical, waited int
+	var criticalWait time.Duration
+	for _, act := range started {
+		if act.Wait <= 0 || (!all && !act.CriticalPath) {
+			continue
+		}
+		p := &priorityAction{
+			ID:           act.ID,
+			Mode:         act.Mode,
+			Package:      act.Package,
+			Priority:     act.Priority,
+			Order:        order[act.ID],
+			Wait:         act.Wait,
+			CriticalPath: act.CriticalPath,
+		}
+		for _, other := range started[:order[act.ID]] {
+			if other.TimeStart.After(act.TimeReady) && !other.CriticalPath {
+				p.Overtaken++
+				if other.Priority < act.Priority {
+					p.Ahead = append(p.Ahead, other.Package)
+				}
+			}
+		}
+		if act.CriticalPath {
+			critical++
+			criticalWait += act.Wait
+			if p.Overtaken > 0 {
+				waited++
+			}
+		}
+		rows = append(rows, p)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Wait > rows[j].Wait
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	for _, p := range rows {
+		if err := out.row(p); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	fmt.Fprintf(opt.stdout, "%.3fs (%.2f%% of the wall time) waited by %d actions on the critical path, %d of them overtaken by others\n",
+		criticalWait.Seconds(), percentOf(criticalWait, opt.store.wall()), critical, waited)
+	if rho, ok := priorityCorrelation(started); ok {
+		fmt.Fprintf(opt.stdout, "%.3f rank correlation of the priorities with the order the actions started\n", rho)
+	}
+	return nil
+}
+
+// percentOf returns d as a percentage of total, or zero if total is zero.
+func percentOf(d, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(d) / float64(total)
+}
+
+// priorityCorrelation returns Spearman's rank correlation of the actions'
+// priorities with their order, which is 1 when they started in the order of
+// their priorities. The priorities are unique, as are the positions.
+func priorityCorrelation(started []*action) (float64, bool) {
+	n := len(started)
+	if n < 2 {
+		return 0, false
+	}
+	byPriority := make([]int, n)
+	for i := range byPriority {
+		byPriority[i] = i
+	}
+	sort.SliceStable(byPriority, func(i, j int) bool {
+		return started[byPriority[i]].Priority < started[byPriority[j]].Priority
+	})
+	var sum float64
+	for rank, i := range byPriority {
+		d := float64(rank - i)
+		sum += d * d
+	}
+	return 1 - 6*sum/(float64(n)*(float64(n)*float64(n)-1)), true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+	"github.com/spf13/cobra"
+)
+
+func addQueryCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "query [-f compile.json] FILTER",
+		Short:   "Query the actions with a jq filter",
+		Long: `Run a jq filter over the array of actions, printing each result as JSON.
+The actions include the fields derived by actiongraph, such as Duration (in
+nanoseconds) and Percent. For example:
+
+    actiongraph query -f compile.json 'map(select(.Mode=="build")) | sort_by(-.Duration)[:5] | map(.Package)'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q, err := gojq.Parse(args[0])
+			if err != nil {
+				return fmt.Errorf("parsing filter: %w", err)
+			}
+			code, err := gojq.Compile(q)
+			if err != nil {
+				return fmt.Errorf("compiling filter: %w", err)
+			}
+
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			raw, err := flags.GetBool("raw-output")
+			if err != nil {
+				return err
+			}
+			compact, err := flags.GetBool("compact-output")
+			if err != nil {
+				return err
+			}
+
+			return query(opt, code, raw, compact)
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolP("raw-output", "r", false, "print string results without quotes")
+	flags.BoolP("compact-output", "c", false, "print each result on a single line")
+	prog.AddCommand(&cmd)
+}
+
+func query(opt *options, code *gojq.Code, raw, compact bool) error {
+	// gojq works on the types produced by decoding JSON, so round-trip the
+	// actions through it.
+	b, err := json.Marshal(opt.actions())
+	if err != nil {
+		return err
+	}
+	var input any
+	if err := json.Unmarshal(b, &input); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(opt.stdout)
+	enc.SetEscapeHTML(false)
+	if !compact {
+		enc.SetIndent("", "  ")
+	}
+	iter := code.Run(input)
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			return nil
+		}
+		switch v := v.(type) {
+		case error:
+			return v
+		case string:
+			if raw {
+				fmt.Fprintln(opt.stdout, v)
+				continue
+			}
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// packageRewriter returns the function shortening the package paths shown by
+// commands: removing the first of the prefixes given by --trim-prefix which
+// each path has, and then applying each of the rewrites given by --rewrite as
+// 'REGEXP=>REPLACEMENT', in order. The replacements may refer to the
+// expression's submatches as $1 or ${name}.
+func packageRewriter(trims, rewrites []string) (func(string) string, error) {
+	type rewrite struct {
+		re   *regexp.Regexp
+		repl string
+	}
+	var rs []rewrite
+	for _, r := range rewrites {
+		expr, repl, ok := strings.Cut(r, "=>")
+		if !ok {
+			return nil, fmt.Errorf("--rewrite %q: expected REGEXP=>REPLACEMENT", r)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("--rewrite %q: %w", r, err)
+		}
+		rs = append(rs, rewrite{re, repl})
+	}
+
+	return func(pkg string) string {
+		for _, prefix := range trims {
+			if p, ok := strings.CutPrefix(pkg, prefix); ok && p != "" {
+				pkg = p
+				break
+			}
+		}
+		for _, r := range rs {
+			pkg = r.re.ReplaceAllString(pkg, r.repl)
+		}
+		return pkg
+	}, nil
+}
+
+// rewritePackages renames the packages of the actions by rewrite.
+func (s *store) rewritePackages(rewrite func(string) string) {
+	for i := range s.actions {
+		act := &s.actions[i]
+		if act.Package != "" {
+			act.imported = act.importPath()
+			act.Package = rewrite(act.Package)
+		}
+		for j := range act.Variants {
+			act.Variants[j].Package = rewrite(act.Variants[j].Package)
+		}
+	}
+	s.renamed = append(s.renamed, rewrite)
+}
+
+// shownPath returns the path shown for the import path pkg, renamed as the
+// packages of the actions were.
+func (s *store) shownPath(pkg string) string {
+	for _, rename := range s.renamed {
+		pkg = rename(pkg)
+	}
+	return pkg
+}
+
+// readAliases reads the file given by --aliases: a YAML or JSON object mapping
+// package path prefixes to the names of the components they make up.
+func readAliases(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var aliases map[string]string
+	if err := yaml.Unmarshal(b, &aliases); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for prefix, name := range aliases {
+		if name == "" {
+			return nil, fmt.Errorf("%s: no name for %q", path, prefix)
+		}
+	}
+	return aliases, nil
+}
+
+// alias returns the name of the component of pkg, by the longest of the
+// prefixes of aliases which is pkg or a directory containing it, with the
+// remainder of the path beneath the name.
+func alias(aliases map[string]string, pkg string) (component, renamed string) {
+	var prefix string
+	for p := range aliases {
+		if len(p) > len(prefix) && (pkg == p || strings.HasPrefix(pkg, strings.TrimSuffix(p, "/")+"/")) {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return "", pkg
+	}
+	component = aliases[prefix]
+	if rest := strings.TrimPrefix(pkg[len(strings.TrimSuffix(prefix, "/")):], "/"); rest != "" {
+		return component, component + "/" + rest
+	}
+	return component, component
+}
+
+// aliasPackages sets the Component of each of the actions, and replaces the
+// prefix of its package with the name of the component.
+func (s *store) aliasPackages(aliases map[string]string) {
+	for i := range s.actions {
+		act := &s.actions[i]
+		if act.Package == "" {
+			continue
+		}
+		act.imported = act.importPath()
+		act.Component, act.Package = alias(aliases, act.Package)
+		for j := range act.Variants {
+			_, act.Variants[j].Package = alias(aliases, act.Variants[j].Package)
+		}
+	}
+	s.renamed = append(s.renamed, func(pkg string) string {
+		_, pkg = alias(aliases, pkg)
+		return pkg
+	})
+}
+
+// unvendor returns the path of the module package vendored at pkg, as in
+// "github.com/org/repo/vendor/golang.org/x/net/html" by GOPATH builds, or pkg
+// if it isn't vendored.
+func unvendor(pkg string) string {
+	if i := strings.LastIndex(pkg, "/vendor/"); i >= 0 {
+		return pkg[i+len("/vendor/"):]
+	}
+	return strings.TrimPrefix(pkg, "vendor/")
+}
+
+// unvendorPackages maps the vendored packages of the actions back onto the
+// paths of their modules, so that vendored and module builds aggregate alike.
+// The standard library's vendored packages are its own copies, so they're
+// left alone.
+func (s *store) unvendorPackages() {
+	for i := range s.actions {
+		if act := &s.actions[i]; !act.Std {
+			act.Package = unvendor(act.Package)
+		}
+	}
+}
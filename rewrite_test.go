@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestFilterBeforeTrim checks that --filter is evaluated against the import
+// paths, before --trim-prefix shortens them.
+func TestFilterBeforeTrim(t *testing.T) {
+	out := runOutput(t, demoFile, ".json", "top", "-n", "0", "--trim-prefix", "k8s.io/", "--filter", `Package startsWith "k8s.io/api/"`)
+	var rows int
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var row struct{ Package string }
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(row.Package, "api/") {
+			t.Errorf("top listed %s, want it trimmed of k8s.io/", row.Package)
+		}
+		rows++
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if rows == 0 {
+		t.Error("top listed no packages of k8s.io/api")
+	}
+}
+
+// TestGroupByModuleTrimmed checks that the modules of the packages are found
+// by their import paths, however they're renamed.
+func TestGroupByModuleTrimmed(t *testing.T) {
+	want := runOutput(t, demoFile, ".csv", "top", "--group-by", "module")
+	got := runOutput(t, demoFile, ".csv", "top", "--group-by", "module", "--trim-prefix", "k8s.io/", "--rewrite", "^github.com/=>gh/")
+	if !bytes.Equal(got, want) {
+		t.Errorf("top --group-by module with --trim-prefix and --rewrite gave:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
+)
+
+// jsonRows are values of the types written by each command for -o json, one
+// object per line. The tree command writes a single object, nesting its
+// Children.
+var jsonRows = map[string]any{
+	"aggregate":   pkgAggregate{},
+	"bench":       pkgAggregate{},
+	"buildids":    buildIDAnomaly{},
+	"cgo":         action{},
+	"chains":      chain{},
+	"correlate":   correlateAction{},
+	"diff":        pkgDelta{},
+	"dominators":  dominator{},
+	"duplicates":  duplicate{},
+	"flagmisses":  flagMiss{},
+	"gocache":     cacheEntry{},
+	"idle":        idleGap{},
+	"matrix":      pkgMatrix{},
+	"owners":      ownerTotal{},
+	"paths":       importChain{},
+	"priority":    priorityAction{},
+	"sizes":       artifact{},
+	"speedup":     speedupReport{},
+	"split":       splitAction{},
+	"test":        testBinary{},
+	"testdeps":    testDep{},
+	"testtimes":   testTime{},
+	"timeline":    timelineBucket{},
+	"top":         topAction{},
+	"trace":       traceAction{},
+	"tree":        treeNode{},
+	"trend":       trendPoint{},
+	"utilization": concurrencyLevel{},
+	"warm":        warmModule{},
+	"why":         importChain{},
+}
+
+func addSchemaCommand(prog *cobra.Command) {
+	names := maps.Keys(jsonRows)
+	sort.Strings(names)
+
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "schema [command]",
+		Short:   "Print the JSON schema of the commands' JSON output",
+		Long: `Print the JSON Schema describing each object written by the command with
+-o json, or by each of the commands keyed by their names: ` + strings.Join(names, ", ") + `.
+
+Durations are given in nanoseconds.`,
+		Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: names,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt := newOptions(cmd)
+
+			var doc any
+			if len(args) == 1 {
+				doc = jsonSchema(args[0], jsonRows[args[0]])
+			} else {
+				all := make(map[string]any, len(names))
+				for _, name := range names {
+					all[name] = jsonSchema(name, jsonRows[name])
+				}
+				doc = all
+			}
+			enc := json.NewEncoder(opt.stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(doc)
+		},
+	}
+	prog.AddCommand(&cmd)
+}
+
+// jsonSchema returns the JSON Schema of v's type as encoded by encoding/json,
+// titled for the command writing it.
+func jsonSchema(command string, v any) map[string]any {
+	s := schemaBuilder{defs: make(map[string]any)}
+	schema := s.of(reflect.TypeOf(v))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "actiongraph " + command + " -o json"
+	schema["$defs"] = s.defs
+	return schema
+}
+
+// schemaBuilder collects the definitions of the struct types, which are
+// referred to by name so that recursive types can be described.
+type schemaBuilder struct {
+	defs map[string]any
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+func (s *schemaBuilder) of(t reflect.Type) map[string]any {
+	switch t {
+	case durationType:
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{s.of(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": []string{"array", "null"}, "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := s.defs[name]; !ok {
+			s.defs[name] = nil // Guard against recursion.
+			s.defs[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	default:
+		// Interfaces can hold anything.
+		return map[string]any{}
+	}
+}
+
+// object returns the schema of the struct type t, whose fields include those
+// of the structs it embeds.
+func (s *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for _, f := range jsonFields(t) {
+		props[f.name] = s.of(f.typ)
+		if !f.omitempty {
+			required = append(required, f.name)
+		}
+	}
+	sort.Strings(required)
+	return map[string]any{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+// jsonField is a field of a struct as encoded by encoding/json.
+type jsonField struct {
+	name      string
+	typ       reflect.Type
+	omitempty bool
+	depth     int  // Of embedding.
+	tagged    bool // Whether the name was given by a tag.
+}
+
+// jsonFields returns the fields of the struct type t in the way encoding/json
+// encodes them: promoting the fields of embedded structs, and keeping the
+// shallowest of those with the same name, or the tagged one of those at the
+// same depth.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	var walk func(t reflect.Type, depth int)
+	walk = func(t reflect.Type, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			ft := sf.Type
+			if sf.Anonymous && name == "" {
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft, depth+1)
+					continue
+				}
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			f := jsonField{name: name, typ: ft, depth: depth, tagged: name != ""}
+			if f.name == "" {
+				f.name = sf.Name
+			}
+			f.omitempty = strings.Contains(","+opts+",", ",omitempty,")
+			fields = append(fields, f)
+		}
+	}
+	walk(t, 0)
+
+	byName := make(map[string][]jsonField)
+	var order []string
+	for _, f := range fields {
+		if _, ok := byName[f.name]; !ok {
+			order = append(order, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	var keep []jsonField
+	for _, name := range order {
+		if f, ok := dominantField(byName[name]); ok {
+			keep = append(keep, f)
+		}
+	}
+	return keep
+}
+
+// dominantField returns the field which encoding/json encodes of those with
+// the same name, if any.
+func dominantField(fields []jsonField) (jsonField, bool) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].depth != fields[j].depth {
+			return fields[i].depth < fields[j].depth
+		}
+		return fields[i].tagged && !fields[j].tagged
+	})
+	if len(fields) > 1 && fields[0].depth == fields[1].depth && fields[0].tagged == fields[1].tagged {
+		return jsonField{}, false
+	}
+	return fields[0], true
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addSizesCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "sizes [-f compile.json] [-n limit]",
+		Short:   "Compare build times with the sizes of the files built",
+		Long: `List the packages whose compiled archives or linked binaries are largest,
+with the time taken to build them, to find the packages producing enormous
+object files, such as from generated code or large tables of data.
+
+The files are found at the actions' Target or, for compiles, in their Objdir,
+and so this must be run on the machine which performed the build, before the
+go command removes its work directory: build with -work to keep it. Cached
+actions' outputs stay in the build cache, and are skipped.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, sizeFormats)
+			if err != nil {
+				return err
+			}
+			return sizes(opt, limit, out)
+		},
+	}
+	cmd.Flags().IntP("limit", "n", 20, "number of packages to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var sizeFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Size", tpl: `{{ .MB | printf "%.2f MB" }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Per MB", tpl: `{{ .PerMB | seconds }}/MB`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Size", tpl: `{{ .MB | printf "%.2f MB" }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "ID", tpl: `{{ .ID }}`},
+		{name: "Size", tpl: `{{ .MB | printf "%.2f MB" }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Per MB", tpl: `{{ .PerMB | seconds }}/MB`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+		{name: "Path", tpl: `{{ .Path }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "id", tpl: `{{ .ID }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "bytes", tpl: `{{ .Bytes }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "per_mb", tpl: `{{ .PerMB.Seconds | printf "%.3f" }}`},
+		{name: "path", tpl: `{{ .Path }}`},
+	},
+}
+
+// artifact is the file built by an action.
+type artifact struct {
+	ID       int
+	Mode     string
+	Package  string
+	Path     string
+	Bytes    int64
+	MB       float64 // Bytes in megabytes.
+	Duration time.Duration
+	PerMB    time.Duration // Duration per megabyte built.
+}
+
+// artifactPath returns the path of the file built by act, if it builds one.
+// Compiles write their archive into their Objdir, and don't record a Target
+// unless it's installed.
+func artifactPath(act *action) string {
+	switch {
+	case act.Target != "":
+		return act.Target
+	case act.Mode == "build" && act.Objdir != "":
+		return filepath.Join(act.Objdir, "_pkg_.a")
+	}
+	return ""
+}
+
+// sizes lists the largest files built by the actions, followed by how their
+// sizes correlate with the actions' durations when written as text.
+func sizes(opt *options, limit int, out *rowWriter) error {
+	var rows []*artifact
+	var missing int
+	for _, act := range opt.actions() {
+		path := artifactPath(act)
+		if path == "" || act.Cached {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			missing++
+			continue
+		}
+		a := &artifact{
+			ID:       act.ID,
+			Mode:     act.Mode,
+			Package:  act.Package,
+			Path:     path,
+			Bytes:    fi.Size(),
+			MB:       float64(fi.Size()) / 1e6,
+			Duration: act.Duration,
+		}
+		if a.MB > 0 {
+			a.PerMB = time.Duration(float64(a.Duration) / a.MB)
+		}
+		rows = append(rows, a)
+	}
+	if len(rows) == 0 && missing > 0 {
+		return fmt.Errorf("none of the %d files built were found: was the build run here, with -work?", missing)
+	}
+
+	r := sizeCorrelation(rows)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Bytes > rows[j].Bytes
+	})
+	var total int64
+	for _, a := range rows {
+		total += a.Bytes
+	}
+	built := len(rows)
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	for _, a := range rows {
+		if err := out.row(a); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	fmt.Fprintf(opt.stdout, "%.2f MB built by %d actions", float64(total)/1e6, built)
+	if !math.IsNaN(r) {
+		fmt.Fprintf(opt.stdout, ", correlating %.3f with their durations", r)
+	}
+	fmt.Fprintln(opt.stdout)
+	if missing > 0 {
+		fmt.Fprintf(opt.stdout, "%d files weren't found\n", missing)
+	}
+	return nil
+}
+
+// sizeCorrelation returns the Pearson correlation of the artifacts' sizes with
+// the durations of the actions building them, or NaN if it's undefined.
+func sizeCorrelation(rows []*artifact) float64 {
+	n := float64(len(rows))
+	var sx, sy, sxx, syy, sxy float64
+	for _, a := range rows {
+		x, y := float64(a.Bytes), a.Duration.Seconds()
+		sx += x
+		sy += y
+		sxx += x * x
+		syy += y * y
+		sxy += x * y
+	}
+	cov := sxy - sx*sy/n
+	vx, vy := sxx-sx*sx/n, syy-sy*sy/n
+	if n < 2 || vx <= 0 || vy <= 0 {
+		return math.NaN()
+	}
+	return cov / math.Sqrt(vx*vy)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addSpeedupCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "speedup [-f compile.json]",
+		Short:   "Compare the speedup achieved by running actions in parallel with the most possible",
+		Long: `Compare the serial time of the build, the total of its actions as if they ran
+one after another, with its wall time and the duration of its critical path,
+the chain of dependent actions taking longest.
+
+The achieved speedup is the serial time over the wall time, and the maximum
+speedup the serial time over the critical path, which no number of CPUs could
+run any faster. Their ratio is the efficiency of the build: close to 100%,
+bigger machines can't make it much faster, and only breaking up the critical
+path will, as chains lists.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+			out, err := newRowWriter(cmd, opt, speedupFormats)
+			if err != nil {
+				return err
+			}
+			return speedup(opt, out)
+		},
+	}
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var speedupFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Serial", tpl: `{{ .Serial | seconds }}`},
+		{name: "Wall", tpl: `{{ .Wall | seconds }}`},
+		{name: "Critical", tpl: `{{ .CriticalPath | seconds }}`},
+		{name: "Speedup", tpl: `{{ .Speedup | printf "%.2fx" }}`},
+		{name: "Max", tpl: `{{ .MaxSpeedup | printf "%.2fx" }}`},
+		{name: "Efficiency", tpl: `{{ .Efficiency | percent }}`},
+	},
+	short: []formatColumn{
+		{name: "Speedup", tpl: `{{ .Speedup | printf "%.2fx" }}`},
+		{name: "Max", tpl: `{{ .MaxSpeedup | printf "%.2fx" }}`},
+	},
+	wide: []formatColumn{
+		{name: "Serial", tpl: `{{ .Serial | seconds }}`},
+		{name: "Wall", tpl: `{{ .Wall | seconds }}`},
+		{name: "Critical", tpl: `{{ .CriticalPath | seconds }}`},
+		{name: "Speedup", tpl: `{{ .Speedup | printf "%.2fx" }}`},
+		{name: "Max", tpl: `{{ .MaxSpeedup | printf "%.2fx" }}`},
+		{name: "Efficiency", tpl: `{{ .Efficiency | percent }}`},
+		{name: "Actions", tpl: `{{ .Count }}`},
+		{name: "Depth", tpl: `{{ .Depth }}`},
+	},
+	columns: []formatColumn{
+		{name: "serial", tpl: `{{ .Serial.Seconds | printf "%.3f" }}`},
+		{name: "wall", tpl: `{{ .Wall.Seconds | printf "%.3f" }}`},
+		{name: "critical_path", tpl: `{{ .CriticalPath.Seconds | printf "%.3f" }}`},
+		{name: "speedup", tpl: `{{ .Speedup | printf "%.4f" }}`},
+		{name: "max_speedup", tpl: `{{ .MaxSpeedup | printf "%.4f" }}`},
+		{name: "efficiency", tpl: `{{ .Efficiency | printf "%.2f" }}`},
+		{name: "count", tpl: `{{ .Count }}`},
+		{name: "depth", tpl: `{{ .Depth }}`},
+	},
+}
+
+// speedupReport compares the time of the build run in parallel with that of
+// its actions run one after another.
+type speedupReport struct {
+	Serial       time.Duration // Total of the actions.
+	Wall         time.Duration
+	CriticalPath time.Duration
+	Speedup      float64 // Serial over Wall.
+	MaxSpeedup   float64 // Serial over CriticalPath.
+	Efficiency   float64 // Speedup as a percentage of MaxSpeedup.
+	Count        int     // Number of actions.
+	Depth        int     // Number of actions on the longest chain of dependencies.
+}
+
+// speedup writes the speedup of the build, followed by how much faster more
+// CPUs could make it when written as text.
+func speedup(opt *options, out *rowWriter) error {
+	acts := opt.actions()
+	shape := graphShape(acts)
+	r := &speedupReport{
+		Wall:         opt.store.wall(),
+		CriticalPath: shape.CriticalPath,
+		Count:        len(acts),
+		Depth:        shape.Depth,
+	}
+	for _, act := range acts {
+		r.Serial += act.Duration
+	}
+	if r.Wall > 0 {
+		r.Speedup = float64(r.Serial) / float64(r.Wall)
+	}
+	if r.CriticalPath > 0 {
+		r.MaxSpeedup = float64(r.Serial) / float64(r.CriticalPath)
+	}
+	if r.MaxSpeedup > 0 {
+		r.Efficiency = 100 * r.Speedup / r.MaxSpeedup
+	}
+	if err := out.row(r); err != nil {
+		return err
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	if r.Wall <= r.CriticalPath {
+		fmt.Fprintf(opt.stdout, "the build took no longer than its critical path, %.3fs: no number of CPUs could make it faster\n", r.CriticalPath.Seconds())
+		return nil
+	}
+	fmt.Fprintf(opt.stdout, "however many CPUs, the build could be at most %.2fx faster, taking %.3fs rather than %.3fs\n",
+		float64(r.Wall)/float64(r.CriticalPath), r.CriticalPath.Seconds(), r.Wall.Seconds())
+	return nil
+}
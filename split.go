@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addSplitCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "split [-f compile.json] [-n limit] [--parts 2] [--parallelism N]",
+		Short:   "Suggest the slow packages on the critical path worth splitting",
+		Long: `List the packages whose compiles are both slow and on the critical path, the
+longest chain of dependencies through the build, with the wall time which
+splitting each of them into --parts packages would save.
+
+A package's Slack is how much shorter the longest chain through its compile
+is than the critical path: with none, the rest of the build waits on it, and
+a shorter compile would finish the build sooner. Compiles taking at least
+--min-duration with at most --slack are simulated as taking a --parts share
+of their time, as though the parts compiled alongside each other and the
+package's dependents needed all of them. The rest of the actions are
+scheduled on --parallelism workers in the order the go command started them,
+as by warm, and the packages are listed by the wall time saved.
+
+With --enrich-golist, the number of Go files in each package is shown, and
+packages of a single file, which can't be split by moving files, are left
+out.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			var sopt splitOptions
+			if sopt.limit, err = flags.GetInt("limit"); err != nil {
+				return err
+			}
+			if sopt.parts, err = flags.GetInt("parts"); err != nil {
+				return err
+			}
+			if sopt.parts < 2 {
+				return errors.New("--parts must be at least 2")
+			}
+			if sopt.parallelism, err = flags.GetInt("parallelism"); err != nil {
+				return err
+			}
+			if sopt.parallelism < 0 {
+				return errors.New("--parallelism must not be negative")
+			}
+			if sopt.minDuration, err = flags.GetDuration("min-duration"); err != nil {
+				return err
+			}
+			if sopt.slack, err = flags.GetDuration("slack"); err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, splitFormats)
+			if err != nil {
+				return err
+			}
+			return split(opt, sopt, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of the slowest packages to simulate and show")
+	flags.Int("parts", 2, "number of packages to simulate splitting each into")
+	flags.Int("parallelism", 0, "number of actions the build could run at once (default the most seen running)")
+	flags.Duration("min-duration", time.Second, "ignore compiles quicker than this")
+	flags.Duration("slack", time.Second, "ignore compiles whose longest chain is this much shorter than the critical path")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var splitFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Saving", tpl: `{{ .Saving | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Duration", tpl: `{{ .Duration | seconds }}`},
+		{name: "Slack", tpl: `{{ .Slack | seconds }}`},
+		{name: "Files", tpl: `{{ if .Listed }}{{ .GoFiles }}{{ else }}-{{ end }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Saving", tpl: `{{ .Saving | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Saving", tpl: `{{ .Saving | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Duration", tpl: `{{ .Duration | seconds }}`},
+		{name: "Slack", tpl: `{{ .Slack | seconds }}`},
+		{name: "Files", tpl: `{{ if .Listed }}{{ .GoFiles }}{{ else }}-{{ end }}`},
+		{name: "Per file", tpl: `{{ if .Listed }}{{ .PerFile | seconds }}{{ else }}-{{ end }}`},
+		{name: "Dependents", tpl: `{{ .Dependents }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "saving", tpl: `{{ .Saving.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "slack", tpl: `{{ .Slack.Seconds | printf "%.3f" }}`},
+		{name: "go_files", tpl: `{{ if .Listed }}{{ .GoFiles }}{{ end }}`},
+		{name: "dependents", tpl: `{{ .Dependents }}`},
+	},
+}
+
+type splitOptions struct {
+	limit       int
+	parts       int
+	parallelism int
+	minDuration time.Duration
+	slack       time.Duration
+}
+
+// splitAction is a compile on or near the critical path, with the wall time
+// splitting its package would save.
+type splitAction struct {
+	action
+	Dependents int           // Number of actions depending on it.
+	Saving     time.Duration // Simulated wall time saved by splitting the package.
+	Percent    float64       // Saving as a percentage of the simulated wall time.
+}
+
+// split lists the compiles on the critical path whose splitting would save
+// the most wall time, followed by the saving of splitting all of them when
+// written as text.
+func split(opt *options, sopt splitOptions, out *rowWriter) error {
+	if sopt.parallelism == 0 {
+		var err error
+		if sopt.parallelism, err = peakParallelism(opt); err != nil {
+			return err
+		}
+	}
+
+	s := opt.store
+	critical := graphShape(s.view()).CriticalPath
+
+	var candidates []*splitAction
+	var singleFile int
+	for _, act := range opt.actions() {
+		if act.Mode != "build" || act.Cached || act.Duration < sopt.minDuration {
+			continue
+		}
+		if act.Slack > sopt.slack {
+			continue
+		}
+		if act.Listed && act.GoFiles < 2 {
+			singleFile++
+			continue
+		}
+		candidates = append(candidates, &splitAction{
+			action:     *act,
+			Dependents: len(s.dependents.of(act.ID)),
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Duration != candidates[j].Duration {
+			return candidates[i].Duration > candidates[j].Duration
+		}
+		return candidates[i].Package < candidates[j].Package
+	})
+	if sopt.limit > 0 && len(candidates) > sopt.limit {
+		candidates = candidates[:sopt.limit]
+	}
+	opt.logf(1, "simulating %d compiles on the critical path of %.3fs", len(candidates), critical.Seconds())
+	if singleFile > 0 {
+		opt.logf(2, "leaving out %d packages of a single Go file", singleFile)
+	}
+
+	wall := simulateBuild(s, sopt.parallelism, nil)
+	all := make(map[int]bool, len(candidates))
+	for _, c := range candidates {
+		all[c.ID] = true
+		splitOne := splitDurations(s, map[int]bool{c.ID: true}, sopt.parts)
+		if saving := wall - simulateBuild(s, sopt.parallelism, splitOne); saving > 0 {
+			c.Saving = saving
+		}
+		c.Percent = percentOf(c.Saving, wall)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Saving > candidates[j].Saving
+	})
+	for _, c := range candidates {
+		if err := out.row(c); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		fmt.Fprintf(opt.stdout, "no compiles of at least %s are within %s of the critical path of %.3fs\n", sopt.minDuration, sopt.slack, critical.Seconds())
+		return nil
+	}
+	saving := wall - simulateBuild(s, sopt.parallelism, splitDurations(s, all, sopt.parts))
+	fmt.Fprintf(opt.stdout, "splitting all %d packages in %d would save %.3fs of the %.3fs simulated at a parallelism of %d (%.2f%%); the critical path is %.3fs\n",
+		len(candidates), sopt.parts, saving.Seconds(), wall.Seconds(), sopt.parallelism, percentOf(saving, wall), critical.Seconds())
+	return nil
+}
+
+// splitDurations returns the durations of the actions were the split ones to
+// take a parts share of their time, for simulateBuild.
+func splitDurations(s *store, split map[int]bool, parts int) func(id int) time.Duration {
+	return func(id int) time.Duration {
+		if split[id] {
+			return s.actions[id].Duration / time.Duration(parts)
+		}
+		return s.actions[id].Duration
+	}
+}
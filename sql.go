@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func addSQLCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "sql [-f compile.json] QUERY",
+		Short:   "Query the actions with SQL",
+		Long: `Run a SQL query over the actions, loaded into an in-memory SQLite database
+with the same tables as written by "actiongraph export", and print its
+results as a table. For example:
+
+    actiongraph sql -f compile.json 'SELECT package, sum(duration) FROM actions GROUP BY 1 ORDER BY 2 DESC LIMIT 10'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Every connection to :memory: opens a separate database, so
+			// the tables are only visible on the one connection.
+			db, err := sql.Open("sqlite", ":memory:")
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			if _, err := exportSQLite(cmd.Context(), db, opt, "actiongraph", gitInfo{}); err != nil {
+				return fmt.Errorf("loading actions: %w", err)
+			}
+			return querySQL(cmd.Context(), opt.stdout, db, args[0])
+		},
+	}
+	prog.AddCommand(&cmd)
+}
+
+// querySQL runs query on db, writing its results to w as aligned columns
+// beneath a header.
+func querySQL(ctx context.Context, w io.Writer, db *sql.DB, query string) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(cols, "\t"))
+
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	cells := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			switch v := v.(type) {
+			case nil:
+				cells[i] = "NULL"
+			case []byte:
+				cells[i] = string(v)
+			case float64:
+				cells[i] = fmt.Sprintf("%.9g", v)
+			default:
+				cells[i] = fmt.Sprint(v)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// durationStats summarises a set of durations.
+type durationStats struct {
+	Count  int
+	Total  time.Duration
+	Mean   time.Duration
+	Stddev time.Duration // Sample standard deviation, or zero for fewer than two durations.
+	Min    time.Duration
+	Median time.Duration
+	P95    time.Duration
+	Max    time.Duration
+}
+
+func summarise(ds []time.Duration) durationStats {
+	s := durationStats{Count: len(ds)}
+	if len(ds) == 0 {
+		return s
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, d := range sorted {
+		s.Total += d
+	}
+	s.Mean = s.Total / time.Duration(len(sorted))
+	if len(sorted) > 1 {
+		var sq float64
+		for _, d := range sorted {
+			sq += math.Pow(float64(d-s.Mean), 2)
+		}
+		s.Stddev = time.Duration(math.Sqrt(sq / float64(len(sorted)-1)))
+	}
+	s.Min = sorted[0]
+	s.Median = percentile(sorted, 50)
+	s.P95 = percentile(sorted, 95)
+	s.Max = sorted[len(sorted)-1]
+	return s
+}
+
+// percentile returns the p-th percentile of the ascending durations, using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
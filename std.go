@@ -0,0 +1,47 @@
+package main
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:generate sh -c "go list std > std.txt"
+
+// stdList is the output of `go list std`, regenerated with each Go release.
+//
+//go:embed std.txt
+var stdList string
+
+var (
+	// stdPackages are the packages of the standard library.
+	stdPackages = make(map[string]bool)
+
+	// stdRoots are the top-level directories of the standard library, other
+	// than vendor and internal, which any module-less path might begin with.
+	stdRoots = make(map[string]bool)
+)
+
+func init() {
+	for _, pkg := range strings.Fields(stdList) {
+		stdPackages[pkg] = true
+		if root, _, _ := strings.Cut(pkg, "/"); root != "vendor" && root != "internal" {
+			stdRoots[root] = true
+		}
+	}
+}
+
+// isStdlib reports whether pkg is part of the standard library. Packages
+// missing from the embedded list, perhaps because they were added in a later
+// release, are recognised by sharing a top-level directory with it, other
+// than vendor or internal.
+//
+// Actions compiled from the standard library are also recognised by the -std
+// flag given to the compiler: see action.Std.
+func isStdlib(pkg string) bool {
+	pkg, _, _ = strings.Cut(pkg, " ") // Strip test variants, "pkg [pkg.test]".
+	if stdPackages[pkg] {
+		return true
+	}
+	root, _, _ := strings.Cut(pkg, "/")
+	return stdRoots[root]
+}
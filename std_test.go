@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestIsStdlib(t *testing.T) {
+	for pkg, want := range map[string]bool{
+		"fmt":                                  true,
+		"net/http [net/http.test]":             true,
+		"internal/cpu":                         true,
+		"vendor/golang.org/x/net/idna":         true,
+		"crypto/newin2099":                     true,
+		"internal/bar":                         false,
+		"vendor/foo":                           false,
+		"github.com/pkg/errors":                false,
+		"example.com/internal/fmt":             false,
+		"fmtx":                                 false,
+		"github.com/x/y [github.com/x/y.test]": false,
+	} {
+		if got := isStdlib(pkg); got != want {
+			t.Errorf("isStdlib(%q) = %v, want %v", pkg, got, want)
+		}
+	}
+}
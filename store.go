@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/icio/actiongraph/actiongraph"
+)
+
+// store is the immutable set of actions read from an actiongraph file. The
+// actions are indexed by their ID so that Deps can be followed directly.
+// Commands must not reorder or modify the actions in the store: those wanting
+// a different ordering should take a view instead.
+//
+// The store is the only copy of the actions kept once they're loaded, so that
+// graphs of many actions fit in memory: most of the size of each action is its
+// commands, whose arguments are slices of the command lines read, and the
+// strings repeated between actions, such as their packages and modes, are
+// interned. The actions of a typical Go build take around 2.5KB each once
+// loaded, about twice the size of the -debug-actiongraph JSON, so a million
+// of them fit in 3GB, though decoding the JSON takes twice that at its peak.
+// BenchmarkNewStore reports the size, and TestStoreMemory checks it.
+type store struct {
+	actions []action
+	total   time.Duration
+	begin   time.Time
+	end     time.Time
+
+	// dependents are the IDs of the actions depending on each action.
+	dependents adjacency
+
+	// renamed are the renamings of the packages by --aliases, --trim-prefix
+	// and --rewrite, in the order they were applied.
+	renamed []func(string) string
+}
+
+// loadStore reads the actions of a build in any of the formats recognised by
+// actiongraph.Load.
+func loadStore(r io.Reader) (*store, error) {
+	g, err := actiongraph.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	return newStore(g), nil
+}
+
+// newStore wraps the actions of g with the fields derived from their commands.
+// The actions of g are moved into the store, and g must not be used after.
+func newStore(g *actiongraph.Graph) *store {
+	s := store{actions: make([]action, len(g.Actions)), total: g.Total, begin: g.Start()}
+	intern := make(map[string]string)
+	for i := range g.Actions {
+		act := &s.actions[i]
+		act.Action = g.Actions[i]
+		act.Deps = knownDeps(act.Deps, len(g.Actions))
+		act.Mode = internString(intern, act.Mode)
+		act.Package = internString(intern, act.Package)
+		act.parseCmd()
+		for j, f := range act.Flags {
+			act.Flags[j] = internString(intern, f)
+		}
+		act.Cgo = usesCgo(act)
+		act.Std = isStdlib(act.Package) || act.HasFlag("-std")
+		act.instrumentation = cmdInstrumentation(act)
+		if act.TimeDone.After(s.end) {
+			s.end = act.TimeDone
+		}
+	}
+	g.Actions = nil
+	s.index()
+	return &s
+}
+
+// index builds the indexes of the actions, which must be rebuilt whenever
+// they're renumbered.
+func (s *store) index() {
+	s.dependents = newAdjacency(len(s.actions), func(visit func(from, to int)) {
+		for i := range s.actions {
+			for _, dep := range s.actions[i].Deps {
+				visit(dep, i)
+			}
+		}
+	})
+}
+
+// knownDeps removes the IDs of deps which aren't among the n actions, as
+// actiongraph.ComputeDerived ignores them, so that they can be indexed by.
+func knownDeps(deps []int, n int) []int {
+	known := deps[:0]
+	for _, dep := range deps {
+		if dep >= 0 && dep < n {
+			known = append(known, dep)
+		}
+	}
+	return known
+}
+
+// internString returns the copy of str kept by intern, keeping str if there
+// isn't one yet.
+func internString(intern map[string]string, str string) string {
+	if s, ok := intern[str]; ok {
+		return s
+	}
+	intern[str] = str
+	return str
+}
+
+// adjacency lists the edges from each of n nodes, numbered from zero, in a
+// single slice rather than a slice for each node.
+type adjacency struct {
+	offsets []int32 // The edges from node i are to[offsets[i]:offsets[i+1]].
+	to      []int
+}
+
+// newAdjacency returns the adjacency of the n nodes with the edges given to
+// visit by edges, which must give them the same each time it's called.
+func newAdjacency(n int, edges func(visit func(from, to int))) adjacency {
+	a := adjacency{offsets: make([]int32, n+1)}
+	edges(func(from, _ int) { a.offsets[from+1]++ })
+	for i := 1; i <= n; i++ {
+		a.offsets[i] += a.offsets[i-1]
+	}
+	a.to = make([]int, a.offsets[n])
+	next := append([]int32(nil), a.offsets[:n]...)
+	edges(func(from, to int) {
+		a.to[next[from]] = to
+		next[from]++
+	})
+	return a
+}
+
+// of returns the edges from node n. The caller must not modify them.
+func (a adjacency) of(n int) []int {
+	return a.to[a.offsets[n]:a.offsets[n+1]:a.offsets[n+1]]
+}
+
+// percent returns d as a percentage of the total duration of the actions, or
+// zero if they took no time, as when the build tool recorded no timings.
+func (s *store) percent(d time.Duration) float64 {
+	return percentOf(d, s.total)
+}
+
+// view returns pointers to each of the actions in ID order. The caller is free
+// to reorder the view, but must not modify the actions themselves.
+func (s *store) view() []*action {
+	v := make([]*action, len(s.actions))
+	for i := range s.actions {
+		v[i] = &s.actions[i]
+	}
+	return v
+}
+
+// sorted returns a view of the actions, ordered by less. Actions which compare
+// equal are kept in ID order.
+func (s *store) sorted(less func(a, b *action) bool) []*action {
+	v := s.view()
+	sort.SliceStable(v, func(i, j int) bool { return less(v[i], v[j]) })
+	return v
+}
+
+// byDuration orders the slowest actions first.
+func byDuration(a, b *action) bool {
+	return a.Duration > b.Duration
+}
+
+// instrumentations returns the distinct instrumentation of the executed compile
+// and link actions in the store, as their sanitizer flags, sorted so that
+// those of different builds can be compared.
+func (s *store) instrumentations() []string {
+	var found []string
+	seen := make(map[instrumentation]bool)
+	for i := range s.actions {
+		act := &s.actions[i]
+		if act.Cached || (act.Mode != "build" && act.Mode != "link") {
+			continue
+		}
+		if !seen[act.instrumentation] {
+			seen[act.instrumentation] = true
+			found = append(found, act.instrumentation.flags())
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// parseCmd sets the fields derived from the action's Cmd.
+func (a *action) parseCmd() {
+	a.Commands = parseCmds(a.Cmd)
+	if m := mainCommand(a.Commands); m >= 0 {
+		a.Tool = a.Commands[m].Tool
+		a.Flags = a.Commands[m].Flags
+		a.FlagCount = len(a.Flags)
+	}
+	for _, c := range a.Commands {
+		a.SourceFiles += len(c.Files)
+	}
+}
+
+// wall returns the wall-clock time of the build, from the first action
+// starting to the last finishing.
+func (s *store) wall() time.Duration {
+	return s.end.Sub(s.begin)
+}
+
+// start returns the time at which the first action started.
+func (s *store) start() time.Time {
+	return s.begin
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/icio/actiongraph/actiongraph"
+)
+
+// storeTargetBytes is the memory kept per action which the store's doc
+// comment puts at around 2.5KB, with some room for the runtime's noise.
+const storeTargetBytes = 3 << 10
+
+// generateGraph returns the JSON of a build of copies of demo/k9s.json, one
+// after another, as though by test --merge.
+func generateGraph(tb testing.TB, copies int) []byte {
+	tb.Helper()
+	files := make([]string, copies)
+	for i := range files {
+		files[i] = filepath.Join("demo", "k9s.json")
+	}
+	var buf bytes.Buffer
+	if err := mergeActionFiles(&buf, files); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkLoad(b *testing.B) {
+	data := generateGraph(b, 10)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := actiongraph.Load(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkNewStore measures the building of the store from the loaded
+// actions, reporting the memory kept per action once loaded, which is checked
+// against storeTargetBytes by TestStoreMemory.
+func BenchmarkNewStore(b *testing.B) {
+	data := generateGraph(b, 10)
+	b.ReportAllocs()
+	var kept int64
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		before := heapAlloc()
+		g, err := actiongraph.Load(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		s := newStore(g)
+
+		b.StopTimer()
+		kept = (heapAlloc() - before) / int64(len(s.actions))
+		runtime.KeepAlive(s)
+		b.StartTimer()
+	}
+	runtime.KeepAlive(data)
+	b.ReportMetric(float64(kept), "B/action")
+}
+
+// heapAlloc returns the bytes of the heap in use after garbage collection,
+// run twice to also free what was reachable only by finalizers and pools.
+func heapAlloc() int64 {
+	runtime.GC()
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapAlloc)
+}
+
+// TestStoreMemory checks the memory kept per action by the store against the
+// target of its doc comment.
+func TestStoreMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("loads a large graph")
+	}
+	data := generateGraph(t, 10)
+	before := heapAlloc()
+	s, err := loadStore(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := (heapAlloc() - before) / int64(len(s.actions))
+	runtime.KeepAlive(s)
+	runtime.KeepAlive(data)
+
+	t.Logf("%d actions keep %d bytes each, from %d bytes of JSON each", len(s.actions), kept, len(data)/len(s.actions))
+	if kept > storeTargetBytes {
+		t.Errorf("the store keeps %d bytes per action, over its target of %d", kept, storeTargetBytes)
+	}
+}
+
+// TestNewStoreUnknownDeps checks that the Deps missing from the actions are
+// dropped, as they're ignored by actiongraph.ComputeDerived.
+func TestNewStoreUnknownDeps(t *testing.T) {
+	g, err := actiongraph.New([]actiongraph.Action{
+		{ID: 0, Mode: "build", Package: "y", Deps: []int{5, -1}},
+		{ID: 1, Mode: "build", Package: "x", Deps: []int{0, 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newStore(g)
+	if deps := s.actions[0].Deps; len(deps) != 0 {
+		t.Errorf("y depends on %v, want nothing", deps)
+	}
+	if deps, want := s.actions[1].Deps, []int{0}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("x depends on %v, want %v", deps, want)
+	}
+	if deps, want := s.dependents.of(0), []int{1}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("y is depended on by %v, want %v", deps, want)
+	}
+}
+
+func TestTopUnknownDeps(t *testing.T) {
+	out := runOutput(t, filepath.Join("testdata", "dangling.json"), ".csv", "top")
+	if !bytes.Contains(out, []byte(",x,")) {
+		t.Errorf("top didn't list x:\n%s", out)
+	}
+}
+
+func TestMain(m *testing.M) {
+	// The fixtures are relative to the module root.
+	if _, err := os.Stat(filepath.Join("demo", "k9s.json")); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
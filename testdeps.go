@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func addTestDepsCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "testdeps [-f test.json] [-n limit]",
+		Short:   "List the dependencies built only for tests, and what they cost",
+		Long: `List the modules whose packages were built only because tests imported them,
+such as assertion or mocking frameworks, by the time spent building them:
+
+    go test -debug-actiongraph=test.json ./...
+    actiongraph testdeps -f test.json --enrich-golist
+
+A package is needed by the code itself if it's imported, directly or not, by
+a package under test or by a binary built alongside the tests. The rest of
+the packages built for the test binaries were needed only by the tests: the
+imports of external test packages (x_test), of the generated test mains
+(x.test), and of a package's own test files, along with the packages which
+had to be recompiled for the tests. Their Binaries are the number of test
+binaries which needed them.
+
+The go command gives a package compiled with its test files the same name as
+the package, so the imports of its own files can only be told from those of
+its tests by --enrich-golist. Without it, they're counted as the package's
+own, and the time is a lower bound.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+			main, err := opt.mainModules(cmd.Context())
+			if err != nil {
+				// The packages of the main module are then each taken as a
+				// module of their own.
+				opt.logf(1, "finding the main module: %v", err)
+			}
+
+			out, err := newRowWriter(cmd, opt, testDepFormats)
+			if err != nil {
+				return err
+			}
+			return testDeps(opt, main, limit, out)
+		},
+	}
+	cmd.Flags().IntP("limit", "n", 20, "number of modules to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var testDepFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Packages", tpl: `{{ len .Packages }}`},
+		{name: "Binaries", tpl: `{{ .Binaries }}`},
+		{name: "Module", tpl: `{{ .Module }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Module", tpl: `{{ .Module }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Actions", tpl: `{{ .Actions }}`},
+		{name: "Packages", tpl: `{{ len .Packages }}`},
+		{name: "Binaries", tpl: `{{ .Binaries }}`},
+		{name: "Module", tpl: `{{ .Module }}`, left: true},
+		{name: "Packages", tpl: `{{ .Packages | join " " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "module", tpl: `{{ .Module }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "actions", tpl: `{{ .Actions }}`},
+		{name: "binaries", tpl: `{{ .Binaries }}`},
+		{name: "packages", tpl: `{{ .Packages | join " " }}`},
+	},
+}
+
+// testDep is a module whose packages were built only for tests.
+type testDep struct {
+	Module   string
+	Duration time.Duration // Of the compiles needed only by tests.
+	Percent  float64       // Duration as a percentage of the total of all actions.
+	Actions  int           // Number of the compiles needed only by tests.
+	Binaries int           // Number of test binaries needing any of them.
+	Packages []string      // Sorted.
+	IDs      []int
+}
+
+// testDeps lists the modules of the packages built only for tests, by the
+// time spent building them, followed by their total when written as text.
+func testDeps(opt *options, main []string, limit int, out *rowWriter) error {
+	s := opt.store
+	g := newImportGraph(s)
+	// The test packages themselves are the tests' own code, rather than
+	// their dependencies.
+	isTest := func(pkg string) bool {
+		pkg, _, _ = strings.Cut(pkg, " ")
+		return strings.HasSuffix(pkg, "_test") || strings.HasSuffix(pkg, ".test")
+	}
+
+	// The packages targeted by go test, and the compiles of those with their
+	// test files, imported by the test mains.
+	targets := make(map[string]bool)
+	underTest := make(map[int]bool)
+	for i := range s.actions {
+		act := &s.actions[i]
+		switch {
+		case act.Mode == "test run":
+			targets[act.Package] = true
+		case act.Mode == "build" && strings.HasSuffix(act.Package, ".test"):
+			pkg := strings.TrimSuffix(act.Package, ".test")
+			for _, dep := range g.imports[act.ID] {
+				if p := s.actions[dep].Package; testPackage(p) == pkg && !isTest(p) {
+					underTest[dep] = true
+				}
+			}
+		}
+	}
+
+	// Mark the compiles needed by the code itself: those of the packages
+	// under test, and of those built and linked other than for tests, and
+	// everything they import. The compile of a package with its test files
+	// is followed only by the imports of its own files, when known.
+	needed := make([]bool, len(s.actions))
+	var mark func(id int)
+	mark = func(id int) {
+		if needed[id] {
+			return
+		}
+		needed[id] = true
+		for _, dep := range g.imports[id] {
+			mark(dep)
+		}
+	}
+	for i := range s.actions {
+		act := &s.actions[i]
+		if (act.Mode != "build" && act.Mode != "link") || isTest(act.Package) {
+			continue
+		}
+		if !targets[act.Package] && !builtAlone(s, i) {
+			continue
+		}
+		if !underTest[i] || !act.Listed {
+			mark(i)
+			continue
+		}
+		needed[i] = true
+		imports := make(map[string]bool, len(act.Imports))
+		for _, pkg := range act.Imports {
+			imports[pkg] = true
+		}
+		for _, dep := range g.imports[i] {
+			if imports[s.actions[dep].Package] {
+				mark(dep)
+			}
+		}
+	}
+
+	// Charge the rest of the compiles reached by the test binaries to the
+	// tests, once each however many binaries needed them.
+	mods := loadModules(s.actions, main)
+	byModule := make(map[string]*testDep)
+	binaries := make(map[string]map[int]bool)
+	counted := make(map[int]bool)
+	for i := range s.actions {
+		link := &s.actions[i]
+		if link.Mode != "link" || !strings.HasSuffix(link.Package, ".test") {
+			continue
+		}
+		for _, id := range dependencies(s.actions, i) {
+			act := &s.actions[id]
+			if act.Mode != "build" || needed[id] || underTest[id] || isTest(act.Package) || !opt.include(act) {
+				continue
+			}
+			name := mods.module(testPackage(act.importPath()))
+			d := byModule[name]
+			if d == nil {
+				d = &testDep{Module: name}
+				byModule[name] = d
+				binaries[name] = make(map[int]bool)
+			}
+			binaries[name][i] = true
+			if counted[id] {
+				continue
+			}
+			counted[id] = true
+			d.Duration += act.Duration
+			d.Actions++
+			d.Packages = append(d.Packages, act.Package)
+			d.IDs = append(d.IDs, id)
+		}
+	}
+
+	rows := make([]*testDep, 0, len(byModule))
+	var total time.Duration
+	var packages int
+	for name, d := range byModule {
+		d.Binaries = len(binaries[name])
+		d.Percent = s.percent(d.Duration)
+		sort.Ints(d.IDs)
+		sort.Strings(d.Packages)
+		d.Packages = slices.Compact(d.Packages)
+		total += d.Duration
+		packages += len(d.Packages)
+		rows = append(rows, d)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Duration != rows[j].Duration {
+			return rows[i].Duration > rows[j].Duration
+		}
+		return rows[i].Module < rows[j].Module
+	})
+	for i, d := range rows {
+		if limit > 0 && i >= limit {
+			break
+		}
+		if err := out.row(d); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+	fmt.Fprintf(opt.stdout, "%.3fs (%.2f%%) spent building %d packages of %d modules needed only by tests\n",
+		total.Seconds(), s.percent(total), packages, len(rows))
+	return nil
+}
+
+// builtAlone reports whether the action is a compile or link which no other
+// compile or link needed, such as that of a binary built alongside the tests.
+func builtAlone(s *store, id int) bool {
+	for _, dep := range s.dependents.of(id) {
+		if m := s.actions[dep].Mode; m == "build" || m == "link" {
+			return false
+		}
+	}
+	return true
+}
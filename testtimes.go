@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addTestTimesCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "testtimes [-f tests.json] --test2json results.json [-n limit]",
+		Short:   "List the time to compile, link and run the tests of each package",
+		Long: `Join the time taken to run each package's tests, as written by go test -json,
+onto the time taken to compile and link its test binary, as written by
+-debug-actiongraph for the same go test command:
+
+    go test -debug-actiongraph=tests.json -json ./... > results.json
+    actiongraph testtimes -f tests.json --test2json results.json
+
+Each package is charged for compiling itself and its test packages, though the
+tests of other packages may have needed them too.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			fn, err := flags.GetString("test2json")
+			if err != nil {
+				return err
+			}
+			if fn == "" {
+				return errors.New("the output of go test -json must be given by --test2json")
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			f, err := openFile(fn)
+			if err != nil {
+				return err
+			}
+			runs, err := readTest2JSON(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", fn, err)
+			}
+
+			out, err := newRowWriter(cmd, opt, testTimeFormats)
+			if err != nil {
+				return err
+			}
+			return testTimes(opt, runs, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.String("test2json", "", "file written by go test -json")
+	cmd.MarkFlagFilename("test2json", "json")
+	flags.IntP("limit", "n", 20, "number of slowest packages to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var testTimeFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Total", tpl: `{{ .Total | colordur }}`},
+		{name: "Compile", tpl: `{{ .Compile | seconds }}`},
+		{name: "Link", tpl: `{{ .Link | seconds }}`},
+		{name: "Run", tpl: `{{ .Run | seconds }}`},
+		{name: "Result", tpl: `{{ .Result }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Total", tpl: `{{ .Total | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Total", tpl: `{{ .Total | colordur }}`},
+		{name: "Compile", tpl: `{{ .Compile | seconds }}`},
+		{name: "Link", tpl: `{{ .Link | seconds }}`},
+		{name: "Run", tpl: `{{ .Run | seconds }}`},
+		{name: "Run%", tpl: `{{ .RunPercent | percent }}`},
+		{name: "Tests", tpl: `{{ .Tests }}`},
+		{name: "Result", tpl: `{{ .Result }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "total", tpl: `{{ .Total.Seconds | printf "%.3f" }}`},
+		{name: "compile", tpl: `{{ .Compile.Seconds | printf "%.3f" }}`},
+		{name: "link", tpl: `{{ .Link.Seconds | printf "%.3f" }}`},
+		{name: "run", tpl: `{{ .Run.Seconds | printf "%.3f" }}`},
+		{name: "tests", tpl: `{{ .Tests }}`},
+		{name: "result", tpl: `{{ .Result }}`},
+	},
+}
+
+// testRun is the result of running the tests of a package, as written by go
+// test -json.
+type testRun struct {
+	Elapsed time.Duration
+	Result  string // pass, fail or skip.
+	Tests   int    // Number of top-level tests run.
+	Cached  bool
+}
+
+// readTest2JSON reads the result of each package's tests from the events
+// written by go test -json. The events of the build, which have no Package,
+// are skipped.
+func readTest2JSON(r io.Reader) (map[string]*testRun, error) {
+	runs := make(map[string]*testRun)
+	run := func(pkg string) *testRun {
+		t, ok := runs[pkg]
+		if !ok {
+			t = &testRun{}
+			runs[pkg] = t
+		}
+		return t
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var ev struct {
+			Action  string
+			Package string
+			Test    string
+			Output  string
+			Elapsed float64
+		}
+		err := dec.Decode(&ev)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding go test -json output: %w", err)
+		}
+		if ev.Package == "" {
+			continue
+		}
+		switch ev.Action {
+		case "run":
+			if !strings.Contains(ev.Test, "/") {
+				run(ev.Package).Tests++
+			}
+		case "output":
+			if ev.Test == "" && strings.Contains(ev.Output, "(cached)") {
+				run(ev.Package).Cached = true
+			}
+		case "pass", "fail", "skip":
+			if ev.Test == "" {
+				t := run(ev.Package)
+				t.Result = ev.Action
+				t.Elapsed = time.Duration(ev.Elapsed * float64(time.Second))
+			}
+		}
+	}
+	return runs, nil
+}
+
+// testTime is the time spent compiling, linking and running the tests of a
+// package.
+type testTime struct {
+	Package    string
+	Compile    time.Duration // Of the package and its test packages.
+	Link       time.Duration // Of the test binary.
+	Run        time.Duration // As reported by go test -json.
+	Total      time.Duration
+	RunPercent float64 // Run as a percentage of Total.
+	Tests      int
+	Result     string // pass, fail or skip, and whether it was cached.
+}
+
+// testTimes lists the packages whose tests took longest to compile, link and
+// run together, followed by the totals of each when written as text.
+func testTimes(opt *options, runs map[string]*testRun, limit int, out *rowWriter) error {
+	rows := make(map[string]*testTime)
+	for pkg, r := range runs {
+		result := r.Result
+		if r.Cached {
+			result += " (cached)"
+		}
+		rows[pkg] = &testTime{Package: pkg, Run: r.Elapsed, Tests: r.Tests, Result: result}
+	}
+
+	for i := range opt.store.actions {
+		act := &opt.store.actions[i]
+		if !opt.include(act) {
+			continue
+		}
+		t, ok := rows[testPackage(act.Package)]
+		if !ok {
+			continue
+		}
+		switch {
+		case act.Mode == "build":
+			t.Compile += act.Duration
+		case act.Mode == "link" && strings.HasSuffix(act.Package, ".test"):
+			t.Link += act.Duration
+		}
+	}
+
+	list := make([]*testTime, 0, len(rows))
+	var compile, link, run time.Duration
+	for _, t := range rows {
+		t.Total = t.Compile + t.Link + t.Run
+		if t.Total > 0 {
+			t.RunPercent = 100 * float64(t.Run) / float64(t.Total)
+		}
+		compile += t.Compile
+		link += t.Link
+		run += t.Run
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total != list[j].Total {
+			return list[i].Total > list[j].Total
+		}
+		return list[i].Package < list[j].Package
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	for _, t := range list {
+		if err := out.row(t); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	fmt.Fprintf(opt.stdout, "total: compile %.3fs, link %.3fs, run %.3fs of %d packages\n",
+		compile.Seconds(), link.Seconds(), run.Seconds(), len(rows))
+	return nil
+}
+
+// testPackage returns the package under test for which the go command built
+// pkg: pkg itself, its external test package pkg_test, its test variant
+// "pkg [pkg.test]", or the generated main package pkg.test.
+func testPackage(pkg string) string {
+	if i := strings.Index(pkg, " ["); i >= 0 {
+		pkg = pkg[:i]
+	}
+	pkg = strings.TrimSuffix(pkg, ".test")
+	return strings.TrimSuffix(pkg, "_test")
+}
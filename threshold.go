@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit statuses distinguishing the thresholds which were exceeded from other
+// errors, which exit with status 1.
+const (
+	exitTotalOver  = 3
+	exitActionOver = 4
+)
+
+// exitError is an error which should cause the program to exit with a
+// particular status.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func addThresholdFlags(prog *cobra.Command) {
+	flags := prog.PersistentFlags()
+	flags.Duration("fail-over", 0, fmt.Sprintf("exit with status %d if the total duration of the actions exceeds this", exitTotalOver))
+	flags.Duration("fail-action-over", 0, fmt.Sprintf("exit with status %d if any action takes longer than this", exitActionOver))
+}
+
+// checkThresholds is run after each command, to fail if the actions exceeded
+// the --fail-over or --fail-action-over thresholds.
+func checkThresholds(cmd *cobra.Command, opt *options) error {
+	flags := cmd.Flags()
+	totalOver, err := flags.GetDuration("fail-over")
+	if err != nil {
+		return err
+	}
+	actionOver, err := flags.GetDuration("fail-action-over")
+	if err != nil {
+		return err
+	}
+	if totalOver <= 0 && actionOver <= 0 {
+		return nil
+	}
+
+	var total time.Duration
+	var slowest *action
+	for _, act := range opt.actions() {
+		total += act.Duration
+		if slowest == nil || act.Duration > slowest.Duration {
+			slowest = act
+		}
+	}
+	if totalOver > 0 && total > totalOver {
+		return &exitError{exitTotalOver, fmt.Errorf("total duration %s exceeds %s", total.Round(time.Millisecond), totalOver)}
+	}
+	if actionOver > 0 && slowest != nil && slowest.Duration > actionOver {
+		return &exitError{exitActionOver, fmt.Errorf("%s %s took %s, exceeding %s", slowest.Mode, slowest.Package, slowest.Duration.Round(time.Millisecond), actionOver)}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addTimelineCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "timeline [-f compile.json] [--bucket 1s]",
+		Short:   "Write the number of actions running over time, for plotting",
+		Long: `Split the build into buckets of time from its first action, and write the mean
+and most actions running in each, with the numbers started and finished and
+the packages active at any time in the bucket. Written as csv, with --format
+csv, it's ready for plotting by tools such as gnuplot or Grafana:
+
+    actiongraph timeline -f compile.json --bucket 500ms --format csv > timeline.csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			bucket, err := cmd.Flags().GetDuration("bucket")
+			if err != nil {
+				return err
+			}
+			if bucket <= 0 {
+				return errors.New("--bucket must be positive")
+			}
+
+			out, err := newRowWriter(cmd, opt, timelineFormats)
+			if err != nil {
+				return err
+			}
+			return timeline(opt, bucket, out)
+		},
+	}
+	cmd.Flags().Duration("bucket", time.Second, "length of each bucket of time")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var timelineFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Start", tpl: `+{{ .Start | seconds }}`},
+		{name: "Running", tpl: `{{ .Running | printf "%.1f" }}`},
+		{name: "Max", tpl: `{{ .Max }}`},
+		{name: "Activity", tpl: `{{ .Percent | spark }}`, left: true},
+		{name: "Packages", tpl: `{{ len .Packages }}`},
+	},
+	short: []formatColumn{
+		{name: "Start", tpl: `+{{ .Start | seconds }}`},
+		{name: "Running", tpl: `{{ .Running | printf "%.1f" }}`},
+	},
+	wide: []formatColumn{
+		{name: "Start", tpl: `+{{ .Start | seconds }}`},
+		{name: "Running", tpl: `{{ .Running | printf "%.1f" }}`},
+		{name: "Max", tpl: `{{ .Max }}`},
+		{name: "Started", tpl: `{{ .Started }}`},
+		{name: "Finished", tpl: `{{ .Finished }}`},
+		{name: "Packages", tpl: `{{ .Packages | join ", " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "start_offset", tpl: `{{ .Start.Seconds | printf "%.3f" }}`},
+		{name: "done_offset", tpl: `{{ .Done.Seconds | printf "%.3f" }}`},
+		{name: "running", tpl: `{{ .Running | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "max", tpl: `{{ .Max }}`},
+		{name: "started", tpl: `{{ .Started }}`},
+		{name: "finished", tpl: `{{ .Finished }}`},
+		{name: "packages", tpl: `{{ .Packages | join " " }}`},
+	},
+}
+
+// timelineBucket is the activity of the build in a period of time.
+type timelineBucket struct {
+	Start    time.Duration // Offset from the start of the build.
+	Done     time.Duration
+	Running  float64  // Mean number of actions running.
+	Max      int      // Most actions running at once.
+	Percent  float64  // Running, as a percentage of the most running at once in the build.
+	Started  int      // Number of actions started.
+	Finished int      // Number of actions finished.
+	Packages []string // Of the actions running at any time in the bucket, sorted.
+}
+
+// timeline writes the activity of the build in each bucket of time, from the
+// start of the build to the end of its last action.
+func timeline(opt *options, bucket time.Duration, out *rowWriter) error {
+	acts, err := timedActions(opt)
+	if err != nil {
+		return err
+	}
+	var end time.Duration
+	for _, act := range acts {
+		if act.DoneOffset > end {
+			end = act.DoneOffset
+		}
+	}
+	buckets := make([]timelineBucket, int(end/bucket)+1)
+	for i := range buckets {
+		b := &buckets[i]
+		b.Start = time.Duration(i) * bucket
+		b.Done = b.Start + bucket
+	}
+	index := func(t time.Duration) int {
+		if i := int(t / bucket); i < len(buckets) {
+			return i
+		}
+		return len(buckets) - 1
+	}
+
+	pkgs := make([]map[string]bool, len(buckets))
+	for _, act := range acts {
+		buckets[index(act.StartOffset)].Started++
+		buckets[index(act.DoneOffset)].Finished++
+		for i := index(act.StartOffset); i <= index(act.DoneOffset); i++ {
+			b := &buckets[i]
+			from, to := act.StartOffset, act.DoneOffset
+			if from < b.Start {
+				from = b.Start
+			}
+			if to > b.Done {
+				to = b.Done
+			}
+			if to > from {
+				b.Running += float64(to-from) / float64(bucket)
+			}
+			if act.Package == "" || (to <= from && act.Duration > 0) {
+				continue
+			}
+			if pkgs[i] == nil {
+				pkgs[i] = make(map[string]bool)
+			}
+			pkgs[i][act.Package] = true
+		}
+	}
+	spans, peak := concurrency(acts, opt.store.start())
+	for _, s := range spans {
+		for i := index(s.start); i <= index(s.done); i++ {
+			if s.done > buckets[i].Start && s.running > buckets[i].Max {
+				buckets[i].Max = s.running
+			}
+		}
+	}
+
+	for i := range buckets {
+		b := &buckets[i]
+		for pkg := range pkgs[i] {
+			b.Packages = append(b.Packages, pkg)
+		}
+		sort.Strings(b.Packages)
+		if peak > 0 {
+			b.Percent = 100 * b.Running / float64(peak)
+		}
+		if err := out.row(b); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
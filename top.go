@@ -2,17 +2,17 @@ package main
 
 import (
 	"fmt"
-	"sort"
-	"text/template"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
 )
 
 func addTopCommand(cmd *cobra.Command) {
 	topCmd := cobra.Command{
 		GroupID: "actiongraph",
-		Use:     "top [-f compile.json] [-n limit]",
+		Use:     "top [-f compile.json] [-n limit] [--group-by domain]",
 		Short:   "List slowest build steps",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			opt, err := loadOptions(cmd)
@@ -26,30 +26,92 @@ func addTopCommand(cmd *cobra.Command) {
 				return err
 			}
 
-			tplStr, err := flags.GetString("tpl")
+			less := byDuration
+			if sortBy, err := flags.GetString("sort"); err != nil {
+				return err
+			} else if sortBy != "duration" {
+				if !slices.Contains(opt.defined, sortBy) {
+					return fmt.Errorf("--sort: %q is neither duration nor a field given by --define", sortBy)
+				}
+				less = byField(sortBy)
+			}
+
+			grouping, err := flags.GetString("group-by")
 			if err != nil {
 				return err
 			}
-			tpl, err := template.New("top").Funcs(opt.funcs).Parse(tplStr)
+			if grouping != "" {
+				var mods *modules
+				if grouping == "module" {
+					main, _ := opt.mainModules(cmd.Context())
+					mods = loadModules(opt.store.actions, main)
+				}
+				group, err := actionGroup(grouping, mods)
+				if err != nil {
+					return err
+				}
+				out, err := newRowWriter(cmd, opt, groupFormats)
+				if err != nil {
+					return err
+				}
+				return topGroups(opt, limit, group, out)
+			}
+
+			out, err := newRowWriter(cmd, opt, topFormats)
 			if err != nil {
-				return fmt.Errorf("parsing tpl: %w", err)
+				return err
 			}
 
-			return top(opt, limit, tpl)
+			return top(opt, limit, less, out)
 		},
 	}
 	flags := topCmd.Flags()
 	flags.IntP("limit", "n", 20, "number of slowest build steps to show")
-	flags.String("tpl", `{{ .Duration | seconds | right 8 }}{{ .CumulativePercent | percent | right 8 }}  {{.Mode}}	{{.Package}}`, "template for output")
+	flags.String("sort", "duration", "order by duration or, descending, by a field given by --define")
+	flags.String("group-by", "", "total the actions by "+strings.Join(groupings, ", ")+", such as github.com/org for domain")
+	addFormatFlags(&topCmd)
 	cmd.AddCommand(&topCmd)
 }
 
-func top(opt *options, limit int, tpl *template.Template) error {
-	actions := opt.actions
+var topFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Cumulative", tpl: `{{ .CumulativePercent | percent }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "ID", tpl: `{{ .ID }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Cumulative", tpl: `{{ .CumulativePercent | percent }}`},
+		{name: "Start", tpl: `+{{ .StartOffset | seconds }}`},
+		{name: "Done", tpl: `+{{ .DoneOffset | seconds }}`},
+		{name: "Cached", tpl: `{{ if .Cached }}cached{{ end }}`, left: true},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "id", tpl: `{{ .ID }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "cumulative_percent", tpl: `{{ .CumulativePercent | printf "%.2f" }}`},
+		{name: "cached", tpl: `{{ .Cached }}`},
+		{name: "time_start", tpl: `{{ .TimeStart | iso8601 }}`},
+		{name: "time_done", tpl: `{{ .TimeDone | iso8601 }}`},
+		{name: "start_offset", tpl: `{{ .StartOffset.Seconds | printf "%.3f" }}`},
+		{name: "done_offset", tpl: `{{ .DoneOffset.Seconds | printf "%.3f" }}`},
+	},
+}
 
-	sort.Slice(actions, func(i, j int) bool {
-		return actions[i].Duration >= actions[j].Duration
-	})
+func top(opt *options, limit int, less func(a, b *action) bool, out *rowWriter) error {
+	actions := opt.sorted(less)
 
 	var cum time.Duration
 	for i, node := range actions {
@@ -58,16 +120,15 @@ func top(opt *options, limit int, tpl *template.Template) error {
 		}
 
 		cum += node.Duration
-		err := tpl.Execute(opt.stdout, topAction{
-			action:            node,
-			CumulativePercent: 100 * float64(cum) / float64(opt.total),
+		err := out.row(topAction{
+			action:            *node,
+			CumulativePercent: opt.store.percent(cum),
 		})
 		if err != nil {
 			return err
 		}
-		fmt.Fprintln(opt.stdout)
 	}
-	return nil
+	return out.flush()
 }
 
 type topAction struct {
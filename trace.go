@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addTraceCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "trace [-f compile.json] [-n limit] trace.json",
+		Short:   "Compare the actions with the go command's own trace of the build",
+		Long: `Join the actions onto the spans of the trace written by the go command's
+-debug-trace flag for the same build, and list those which waited longest for
+the go command to start them after their dependencies had finished, such as
+for a free worker:
+
+    go build -debug-actiongraph=compile.json -debug-trace=trace.json ./...
+    actiongraph trace -f compile.json trace.json
+
+Beneath the table, the time of the whole build command is broken down into the
+time spent loading packages before the first action, running actions, with no
+action running, and finishing after the last.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			f, err := openFile(args[0])
+			if err != nil {
+				return err
+			}
+			spans, err := readDebugTrace(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+
+			out, err := newRowWriter(cmd, opt, traceFormats)
+			if err != nil {
+				return err
+			}
+			return traceActions(opt, spans, limit, out)
+		},
+	}
+	cmd.Flags().IntP("limit", "n", 20, "number of actions to show")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var traceFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Wait", tpl: `{{ .Wait | colordur }}`},
+		{name: "Trace", tpl: `{{ .Trace | seconds }}`},
+		{name: "Action", tpl: `{{ .Duration | seconds }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Wait", tpl: `{{ .Wait | colordur }}`},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Wait", tpl: `{{ .Wait | colordur }}`},
+		{name: "Trace", tpl: `{{ .Trace | seconds }}`},
+		{name: "Action", tpl: `{{ .Duration | seconds }}`},
+		{name: "Overhead", tpl: `{{ .Overhead | seconds }}`},
+		{name: "ID", tpl: `{{ .ID }}`},
+		{name: "Mode", tpl: `{{ .Mode }}`, left: true},
+		{name: "Package", tpl: `{{ .Package }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "id", tpl: `{{ .ID }}`},
+		{name: "mode", tpl: `{{ .Mode }}`},
+		{name: "package", tpl: `{{ .Package }}`},
+		{name: "wait", tpl: `{{ .Wait.Seconds | printf "%.6f" }}`},
+		{name: "trace", tpl: `{{ .Trace.Seconds | printf "%.6f" }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.6f" }}`},
+		{name: "overhead", tpl: `{{ .Overhead.Seconds | printf "%.6f" }}`},
+	},
+}
+
+// traceSpan is a complete span of the trace written by -debug-trace.
+type traceSpan struct {
+	Name       string
+	Start, End time.Time
+}
+
+// readDebugTrace reads the spans of the trace written by the go command's
+// -debug-trace flag, which is an array of events in the Chrome trace event
+// format. Each span begins and ends with an event of the same name on the
+// same thread.
+func readDebugTrace(r io.Reader) ([]traceSpan, error) {
+	var events []struct {
+		Name string  `json:"name"`
+		Ph   string  `json:"ph"`
+		Ts   float64 `json:"ts"` // Microseconds since the Unix epoch.
+		Tid  int     `json:"tid"`
+	}
+	if err := json.NewDecoder(r).Decode(&events); err != nil {
+		return nil, fmt.Errorf("decoding trace: %w", err)
+	}
+
+	type key struct {
+		tid  int
+		name string
+	}
+	open := make(map[key][]time.Time)
+	var spans []traceSpan
+	for _, ev := range events {
+		k := key{ev.Tid, ev.Name}
+		ts := time.Unix(0, int64(ev.Ts*1e3))
+		switch ev.Ph {
+		case "B":
+			open[k] = append(open[k], ts)
+		case "E":
+			starts := open[k]
+			if len(starts) == 0 {
+				continue
+			}
+			spans = append(spans, traceSpan{Name: ev.Name, Start: starts[len(starts)-1], End: ts})
+			open[k] = starts[:len(starts)-1]
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+	return spans, nil
+}
+
+// traceAction is an action joined with its span in the trace.
+type traceAction struct {
+	ID       int
+	Mode     string
+	Package  string
+	Wait     time.Duration // From the last of the action's dependencies finishing to its span starting.
+	Trace    time.Duration // Of the span in the trace.
+	Duration time.Duration // Of the action, as recorded in the actiongraph.
+	Overhead time.Duration // Trace less Duration.
+}
+
+// traceActions lists the actions which waited longest for the go command to
+// run them after their dependencies had finished, followed by a breakdown of
+// the build command's time when written as text.
+func traceActions(opt *options, spans []traceSpan, limit int, out *rowWriter) error {
+	// The go command names the span of each action for its mode and package.
+	// Should it run several with the same name, they're matched in order.
+	executing := make(map[string][]traceSpan)
+	var command *traceSpan
+	for i, s := range spans {
+		if name, ok := strings.CutPrefix(s.Name, "Executing action ("); ok {
+			name = strings.TrimSuffix(name, ")")
+			executing[name] = append(executing[name], s)
+		} else if s.Name == "Running build command" && command == nil {
+			command = &spans[i]
+		}
+	}
+	if len(executing) == 0 {
+		return errors.New("the trace has no actions: was it written with -debug-trace?")
+	}
+
+	actions := opt.store.actions
+	order := make([]int, len(actions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return actions[order[i]].TimeStart.Before(actions[order[j]].TimeStart)
+	})
+	matched := make(map[int]traceSpan)
+	for _, id := range order {
+		name := actions[id].Mode + " " + actions[id].Package
+		if queue := executing[name]; len(queue) > 0 {
+			matched[id] = queue[0]
+			executing[name] = queue[1:]
+		}
+	}
+
+	// Break the build command down by whether any action was executing.
+	var first, last time.Time
+	var running, idle time.Duration
+	for _, s := range spans {
+		if !strings.HasPrefix(s.Name, "Executing action (") {
+			continue
+		}
+		switch {
+		case first.IsZero():
+			first, last = s.Start, s.End
+			running = s.End.Sub(s.Start)
+		case s.Start.After(last):
+			idle += s.Start.Sub(last)
+			running += s.End.Sub(s.Start)
+			last = s.End
+		case s.End.After(last):
+			running += s.End.Sub(last)
+			last = s.End
+		}
+	}
+	if command == nil {
+		command = &traceSpan{Start: spans[0].Start, End: last}
+	}
+
+	var rows []*traceAction
+	var wait, overhead time.Duration
+	for id, s := range matched {
+		act := &actions[id]
+		if !opt.include(act) {
+			continue
+		}
+		ready := first
+		for _, dep := range act.Deps {
+			if d, ok := matched[dep]; ok && d.End.After(ready) {
+				ready = d.End
+			}
+		}
+		t := &traceAction{
+			ID:       id,
+			Mode:     act.Mode,
+			Package:  act.Package,
+			Trace:    s.End.Sub(s.Start),
+			Duration: act.Duration,
+		}
+		if s.Start.After(ready) {
+			t.Wait = s.Start.Sub(ready)
+		}
+		t.Overhead = t.Trace - t.Duration
+		wait += t.Wait
+		overhead += t.Overhead
+		rows = append(rows, t)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Wait != rows[j].Wait {
+			return rows[i].Wait > rows[j].Wait
+		}
+		return rows[i].ID < rows[j].ID
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	for _, t := range rows {
+		if err := out.row(t); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	fmt.Fprintf(opt.stdout, "%.3fs  loading packages, before the first action\n", first.Sub(command.Start).Seconds())
+	fmt.Fprintf(opt.stdout, "%.3fs  running actions\n", running.Seconds())
+	fmt.Fprintf(opt.stdout, "%.3fs  with no action running\n", idle.Seconds())
+	fmt.Fprintf(opt.stdout, "%.3fs  after the last action\n", command.End.Sub(last).Seconds())
+	fmt.Fprintf(opt.stdout, "%.3fs  total, running the build command\n", command.End.Sub(command.Start).Seconds())
+	fmt.Fprintf(opt.stdout, "%.3fs  waited by actions whose dependencies had finished\n", wait.Seconds())
+	fmt.Fprintf(opt.stdout, "%.3fs  traced beyond the actions' own durations\n", overhead.Seconds())
+	if n := len(actions) - len(matched); n > 0 {
+		fmt.Fprintf(opt.stdout, "%d actions weren't in the trace\n", n)
+	}
+	return nil
+}
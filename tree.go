@@ -1,11 +1,16 @@
 package main
 
 import (
+	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	htmltpl "html/template"
 	"strings"
 	"text/template"
 	"time"
 
+	"github.com/icio/actiongraph/actiongraph"
 	"github.com/spf13/cobra"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
@@ -13,10 +18,11 @@ import (
 
 func addTreeCommand(prog *cobra.Command) {
 	cmd := cobra.Command{
-		GroupID: "actiongraph",
-		Use:     "tree [-m] [-f compile.json] [package...]",
-		Short:   "Total build times by directory",
-		Args:    cobra.ArbitraryArgs,
+		GroupID:           "actiongraph",
+		Use:               "tree [-m] [-f compile.json] [--group-by domain] [package...]",
+		Short:             "Total build times by directory",
+		Args:              cobra.ArbitraryArgs,
+		ValidArgsFunction: completePackages(true),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			opt, err := loadOptions(cmd)
 			if err != nil {
@@ -24,60 +30,196 @@ func addTreeCommand(prog *cobra.Command) {
 			}
 
 			flags := cmd.Flags()
-			level, err := flags.GetInt("level")
+			topt := treeOptions{focus: args}
+			topt.level, err = flags.GetInt("level")
 			if err != nil {
-				return nil
+				return err
 			}
 
-			tplStr, err := flags.GetString("tpl")
+			sortBy, err := flags.GetString("sort")
+			if err != nil {
+				return err
+			}
+			var ok bool
+			topt.less, ok = treeSorts[sortBy]
+			if !ok {
+				return fmt.Errorf("unknown --sort %q: expected one of %s", sortBy, strings.Join(treeSortNames, ", "))
+			}
+
+			topt.minDuration, err = flags.GetDuration("min-duration")
+			if err != nil {
+				return err
+			}
+			topt.minPercent, err = flags.GetFloat64("min-percent")
 			if err != nil {
 				return err
 			}
+			topt.compact, err = flags.GetBool("compact")
+			if err != nil {
+				return err
+			}
+			byModule, err := flags.GetBool("modules")
+			if err != nil {
+				return err
+			}
+			grouping, err := flags.GetString("group-by")
+			if err != nil {
+				return err
+			}
+			switch grouping {
+			case "directory":
+			case "module":
+				byModule = true
+			case "domain":
+				if byModule {
+					return errors.New("--modules and --group-by domain are mutually exclusive")
+				}
+				topt.domains = true
+			default:
+				return fmt.Errorf("unknown --group-by %q: expected one of %s", grouping, strings.Join(groupings, ", "))
+			}
+			if byModule {
+				// The main modules are built from their own directories rather
+				// than the module cache, so we need to be told about them.
+				// Outside of a module we rely on the module cache paths alone.
+				main, _ := opt.mainModules(cmd.Context())
+				topt.modules = loadModules(opt.store.actions, main)
+			}
+
+			exclude, err := flags.GetStringArray("exclude")
+			if err != nil {
+				return err
+			}
+			topt.exclude, err = parsePkgGlobs(exclude)
+			if err != nil {
+				return fmt.Errorf("parsing --exclude: %w", err)
+			}
+
+			topt.format, err = flags.GetString("format")
+			if err != nil {
+				return err
+			}
+			if topt.format != "text" && topt.format != "heat" && topt.format != "json" && topt.format != "html" {
+				return fmt.Errorf("unknown --format %q: expected text, heat, json or html", topt.format)
+			}
+
+			tplStr, err := tplFlag(flags)
+			if err != nil {
+				return err
+			}
+			if topt.format == "heat" {
+				if flags.Changed("tpl") || flags.Changed("tpl-file") {
+					return errors.New("--format heat and --tpl are mutually exclusive")
+				}
+				tplStr = treeHeatTpl
+			}
 			tpl, err := template.New("top").Funcs(opt.funcs).Parse(tplStr)
 			if err != nil {
 				return fmt.Errorf("parsing tpl: %w", err)
 			}
 
-			return tree(opt, level, args, tpl)
+			return tree(opt, topt, tpl)
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.IntP("level", "L", -1, "descend only level directories deep (-ve for unlimited)")
-	flags.String("tpl", `{{ .CumulativeDuration | seconds | right 8 }} {{ if eq .ID -1 }}        {{ else }}{{ .Duration | seconds | right 8 }}{{ end }} {{.Indent}}{{.Package}}`, "template for output")
+	flags.String("sort", "duration", "order of children within each directory: "+strings.Join(treeSortNames, ", "))
+	flags.StringArray("exclude", nil, "omit packages matching the glob (e.g. std/... or **/mocks) and their subpackages")
+	cmd.RegisterFlagCompletionFunc("exclude", completePackages(true))
+	flags.Bool("modules", false, "group packages by their module rather than their first directory (as --group-by module)")
+	flags.String("group-by", "directory", "group packages at the top level by "+strings.Join(groupings, ", ")+", such as github.com/org for domain")
+	flags.Bool("compact", false, "merge directories having a single child into one row")
+	flags.Duration("min-duration", 0, "collapse subtrees taking less than this into (other)")
+	flags.Float64("min-percent", 0, "collapse subtrees taking less than this percentage of the total into (other)")
+	flags.StringP("format", "o", "text", "output format: text, heat, json or html")
+	annotateFormats(flags, "text", "heat", "json", "html")
+	addTplFlags(flags, treeTpl, "template for output")
 
 	prog.AddCommand(&cmd)
 }
 
-func tree(opt *options, level int, focus []string, tpl *template.Template) error {
-	actions := opt.actions
-	root := buildTree(actions)
+// treeTpl is the default template of the text format, and treeHeatTpl that of
+// the heat format, which adds a cell shaded by the percentage of the build
+// spent within each directory.
+const (
+	treeTpl     = `{{ .CumulativeDuration | seconds | right 8 }} {{ if eq .ID -1 }}        {{ else }}{{ .Duration | colordur | right 8 }}{{ end }} {{ .Count | printf "%d" | right 5 }} {{ .Mean | seconds | right 8 }} {{ .CacheHitPercent | percent | right 7 }} {{.Indent}}{{.Package}}`
+	treeHeatTpl = `{{ .CumulativePercent | heat }} {{ .CumulativeDuration | seconds | right 8 }} {{ .CumulativePercent | percent | right 7 }} {{ .Count | printf "%d" | right 5 }} {{.Indent}}{{.Package}}`
+)
 
-	if len(focus) != 0 {
-		filterActs := make([]action, len(focus))
-		for i, pkg := range focus {
-			filterActs[i] = action{
-				ID:      0,       // buildTree and pruneTree use -1 for intermediary nodes.
-				Mode:    "build", // buildTree ignores non-build actions.
-				Package: strings.TrimRight(pkg, "/."),
-			}
-		}
-		pruneTree(root, buildTree(filterActs))
+// treeSorts are the orderings of children within each directory that are
+// available to the --sort flag.
+var treeSorts = map[string]func(a, b *pkgtree) bool{
+	"duration": func(a, b *pkgtree) bool { return a.d > b.d },
+	"name":     func(a, b *pkgtree) bool { return a.path < b.path },
+	"count":    func(a, b *pkgtree) bool { return a.count > b.count },
+	"self":     func(a, b *pkgtree) bool { return a.self > b.self },
+}
+
+var treeSortNames = []string{"duration", "name", "count", "self"}
+
+type treeOptions struct {
+	level int
+	less  func(a, b *pkgtree) bool
+	focus []string
+
+	// Packages within exclude are left out of the tree entirely.
+	exclude []pkgGlob
+
+	// Subtrees smaller than minDuration or minPercent are merged into a
+	// single (other) node within their directory.
+	minDuration time.Duration
+	minPercent  float64
+
+	// compact merges chains of single-child directories.
+	compact bool
+
+	// format is either "text" or "heat", to render each node using the
+	// template, "json" for the nested structure, or "html" for an icicle
+	// chart.
+	format string
+
+	// modules, if set, groups the top level of the tree by module rather
+	// than by the first path segment.
+	modules *modules
+
+	// domains groups the top level of the tree by domain instead, as given
+	// by the domain function.
+	domains bool
+}
+
+func tree(opt *options, topt treeOptions, tpl *template.Template) error {
+	root := arrangeTree(opt, topt)
+	switch topt.format {
+	case "json":
+		return treeJSON(opt, topt, root)
+	case "html":
+		return treeHTML(opt, topt, root)
 	}
 
+	// dirs is the stack of directories being displayed, and indents the
+	// number of displayed ancestors of the nodes in each.
 	dirs := append(make([][]*pkgtree, 0, 10), []*pkgtree{root})
+	indents := append(make([]int, 0, 10), 0)
 	for len(dirs) > 0 {
 		// Step up from empty paths.
 		last := len(dirs) - 1
 		if len(dirs[last]) == 0 {
 			dirs = dirs[:last]
+			indents = indents[:last]
 			continue
 		}
 
-		// Take the next node.
+		// Take the next node. Nodes beyond the level limit are skipped, unless
+		// they lead to a focus node.
 		n := dirs[last][0]
 		dirs[last] = dirs[last][1:]
-		if level >= 0 && n.depth > level {
+		indent := indents[last]
+		if topt.level >= 0 && n.depth > topt.level {
+			if kids := topt.children(opt, n); n.focus && len(kids) > 0 {
+				dirs = append(dirs, kids)
+				indents = append(indents, indent)
+			}
 			continue
 		}
 
@@ -86,13 +228,20 @@ func tree(opt *options, level int, focus []string, tpl *template.Template) error
 			ID:                 n.id,
 			Package:            n.path,
 			Depth:              n.depth,
-			Indent:             strings.Repeat("  ", last),
-			CumulativePercent:  100 * float64(n.d) / float64(opt.total),
+			Indent:             strings.Repeat("  ", indent),
+			CumulativePercent:  opt.store.percent(n.d),
 			CumulativeDuration: n.d,
+			Count:              n.count,
+			CacheHits:          n.hits,
+		}
+		if n.count > 0 {
+			node.Mean = n.d / time.Duration(n.count)
+			node.CacheHitPercent = 100 * float64(n.hits) / float64(n.count)
 		}
 		if n.id > 0 {
-			node.action = actions[n.id]
+			node.action = opt.store.actions[n.id]
 		}
+		node.StartOffset, node.DoneOffset = n.start, n.done
 		err := tpl.Execute(opt.stdout, node)
 		if err != nil {
 			return err
@@ -100,26 +249,227 @@ func tree(opt *options, level int, focus []string, tpl *template.Template) error
 		fmt.Fprintln(opt.stdout)
 
 		// Step into the children.
-		if len(n.dir) > 0 {
-			kids := maps.Values(n.dir)
-			slices.SortFunc(kids, func(a, b *pkgtree) bool { return a.d > b.d })
+		if kids := topt.children(opt, n); len(kids) > 0 {
 			dirs = append(dirs, kids)
+			indents = append(indents, indent+1)
 			continue
 		}
 	}
 	return nil
 }
 
+// arrangeTree builds the tree of packages and applies the focus and compaction
+// options to it.
+func arrangeTree(opt *options, topt treeOptions) *pkgtree {
+	var top func(string) int
+	if topt.modules != nil {
+		top = func(path string) int {
+			if pkg, ok := strings.CutPrefix(path, "std/"); ok && isStdlib(pkg) {
+				return len("std")
+			}
+			return len(topt.modules.module(path))
+		}
+	} else if topt.domains {
+		top = func(path string) int {
+			if path == "std" || strings.HasPrefix(path, "std/") {
+				return len("std")
+			}
+			return len(domain(path, false))
+		}
+	}
+	if top != nil {
+		top = renamedTop(opt, top)
+	}
+	root := buildTree(opt.actions(), func(path string) bool {
+		return !matchAny(topt.exclude, path)
+	}, top)
+
+	if len(topt.focus) != 0 {
+		filterActs := make([]*action, len(topt.focus))
+		for i, pkg := range topt.focus {
+			pkg = strings.TrimRight(pkg, "/.")
+			filterActs[i] = &action{
+				Action: actiongraph.Action{
+					ID:      0,       // buildTree and pruneTree use -1 for intermediary nodes.
+					Mode:    "build", // buildTree ignores non-build actions.
+					Package: pkg,
+				},
+				Std: isStdlib(pkg),
+			}
+		}
+		pruneTree(root, buildTree(filterActs, nil, top))
+	}
+	if topt.compact {
+		compactTree(root, 0)
+	}
+	return root
+}
+
+// renamedTop wraps top, which finds the module or domain of a tree path, so
+// that it finds those of the packages renamed by --aliases, --trim-prefix or
+// --rewrite by their import paths, when the renamed path still begins with
+// the renamed module or domain.
+func renamedTop(opt *options, top func(path string) int) func(path string) int {
+	if len(opt.store.renamed) == 0 {
+		return top
+	}
+	tops := make(map[string]int)
+	for i := range opt.store.actions {
+		act := &opt.store.actions[i]
+		if act.Mode != "build" || act.Std {
+			continue
+		}
+		imported := treePath(act.importPath(), false)
+		path := treePath(act.Package, false)
+		group := opt.store.shownPath(imported[:top(imported)])
+		if path == group || strings.HasPrefix(path, group+"/") {
+			tops[path] = len(group)
+		}
+	}
+	return func(path string) int {
+		if n, ok := tops[path]; ok {
+			return n
+		}
+		return top(path)
+	}
+}
+
+// children returns the children of n in display order, with any small
+// subtrees merged.
+func (topt treeOptions) children(opt *options, n *pkgtree) []*pkgtree {
+	if len(n.dir) == 0 {
+		return nil
+	}
+	kids := maps.Values(n.dir)
+	slices.SortFunc(kids, func(a, b *pkgtree) bool {
+		// Fall back to the path so that the order is stable between runs.
+		if topt.less(a, b) {
+			return true
+		} else if topt.less(b, a) {
+			return false
+		}
+		return a.path < b.path
+	})
+	return collapseSmall(kids, n.depth+1, func(k *pkgtree) bool {
+		return k.d < topt.minDuration || opt.store.percent(k.d) < topt.minPercent
+	})
+}
+
+// treeNode is the JSON representation of a directory in the tree.
+type treeNode struct {
+	ID                 int
+	Package            string
+	Depth              int
+	Duration           time.Duration
+	CumulativeDuration time.Duration
+	CumulativePercent  float64
+	Count              int
+	Mean               time.Duration
+	CacheHits          int
+	CacheHitPercent    float64
+	Children           []*treeNode `json:",omitempty"`
+}
+
+// treeNodes converts the tree into its JSON representation, applying the
+// ordering and level options.
+func treeNodes(opt *options, topt treeOptions, n *pkgtree) *treeNode {
+	node := &treeNode{
+		ID:                 n.id,
+		Package:            n.path,
+		Depth:              n.depth,
+		Duration:           n.self,
+		CumulativeDuration: n.d,
+		CumulativePercent:  opt.store.percent(n.d),
+		Count:              n.count,
+		CacheHits:          n.hits,
+	}
+	if n.count > 0 {
+		node.Mean = n.d / time.Duration(n.count)
+		node.CacheHitPercent = 100 * float64(n.hits) / float64(n.count)
+	}
+	for _, kid := range topt.children(opt, n) {
+		if topt.level >= 0 && kid.depth > topt.level && !kid.focus {
+			continue
+		}
+		node.Children = append(node.Children, treeNodes(opt, topt, kid))
+	}
+	return node
+}
+
+func treeJSON(opt *options, topt treeOptions, root *pkgtree) error {
+	enc := json.NewEncoder(opt.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(treeNodes(opt, topt, root))
+}
+
+//go:embed tree.html
+var treeHTMLTemplate string
+
+// treeHTML writes a standalone HTML page with a zoomable icicle chart of the
+// tree.
+func treeHTML(opt *options, topt treeOptions, root *pkgtree) error {
+	tpl, err := htmltpl.New("tree").Parse(treeHTMLTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(opt.stdout, map[string]any{
+		"Title": fmt.Sprintf("actiongraph tree: %.3fs", opt.store.total.Seconds()),
+		"Root":  treeNodes(opt, topt, root),
+	})
+}
+
+// collapseSmall replaces the kids which are small with a single (other) node
+// at the end of the list, totalling their durations.
+func collapseSmall(kids []*pkgtree, depth int, small func(*pkgtree) bool) []*pkgtree {
+	keep := kids[:0]
+	other := pkgtree{path: "(other)", depth: depth, id: -1}
+	for _, k := range kids {
+		if small(k) {
+			other.add(k.d, k.count, k.hits, k.start, k.done)
+		} else {
+			keep = append(keep, k)
+		}
+	}
+	if other.count > 0 {
+		keep = append(keep, &other)
+	}
+	return keep
+}
+
 type pkgtree struct {
 	path  string
 	depth int
-	d     time.Duration
+	d     time.Duration // Cumulative duration of the actions in this subtree.
+	self  time.Duration // Duration of this node's own action.
+	count int           // Number of actions in this subtree.
+	hits  int           // Number of cached actions in this subtree.
+	start time.Duration // Earliest StartOffset of the actions in this subtree.
+	done  time.Duration // Latest DoneOffset of the actions in this subtree.
+	focus bool          // Whether the subtree contains an explicit focus node.
 	id    int
 
 	dir map[string]*pkgtree
 }
 
-func buildTree(actions []action) *pkgtree {
+// add accounts for an action, or the actions of another subtree, within the
+// subtree.
+func (n *pkgtree) add(d time.Duration, count, hits int, start, done time.Duration) {
+	if n.count == 0 || start < n.start {
+		n.start = start
+	}
+	if done > n.done {
+		n.done = done
+	}
+	n.d += d
+	n.count += count
+	n.hits += hits
+}
+
+// buildTree arranges the build actions into a tree by their package path. If
+// include is non-nil, only the packages whose tree path it accepts are added.
+// If top is non-nil, it gives the length of the prefix of each tree path which
+// forms its top-level directory, instead of the first path segment.
+func buildTree(actions []*action, include func(path string) bool, top func(path string) int) *pkgtree {
 	root := pkgtree{
 		path: "(root)",
 		id:   -1,
@@ -131,26 +481,27 @@ func buildTree(actions []action) *pkgtree {
 			continue
 		}
 
-		// Assume all packages without a "." are part of the standard library.
-		// TODO: Go modules don't need to start with a domain, so this is wrong.
-		pkg := act.Package
-		if isStdlib(pkg) {
-			pkg = "std/" + pkg
+		pkg := treePath(act.Package, act.Std)
+		if include != nil && !include(pkg) {
+			continue
 		}
 
 		// Create the tree of nodes for this one package.
+		hit := 0
+		if act.Cached {
+			hit = 1
+		}
 		actNode := &root
-		actNode.d += act.Duration
-		p := 0
+		actNode.add(act.Duration, 1, hit, act.StartOffset, act.DoneOffset)
 		depth := 0
-		for more := true; more; {
+		for p := 0; p < len(pkg); {
 			depth++
 
 			// Read the next deepest path from pkg.
-			pn := strings.Index(pkg[p+1:], "/")
-			if pn == -1 {
+			if depth == 1 && top != nil {
+				p = top(pkg)
+			} else if pn := strings.Index(pkg[p+1:], "/"); pn == -1 {
 				p = len(pkg)
-				more = false
 			} else {
 				p += pn + 1
 			}
@@ -160,74 +511,97 @@ func buildTree(actions []action) *pkgtree {
 			if actNode.dir == nil {
 				actNode.dir = make(map[string]*pkgtree, 1)
 			}
-			p := actNode.dir[path]
-			if p == nil {
-				p = &pkgtree{
+			node := actNode.dir[path]
+			if node == nil {
+				node = &pkgtree{
 					id:    -1,
 					path:  path,
 					depth: depth,
 				}
-				actNode.dir[path] = p
+				actNode.dir[path] = node
 			}
 
 			// Descend into the node for this path.
-			actNode = p
-			actNode.d += act.Duration
+			actNode = node
+			actNode.add(act.Duration, 1, hit, act.StartOffset, act.DoneOffset)
 		}
 
 		actNode.id = act.ID
+		actNode.self = act.Duration
 	}
 	return &root
 }
 
-func isStdlib(pkg string) bool {
-	root, _, _ := strings.Cut(pkg, "/")
-	return !strings.Contains(root, ".")
+// treePath returns the path of pkg within the tree, which groups the standard
+// library under std.
+func treePath(pkg string, std bool) string {
+	if pkg == "std" || strings.HasPrefix(pkg, "std/") {
+		return pkg
+	}
+	if std {
+		return "std/" + pkg
+	}
+	return pkg
+}
+
+// compactTree replaces each chain of directories having only a single child,
+// and no package of their own, with the last directory in the chain. The depth
+// of the nodes beneath the chain is reduced by the number of nodes removed.
+func compactTree(n *pkgtree, shift int) {
+	n.depth -= shift
+	for _, path := range maps.Keys(n.dir) {
+		kid := n.dir[path]
+		kidShift := shift
+		for kid.id == -1 && len(kid.dir) == 1 {
+			for _, k := range kid.dir {
+				kid = k
+			}
+			kidShift++
+		}
+		if kidShift != shift {
+			delete(n.dir, path)
+			n.dir[kid.path] = kid
+		}
+		compactTree(kid, kidShift)
+	}
 }
 
-// pruneTree removes dir grandchildren from root that are not in keep and resets
-// the depth according to the closest grandchild in keep.
+// pruneTree removes the nodes from root which are neither within nor leading
+// to the nodes explicitly added to keep. The explicitly kept nodes and the
+// directories leading to them are given a depth of 0, and the depth of the
+// nodes within an explicitly kept node are made relative to it, so that their
+// level can be limited from that point. Nodes containing explicitly kept nodes
+// beneath them are marked as focus nodes.
 func pruneTree(root, keep *pkgtree) {
-	type job struct{ r, k *pkgtree }
-	work := make([]job, 0, 10)
-	work = append(work, job{root, keep})
-
-	// TODO: actiongraph tree pkg1 pkg1/pkg2/pkg3 -L 0 should show
-	// pkg1/pkg2/pkg3 but we don't currently traverse past pkg1.
-	for len(work) > 0 {
-		j := work[len(work)-1]
-		work = work[:len(work)-1]
-
-		// TODO: Can we consolidate these two cases?
-		if j.k.id == -1 {
-			// Branch nodes in keep have ID -1. We want to kepe the common
-			// children of root and keep.
-			for path, rChild := range j.r.dir {
-				rChild.depth = 0
-				kChild := j.k.dir[path]
-				if kChild == nil {
-					delete(j.r.dir, path)
-				} else {
-					work = append(work, job{rChild, kChild})
-				}
-			}
+	var prune func(r, k *pkgtree, base int) bool
+	prune = func(r, k *pkgtree, base int) bool {
+		explicit := k != nil && k.id != -1
+		if explicit {
+			base = r.depth
+		}
+		if base >= 0 {
+			r.depth -= base
 		} else {
-			// This path was explicitly added to keep. We want to reset the
-			// depth of each child from this point.
-			for path, rChild := range j.r.dir {
-				rChild.depth -= j.k.depth
-				kChild := j.k.dir[path]
-				if kChild == nil {
-					kChild = &pkgtree{
-						id:    0,
-						path:  path,
-						depth: j.k.depth, // Keep this depth.
-					}
-				}
-				work = append(work, job{rChild, kChild})
+			r.depth = 0
+		}
+
+		for path, rChild := range r.dir {
+			var kChild *pkgtree
+			if k != nil {
+				kChild = k.dir[path]
+			}
+			if kChild == nil && base < 0 {
+				// Neither within nor leading to a kept node.
+				delete(r.dir, path)
+				continue
+			}
+			if prune(rChild, kChild, base) {
+				r.focus = true
 			}
 		}
+		return explicit || r.focus
 	}
+	prune(root, keep, -1)
 }
 
 type treeAction struct {
@@ -237,5 +611,12 @@ type treeAction struct {
 	Depth              int
 	CumulativeDuration time.Duration
 	CumulativePercent  float64
+	Count              int           // Number of actions in the subtree.
+	Mean               time.Duration // Mean duration of the actions in the subtree.
+	CacheHits          int           // Number of actions in the subtree taken from the cache.
+	CacheHitPercent    float64
+
+	// The action of the package at the node, if any. Its StartOffset and
+	// DoneOffset are replaced by the earliest and latest of the subtree.
 	action
 }
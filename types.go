@@ -1,20 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"text/template"
 	"time"
 
-	"golang.org/x/exp/maps"
-
 	"github.com/spf13/cobra"
 )
 
 func addTypesCommand(cmd *cobra.Command) {
 	topCmd := cobra.Command{
 		GroupID: "actiongraph",
-		Use:     "types [-f compile.json] [-n limit]",
+		Use:     "types [-f compile.json] [--top N]",
 		Short:   "List slowest action types",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			opt, err := loadOptions(cmd)
@@ -24,54 +23,166 @@ func addTypesCommand(cmd *cobra.Command) {
 
 			flags := cmd.Flags()
 
-			tplStr, err := flags.GetString("tpl")
+			tplStr, err := tplFlag(flags)
+			if err != nil {
+				return err
+			}
+			actStr, err := flags.GetString("action-tpl")
 			if err != nil {
 				return err
 			}
+			format, err := flags.GetString("format")
+			if err != nil {
+				return err
+			}
+			// The header of the markdown table is written only once the
+			// templates have been parsed, so nothing is written on an error.
+			var header string
+			switch format {
+			case "text":
+			case "markdown", "md":
+				if flags.Changed("tpl") || flags.Changed("tpl-file") || flags.Changed("action-tpl") {
+					return errors.New("--format markdown and --tpl are mutually exclusive")
+				}
+				header = typesMarkdownHeader
+				tplStr, actStr = typesMarkdownTpl, typesMarkdownActionTpl
+			default:
+				return fmt.Errorf("unknown --format %q: expected text or markdown", format)
+			}
+
 			tpl, err := template.New("top").Funcs(opt.funcs).Parse(tplStr)
 			if err != nil {
 				return fmt.Errorf("parsing tpl: %w", err)
 			}
 
-			return typesTop(opt, tpl)
+			limit, err := flags.GetInt("top")
+			if err != nil {
+				return err
+			}
+			actTpl, err := template.New("action").Funcs(opt.funcs).Parse(actStr)
+			if err != nil {
+				return fmt.Errorf("parsing action-tpl: %w", err)
+			}
+
+			fmt.Fprint(opt.stdout, header)
+			return typesTop(opt, tpl, limit, actTpl)
 		},
 	}
 	flags := topCmd.Flags()
-	flags.String("tpl", `{{ .Duration | seconds | right 8 }}{{ .Percentage | percent | right 8 }}  {{.Mode}}`, "template for output")
+	addTplFlags(flags, `{{ .Duration | seconds | right 9 }}{{ .Percentage | percent | right 8 }}{{ .Count | printf "%d" | right 6 }}{{ .Mean | seconds | right 9 }}{{ .Median | seconds | right 9 }}{{ .P95 | seconds | right 9 }}{{ .Max | seconds | right 9 }}  {{.Indent}}{{.Mode}}{{ with .Kind }} ({{.}}){{ end }}`, "template for output")
+	flags.Int("top", 0, "number of slowest actions to list beneath each type")
+	flags.StringP("format", "o", "text", "output format: text or markdown")
+	annotateFormats(flags, "text", "markdown", "md")
+	flags.String("action-tpl", `{{ .Duration | colordur | right 9 }}{{ .Percent | percent | right 8 }}      {{.Indent}}{{.Package}}`, "template for the actions listed by --top")
 	cmd.AddCommand(&topCmd)
 }
 
-func typesTop(opt *options, tpl *template.Template) error {
-	actions := opt.actions
-	types := map[string]typesAction{}
-	var cum time.Duration
-	for _, node := range actions {
-		cum += node.Duration
-		ta, f := types[node.Mode]
-		if !f {
-			ta = typesAction{Mode: node.Mode}
+// The templates used for --format markdown, listing the types and their
+// slowest actions in a single table.
+const (
+	typesMarkdownHeader    = "| Mode | Duration | Percent | Count | Mean | Median | P95 | Max |\n| --- | ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n"
+	typesMarkdownTpl       = `| {{.Mode}}{{ with .Kind }} ({{.}}){{ end }} | {{ .Duration | seconds }} | {{ .Percentage | percent }} | {{ .Count }} | {{ .Mean | seconds }} | {{ .Median | seconds }} | {{ .P95 | seconds }} | {{ .Max | seconds }} |`
+	typesMarkdownActionTpl = `| &emsp;` + "`{{ .Package }}`" + ` | {{ .Duration | seconds }} | {{ .Percent | percent }} | | | | | |`
+)
+
+func typesTop(opt *options, tpl *template.Template, limit int, actTpl *template.Template) error {
+	durations := map[string]*typesDurations{}
+	for _, node := range opt.actions() {
+		td := durations[node.Mode]
+		if td == nil {
+			td = &typesDurations{}
+			durations[node.Mode] = td
+		}
+		td.all = append(td.all, node.Duration)
+		if node.Cached {
+			td.cached = append(td.cached, node.Duration)
+		} else {
+			td.executed = append(td.executed, node.Duration)
 		}
-		ta.Duration += node.Duration
-		ta.Percentage = 100 * float64(ta.Duration) / float64(opt.total)
-		types[node.Mode] = ta
 	}
-	actionTypes := maps.Values(types)
+	actionTypes := make([]typesAction, 0, len(durations))
+	for mode, td := range durations {
+		actionTypes = append(actionTypes, newTypesAction(opt, mode, "", td.all))
+	}
 	sort.Slice(actionTypes, func(i, j int) bool {
-		return actionTypes[i].Duration >= actionTypes[j].Duration
+		if actionTypes[i].Duration != actionTypes[j].Duration {
+			return actionTypes[i].Duration > actionTypes[j].Duration
+		}
+		return actionTypes[i].Mode < actionTypes[j].Mode
 	})
 
+	var slowest []*action
+	if limit > 0 {
+		slowest = opt.sorted(byDuration)
+	}
 	for _, node := range actionTypes {
-		err := tpl.Execute(opt.stdout, node)
-		if err != nil {
-			return err
+		// Follow each mode with its cached and executed actions.
+		td := durations[node.Mode]
+		rows := []typesAction{node}
+		if len(td.cached) > 0 {
+			rows = append(rows, newTypesAction(opt, node.Mode, "cached", td.cached))
+		}
+		if len(td.executed) > 0 {
+			rows = append(rows, newTypesAction(opt, node.Mode, "executed", td.executed))
+		}
+
+		for _, row := range rows {
+			err := tpl.Execute(opt.stdout, row)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(opt.stdout)
+		}
+
+		// Then with its slowest actions.
+		if limit <= 0 {
+			continue
+		}
+		n := 0
+		for _, act := range slowest {
+			if act.Mode != node.Mode {
+				continue
+			}
+			if n++; n > limit {
+				break
+			}
+			err := actTpl.Execute(opt.stdout, typesTopAction{action: *act, Indent: "    "})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(opt.stdout)
 		}
-		fmt.Fprintln(opt.stdout)
 	}
 	return nil
 }
 
+// typesTopAction is one of the slowest actions listed beneath its type.
+type typesTopAction struct {
+	action
+	Indent string
+}
+
+// typesDurations are the durations of the actions of a mode, split by whether
+// they were cached.
+type typesDurations struct {
+	all, cached, executed []time.Duration
+}
+
+func newTypesAction(opt *options, mode, kind string, ds []time.Duration) typesAction {
+	ta := typesAction{Mode: mode, Kind: kind, durationStats: summarise(ds)}
+	if kind != "" {
+		ta.Indent = "  "
+	}
+	ta.Duration = ta.Total
+	ta.Percentage = opt.store.percent(ta.Duration)
+	return ta
+}
+
 type typesAction struct {
 	Mode       string
+	Kind       string // Empty for all actions of the Mode, else "cached" or "executed".
+	Indent     string
 	Duration   time.Duration
 	Percentage float64
+	durationStats
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addUtilizationCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "utilization [-f compile.json] [--cpus N]",
+		Short:   "Estimate how busy the build kept the CPUs, and recommend a -p",
+		Long: `List how long the build spent with each number of actions running at once,
+and with actions ready to run, their dependencies done, but left waiting for a
+worker. Beneath the table, the CPU time of the actions' commands, as recorded
+by the go command in their CmdUser and CmdSys, is spread over the wall time to
+estimate how many of the CPUs were busy on average, followed by a
+recommendation:
+
+  - if no more actions ran at once than there are CPUs, the rest were wasted,
+    and as small a -p would do as well
+  - if actions were often left waiting for a worker, more CPUs, or a larger -p
+    if it was set below their number, would help
+  - otherwise, the dependencies between the actions limited the build, as
+    actiongraph chains shows
+
+The number of CPUs is that of this machine, unless given by --cpus, as that of
+the machine which ran the build, whose go command defaults -p to GOMAXPROCS.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			cpus, err := cmd.Flags().GetInt("cpus")
+			if err != nil {
+				return err
+			}
+			if cpus <= 0 {
+				return errors.New("--cpus must be positive")
+			}
+
+			out, err := newRowWriter(cmd, opt, utilizationFormats)
+			if err != nil {
+				return err
+			}
+			return utilization(opt, cpus, out)
+		},
+	}
+	cmd.Flags().Int("cpus", runtime.NumCPU(), "number of CPUs of the machine which ran the build")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var utilizationFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Running", tpl: `{{ .Running }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Waiting", tpl: `{{ .Waiting | seconds }}`},
+	},
+	short: []formatColumn{
+		{name: "Running", tpl: `{{ .Running }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+	},
+	wide: []formatColumn{
+		{name: "Running", tpl: `{{ .Running }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Waiting", tpl: `{{ .Waiting | seconds }}`},
+		{name: "Ready", tpl: `{{ .Ready | printf "%.1f" }}`},
+	},
+	columns: []formatColumn{
+		{name: "running", tpl: `{{ .Running }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "waiting", tpl: `{{ .Waiting.Seconds | printf "%.3f" }}`},
+		{name: "ready", tpl: `{{ .Ready | printf "%.2f" }}`},
+	},
+}
+
+// concurrencyLevel is the time the build spent with a number of actions
+// running at once.
+type concurrencyLevel struct {
+	Running  int
+	Duration time.Duration
+	Percent  float64       // Of the time from the first action to the last.
+	Waiting  time.Duration // Of the Duration, with actions ready but not started.
+	Ready    float64       // Mean number of actions ready but not started.
+}
+
+// utilizationSaturated is the share of the build, as a percentage, for which
+// actions must have waited for a worker to recommend more of them.
+const utilizationSaturated = 10
+
+// utilization lists the time spent with each number of actions running,
+// followed by the estimated utilization of the CPUs and a recommendation when
+// written as text.
+func utilization(opt *options, cpus int, out *rowWriter) error {
+	acts, err := timedActions(opt)
+	if err != nil {
+		return err
+	}
+	spans, peak := concurrency(acts, opt.store.start())
+
+	levels := make([]concurrencyLevel, peak+1)
+	var span, waiting time.Duration
+	for _, s := range spans {
+		d := s.done - s.start
+		l := &levels[s.running]
+		l.Duration += d
+		l.Ready += float64(s.ready) * float64(d)
+		if s.ready > 0 {
+			l.Waiting += d
+			waiting += d
+		}
+		span += d
+	}
+	for i := range levels {
+		l := &levels[i]
+		l.Running = i
+		if l.Duration > 0 {
+			l.Ready /= float64(l.Duration)
+		}
+		l.Percent = percentOf(l.Duration, span)
+		if err := out.row(l); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	var cpu time.Duration
+	measured := false
+	for _, act := range acts {
+		cpu += act.cpuTime()
+		if act.CmdUser > 0 || act.CmdSys > 0 {
+			measured = true
+		}
+	}
+	wall := opt.store.wall()
+	var busy float64
+	if wall > 0 {
+		busy = float64(cpu) / float64(wall)
+	}
+	fmt.Fprintf(opt.stdout, "%.3fs of CPU time over %.3fs: %.1f CPUs busy on average, %.2f%% of %d",
+		cpu.Seconds(), wall.Seconds(), busy, 100*busy/float64(cpus), cpus)
+	if !measured {
+		fmt.Fprint(opt.stdout, " (estimated from the durations, without CmdUser and CmdSys)")
+	}
+	fmt.Fprintln(opt.stdout)
+
+	saturated := percentOf(waiting, span)
+	switch {
+	case saturated >= utilizationSaturated && peak < cpus:
+		fmt.Fprintf(opt.stdout, "actions waited for a worker for %.2f%% of the build with no more than %d running: a larger -p would help\n", saturated, peak)
+	case saturated >= utilizationSaturated:
+		fmt.Fprintf(opt.stdout, "actions waited for a worker for %.2f%% of the build: more CPUs would help\n", saturated)
+	case peak < cpus:
+		fmt.Fprintf(opt.stdout, "parallelism never exceeded %d: %d of the %d CPUs were wasted, and -p %d would do as well\n", peak, cpus-peak, cpus, peak)
+	default:
+		fmt.Fprintln(opt.stdout, "the dependencies between the actions limited the build more than the CPUs: see chains for the longest of them")
+	}
+	return nil
+}
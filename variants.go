@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// testVariant is one of the packages folded together by --merge-test-variants.
+type testVariant struct {
+	Package  string // As named by the go command, such as "x [x.test]" or "x_test".
+	Duration time.Duration
+	Cached   bool
+}
+
+// mergeTestVariants folds the actions of the variants of each package built
+// for its tests, such as "x [x.test]", "x.test" and "x_test", into a single
+// action of the same mode for the package x, recording the actions folded in
+// Variants. The merged action takes the sum of their durations and spans
+// their times, and the IDs are renumbered in the order of the first action of
+// each group.
+func (s *store) mergeTestVariants() {
+	type key struct {
+		mode, pkg string
+		alone     int // ID of an action kept alone, or -1.
+	}
+	groups := make(map[key][]int)
+	var order []key
+	for i := range s.actions {
+		act := &s.actions[i]
+		k := key{act.Mode, testPackage(act.Package), -1}
+		if act.Package == "" {
+			// Actions without a package, such as the barriers, stay alone.
+			k.alone = i
+		}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], i)
+	}
+	id := make([]int, len(s.actions))
+	for n, k := range order {
+		for _, i := range groups[k] {
+			id[i] = n
+		}
+	}
+
+	merged := make([]action, len(order))
+	for n, k := range order {
+		members := groups[k]
+		act := s.actions[members[0]]
+		if len(members) > 1 || act.Package != k.pkg {
+			act.Package = k.pkg
+			for _, i := range members {
+				m := &s.actions[i]
+				act.Variants = append(act.Variants, testVariant{Package: m.Package, Duration: m.Duration, Cached: m.Cached})
+			}
+			for _, i := range members[1:] {
+				act.fold(&s.actions[i])
+			}
+		}
+		act.ID = n
+		var deps []int
+		for _, i := range members {
+			for _, dep := range s.actions[i].Deps {
+				if d := id[dep]; d != n && !slices.Contains(deps, d) {
+					deps = append(deps, d)
+				}
+			}
+		}
+		act.Deps = deps
+		act.Percent = s.percent(act.Duration)
+		merged[n] = act
+	}
+	s.actions = merged
+	s.index()
+}
+
+// fold adds the work of the action o, of the same mode and package, to a.
+func (a *action) fold(o *action) {
+	a.Duration += o.Duration
+	a.Cached = a.Cached && o.Cached
+	a.CriticalPath = a.CriticalPath || o.CriticalPath
+	if o.Slack < a.Slack {
+		a.Slack = o.Slack
+	}
+	if o.Wait > a.Wait {
+		a.Wait = o.Wait
+	}
+	if a.TimeReady.IsZero() || (!o.TimeReady.IsZero() && o.TimeReady.Before(a.TimeReady)) {
+		a.TimeReady = o.TimeReady
+	}
+	if a.TimeStart.IsZero() || (!o.TimeStart.IsZero() && o.TimeStart.Before(a.TimeStart)) {
+		a.TimeStart, a.StartOffset = o.TimeStart, o.StartOffset
+	}
+	if o.TimeDone.After(a.TimeDone) {
+		a.TimeDone, a.DoneOffset = o.TimeDone, o.DoneOffset
+	}
+	a.CmdReal += o.CmdReal
+	a.CmdUser += o.CmdUser
+	a.CmdSys += o.CmdSys
+	a.NeedBuild = a.NeedBuild || o.NeedBuild
+	a.Cgo = a.Cgo || o.Cgo
+	a.Commands = append(slices.Clip(a.Commands), o.Commands...)
+	a.SourceFiles += o.SourceFiles
+	if a.Cmd == nil {
+		a.Cmd = o.Cmd
+		a.Tool, a.Flags, a.FlagCount = o.Tool, o.Flags, o.FlagCount
+	}
+}
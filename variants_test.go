@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// variantsFile is a build of the package x and its tests, which import y.
+var variantsFile = filepath.Join("testdata", "variants.json")
+
+func TestMergeTestVariantsWhy(t *testing.T) {
+	var chain struct {
+		Packages []string
+		IDs      []int
+	}
+	if err := json.Unmarshal(runOutput(t, variantsFile, ".json", "why", "y", "--merge-test-variants"), &chain); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x", "y"}; !reflect.DeepEqual(chain.Packages, want) {
+		t.Errorf("why y gave the chain %q, want %q", chain.Packages, want)
+	}
+	if want := []int{1, 0}; !reflect.DeepEqual(chain.IDs, want) {
+		t.Errorf("why y gave the chain of IDs %v, want %v", chain.IDs, want)
+	}
+}
+
+func TestMergeTestVariantsSplit(t *testing.T) {
+	dependents := make(map[string]int)
+	sc := bufio.NewScanner(bytes.NewReader(runOutput(t, variantsFile, ".json", "split", "--min-duration", "0", "--merge-test-variants")))
+	for sc.Scan() {
+		var row struct {
+			Package    string
+			Dependents int
+		}
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		dependents[row.Package] = row.Dependents
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// y is imported by x, and linked into x.test, once the variants of x are
+	// merged.
+	if want := map[string]int{"x": 1, "y": 2}; !reflect.DeepEqual(dependents, want) {
+		t.Errorf("split gave the dependents %v, want %v", dependents, want)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// logf writes a diagnostic message to stderr if -v was given at least v
+// times: once for what was read and how long each step took, twice for the
+// decisions made about each action.
+func (opt *options) logf(v int, format string, args ...any) {
+	if opt.verbosity >= v {
+		fmt.Fprintf(opt.stderr, "actiongraph: "+format+"\n", args...)
+	}
+}
+
+// timed logs at -v how long step took since start, returning the time to
+// start the next step from.
+func (opt *options) timed(step string, start time.Time) time.Time {
+	now := time.Now()
+	opt.logf(1, "%s took %s", step, now.Sub(start).Round(time.Microsecond))
+	return now
+}
+
+// addFilter adds a filter of the actions considered by commands, logging at
+// -vv how many actions it keeps by itself.
+func (opt *options) addFilter(name string, f func(*action) bool) {
+	opt.filters = append(opt.filters, f)
+	if opt.verbosity < 2 {
+		return
+	}
+	var kept int
+	for i := range opt.store.actions {
+		if f(&opt.store.actions[i]) {
+			kept++
+		}
+	}
+	opt.logf(2, "%s keeps %d of %d actions", name, kept, len(opt.store.actions))
+}
+
+// logStore logs at -v the number of actions read and, at -vv, how many of
+// each mode there were and how those of the standard library were
+// recognised.
+func logStore(opt *options) {
+	if opt.verbosity < 1 {
+		return
+	}
+	var cached, untimed, listed, rooted, flagged int
+	modes := make(map[string]int)
+	for i := range opt.store.actions {
+		act := &opt.store.actions[i]
+		modes[act.Mode]++
+		if act.Cached {
+			cached++
+		}
+		if act.Untimed {
+			untimed++
+		}
+		if !act.Std {
+			continue
+		}
+		pkg, _, _ := strings.Cut(act.Package, " ")
+		switch {
+		case stdPackages[pkg]:
+			listed++
+		case isStdlib(pkg):
+			rooted++
+		default:
+			flagged++
+		}
+	}
+	opt.logf(1, "read %d actions taking %.3fs over %.3fs: %d cached, %d untimed", len(opt.store.actions), opt.store.total.Seconds(), opt.store.wall().Seconds(), cached, untimed)
+
+	names := make([]string, 0, len(modes))
+	for mode := range modes {
+		names = append(names, mode)
+	}
+	sort.Strings(names)
+	for _, mode := range names {
+		opt.logf(2, "mode %q: %d actions", mode, modes[mode])
+	}
+	opt.logf(2, "standard library: %d actions of packages in go list std, %d sharing its top-level directories, %d compiled with -std", listed, rooted, flagged)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addWarmCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "warm [-f compile.json] [-n limit] [--parallelism N]",
+		Short:   "Suggest the dependencies to build into a warm cache, and what they'd save",
+		Long: `List the modules outside the main module whose packages would be worth
+building into a cache ahead of time, such as in a Docker layer built from
+go.mod and go.sum alone, with the time their absence would save the build.
+
+Modules outside the main module only change with go.mod. They sit beneath the
+main module's packages in the graph, so their compiles can be cached long
+before the code being built has changed. The standard library is left out,
+as it changes with the Go toolchain rather than go.mod. The build is simulated without the compiles of each
+of the --limit modules whose compiles took longest, scheduling the rest of
+the actions on --parallelism workers in the order the go command started
+them, and the modules are listed by the wall time saved. A module whose
+absence happens to schedule the rest worse is given a saving of zero. Their
+Height is the most imports beneath any of their packages: the lower, the
+sooner the rest of the build could start.
+
+The packages to build are given by --tpl, to be built by the layer:
+
+    actiongraph warm -f compile.json --tpl '{{ .Packages | join "\n" }}' > warm.txt
+    # In the Dockerfile, after copying go.mod, go.sum and warm.txt:
+    RUN go build $(cat warm.txt)
+
+The main module is given by --module, or else found by go list in the current
+directory, which must then be that of the build.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+			parallelism, err := flags.GetInt("parallelism")
+			if err != nil {
+				return err
+			}
+			if parallelism < 0 {
+				return errors.New("--parallelism must not be negative")
+			}
+			main, err := opt.mainModules(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("finding the main module (set --module): %w", err)
+			}
+			if len(opt.modules) == 0 && !buildsModules(opt.store, main) {
+				return fmt.Errorf("none of the packages built are in the main module %s found by go list (set --module)", strings.Join(main, ", "))
+			}
+
+			out, err := newRowWriter(cmd, opt, warmFormats)
+			if err != nil {
+				return err
+			}
+			return warm(opt, main, parallelism, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 20, "number of the slowest modules to simulate and show")
+	flags.Int("parallelism", 0, "number of actions the build could run at once (default the most seen running)")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var warmFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Saving", tpl: `{{ .Saving | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Duration", tpl: `{{ .Duration | seconds }}`},
+		{name: "Packages", tpl: `{{ len .Packages }}`},
+		{name: "Height", tpl: `{{ .Height }}`},
+		{name: "Module", tpl: `{{ .Module }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Saving", tpl: `{{ .Saving | colordur }}`},
+		{name: "Module", tpl: `{{ .Module }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Saving", tpl: `{{ .Saving | colordur }}`},
+		{name: "Percent", tpl: `{{ .Percent | percent }}`},
+		{name: "Duration", tpl: `{{ .Duration | seconds }}`},
+		{name: "Packages", tpl: `{{ len .Packages }}`},
+		{name: "Height", tpl: `{{ .Height }}`},
+		{name: "Module", tpl: `{{ .Module }}`, left: true},
+		{name: "Packages", tpl: `{{ .Packages | join " " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "module", tpl: `{{ .Module }}`},
+		{name: "saving", tpl: `{{ .Saving.Seconds | printf "%.3f" }}`},
+		{name: "percent", tpl: `{{ .Percent | printf "%.2f" }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "height", tpl: `{{ .Height }}`},
+		{name: "packages", tpl: `{{ .Packages | join " " }}`},
+	},
+}
+
+// buildsModules reports whether any of the packages built are in the modules.
+func buildsModules(s *store, mods []string) bool {
+	for i := range s.actions {
+		if act := &s.actions[i]; act.Mode == "build" && inModules(act.importPath(), mods) {
+			return true
+		}
+	}
+	return false
+}
+
+// warmModule is a module whose compiles could be cached ahead of the build.
+type warmModule struct {
+	Module   string
+	Duration time.Duration // Total of the compiles of its packages.
+	Saving   time.Duration // Simulated wall time saved without the compiles.
+	Percent  float64       // Saving as a percentage of the simulated wall time.
+	Height   int           // Most imports beneath any of its packages.
+	Packages []string      // Sorted.
+	IDs      []int
+}
+
+// warm lists the modules outside of main whose compiles would save the most
+// wall time if cached, followed by the saving of caching all of them when
+// written as text.
+func warm(opt *options, main []string, parallelism, limit int, out *rowWriter) error {
+	if parallelism == 0 {
+		var err error
+		if parallelism, err = peakParallelism(opt); err != nil {
+			return err
+		}
+	}
+
+	mods := loadModules(opt.store.actions, main)
+	byModule := make(map[string]*warmModule)
+	for _, act := range opt.actions() {
+		if act.Mode != "build" || act.Package == "" || act.Std || inModules(act.importPath(), main) {
+			continue
+		}
+		name := mods.module(act.importPath())
+		m := byModule[name]
+		if m == nil {
+			m = &warmModule{Module: name}
+			byModule[name] = m
+		}
+		m.Duration += act.Duration
+		m.Packages = append(m.Packages, act.Package)
+		m.IDs = append(m.IDs, act.ID)
+	}
+	candidates := make([]*warmModule, 0, len(byModule))
+	for _, m := range byModule {
+		candidates = append(candidates, m)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Duration != candidates[j].Duration {
+			return candidates[i].Duration > candidates[j].Duration
+		}
+		return candidates[i].Module < candidates[j].Module
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	g := newImportGraph(opt.store)
+	heights := make(map[int]int)
+	var height func(n int) int
+	height = func(n int) int {
+		if h, ok := heights[n]; ok {
+			return h
+		}
+		heights[n] = 0 // Guard against cycles.
+		var h int
+		for _, m := range g.imports[n] {
+			if hm := height(m) + 1; hm > h {
+				h = hm
+			}
+		}
+		heights[n] = h
+		return h
+	}
+
+	wall := simulateBuild(opt.store, parallelism, nil)
+	all := make(map[int]bool)
+	for _, m := range candidates {
+		cached := make(map[int]bool, len(m.IDs))
+		for _, id := range m.IDs {
+			cached[id] = true
+			all[id] = true
+			if h := height(id); h > m.Height {
+				m.Height = h
+			}
+		}
+		if saving := wall - simulateBuild(opt.store, parallelism, withoutCached(opt.store, cached)); saving > 0 {
+			m.Saving = saving
+		}
+		m.Percent = percentOf(m.Saving, wall)
+		sort.Strings(m.Packages)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Saving > candidates[j].Saving
+	})
+	for _, m := range candidates {
+		if err := out.row(m); err != nil {
+			return err
+		}
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+
+	saving := wall - simulateBuild(opt.store, parallelism, withoutCached(opt.store, all))
+	fmt.Fprintf(opt.stdout, "warming all %d modules would save %.3fs of the %.3fs simulated at a parallelism of %d (%.2f%%); the build took %.3fs\n",
+		len(candidates), saving.Seconds(), wall.Seconds(), parallelism, percentOf(saving, wall), opt.store.wall().Seconds())
+	return nil
+}
+
+// peakParallelism returns the most actions seen running at once.
+func peakParallelism(opt *options) (int, error) {
+	acts, err := timedActions(opt)
+	if err != nil {
+		return 0, err
+	}
+	_, peak := concurrency(acts, opt.store.start())
+	return peak, nil
+}
+
+// withoutCached returns the durations of the actions were the cached ones to
+// take no time, for simulateBuild.
+func withoutCached(s *store, cached map[int]bool) func(id int) time.Duration {
+	return func(id int) time.Duration {
+		if cached[id] {
+			return 0
+		}
+		return s.actions[id].Duration
+	}
+}
+
+// simulateBuild returns the wall time of the build were each action to take
+// the time given by duration, or as long as it did when duration is nil. The
+// actions are run by parallelism workers, which start the actions whose
+// dependencies have finished in the order the go command started them.
+func simulateBuild(s *store, parallelism int, duration func(id int) time.Duration) time.Duration {
+	order := make([]int, len(s.actions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return s.actions[order[i]].StartOffset < s.actions[order[j]].StartOffset
+	})
+	rank := make([]int64, len(s.actions))
+	for r, id := range order {
+		rank[id] = int64(r)
+	}
+
+	waiting := make([]int, len(s.actions))
+	var ready, running simQueue
+	for i := range s.actions {
+		waiting[i] = len(s.actions[i].Deps)
+		if waiting[i] == 0 {
+			ready = append(ready, simItem{i, rank[i]})
+		}
+	}
+	heap.Init(&ready)
+
+	var now time.Duration
+	for {
+		for running.Len() < parallelism && ready.Len() > 0 {
+			id := heap.Pop(&ready).(simItem).id
+			d := s.actions[id].Duration
+			if duration != nil {
+				d = duration(id)
+			}
+			heap.Push(&running, simItem{id, int64(now + d)})
+		}
+		if running.Len() == 0 {
+			return now
+		}
+		done := heap.Pop(&running).(simItem)
+		now = time.Duration(done.key)
+		for _, dep := range s.dependents.of(done.id) {
+			if waiting[dep]--; waiting[dep] == 0 {
+				heap.Push(&ready, simItem{dep, rank[dep]})
+			}
+		}
+	}
+}
+
+// simItem is an action queued by simulateBuild, by its start order while it
+// waits or its finishing time while it runs.
+type simItem struct {
+	id  int
+	key int64
+}
+
+// simQueue orders the items by key, lowest first.
+type simQueue []simItem
+
+func (q simQueue) Len() int           { return len(q) }
+func (q simQueue) Less(i, j int) bool { return q[i].key < q[j].key }
+func (q simQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x any)        { *q = append(*q, x.(simItem)) }
+
+func (q *simQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
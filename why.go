@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func addWhyCommand(prog *cobra.Command) {
+	cmd := cobra.Command{
+		GroupID: "actiongraph",
+		Use:     "why [-f compile.json] [--shortest | --all [-n limit]] PKG",
+		Short:   "Explain why a package was built by its chains of imports",
+		Long: `List the chains of imports through which the package was built, from the
+packages which nothing imports, such as the main packages built.
+
+With --shortest, the default, a single chain with the fewest imports is
+listed. With --all, every distinct chain is listed, up to the --limit, to see
+how many would have to be cut to stop building the package. The number of
+chains is given beneath the table, however many are listed.
+
+To draw the chains instead, see graph --why.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completePackages(false),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opt, err := loadOptions(cmd)
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			all, err := flags.GetBool("all")
+			if err != nil {
+				return err
+			}
+			shortest, err := flags.GetBool("shortest")
+			if err != nil {
+				return err
+			}
+			if all && shortest {
+				return errors.New("--shortest and --all are mutually exclusive")
+			}
+			limit, err := flags.GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			out, err := newRowWriter(cmd, opt, importChainFormats)
+			if err != nil {
+				return err
+			}
+			return why(opt, args[0], all, limit, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.Bool("shortest", false, "list a single chain with the fewest imports (the default)")
+	flags.Bool("all", false, "list every distinct chain of imports")
+	flags.IntP("limit", "n", 100, "with --all, the number of chains to list")
+	addFormatFlags(&cmd)
+	prog.AddCommand(&cmd)
+}
+
+var importChainFormats = formatPreset{
+	table: []formatColumn{
+		{name: "Hops", tpl: `{{ .Hops }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "Chain", tpl: `{{ .Packages | join " -> " }}`, left: true},
+	},
+	short: []formatColumn{
+		{name: "Chain", tpl: `{{ .Packages | join " -> " }}`, left: true},
+	},
+	wide: []formatColumn{
+		{name: "Hops", tpl: `{{ .Hops }}`},
+		{name: "Duration", tpl: `{{ .Duration | colordur }}`},
+		{name: "IDs", tpl: `{{ .IDs }}`, left: true},
+		{name: "Chain", tpl: `{{ .Packages | join " -> " }}`, left: true},
+	},
+	columns: []formatColumn{
+		{name: "hops", tpl: `{{ .Hops }}`},
+		{name: "duration", tpl: `{{ .Duration.Seconds | printf "%.3f" }}`},
+		{name: "chain", tpl: `{{ .Packages | join " " }}`},
+	},
+}
+
+// importChain is a chain of compiles, each importing the next.
+type importChain struct {
+	Hops     int           // Number of imports in the chain.
+	Duration time.Duration // Total of the compiles in the chain.
+	Packages []string
+	IDs      []int
+}
+
+// why lists the shortest chain of imports from the roots of the build to the
+// package, or all of them up to the limit, followed by the number of chains
+// when written as text.
+func why(opt *options, pkg string, all bool, limit int, out *rowWriter) error {
+	g := newImportGraph(opt.store)
+	to, ok := g.find(pkg)
+	if !ok {
+		return fmt.Errorf("could not find package %q", pkg)
+	}
+	from, dest := g.roots(), map[int]bool{to: true}
+
+	var listed int
+	var err error
+	emit := func(path []int) bool {
+		if err = out.row(g.chain(path)); err != nil {
+			return false
+		}
+		listed++
+		return !all || limit <= 0 || listed < limit
+	}
+	if all {
+		g.walkPaths(from, dest, nil, emit)
+	} else if path := g.shortestPath(from, to); path != nil {
+		emit(path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.flush(); err != nil || !out.text() {
+		return err
+	}
+	fmt.Fprintf(opt.stdout, "%s import chains lead to %s\n", g.countPaths(from, dest, nil), pkg)
+	return nil
+}
+
+// importGraph is the graph of the compiles of the packages, by the compiles
+// of their imports.
+type importGraph struct {
+	store   *store
+	acts    []*action
+	imports map[int][]int // IDs of the compiles of each compile's imports.
+}
+
+func newImportGraph(s *store) *importGraph {
+	g := importGraph{store: s, imports: make(map[int][]int)}
+	for _, act := range s.view() {
+		if act.Mode != "build" {
+			continue
+		}
+		g.acts = append(g.acts, act)
+		for _, dep := range act.Deps {
+			if s.actions[dep].Mode == "build" {
+				g.imports[act.ID] = append(g.imports[act.ID], dep)
+			}
+		}
+	}
+	return &g
+}
+
+// find returns the ID of the compile of the package.
+func (g *importGraph) find(pkg string) (int, bool) {
+	for _, act := range g.acts {
+		if act.Package == pkg {
+			return act.ID, true
+		}
+	}
+	return 0, false
+}
+
+// roots returns the IDs of the compiles which no other compile imports.
+func (g *importGraph) roots() []int {
+	imported := make(map[int]bool)
+	for _, deps := range g.imports {
+		for _, dep := range deps {
+			imported[dep] = true
+		}
+	}
+	var roots []int
+	for _, act := range g.acts {
+		if !imported[act.ID] {
+			roots = append(roots, act.ID)
+		}
+	}
+	return roots
+}
+
+// reaching returns the set of compiles from which any of to can be reached,
+// including those of to themselves.
+func (g *importGraph) reaching(to map[int]bool) map[int]bool {
+	reach := make(map[int]bool, len(to))
+	var queue []int
+	for n := range to {
+		reach[n] = true
+		queue = append(queue, n)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, m := range g.store.dependents.of(n) {
+			if !reach[m] && g.store.actions[m].Mode == "build" {
+				reach[m] = true
+				queue = append(queue, m)
+			}
+		}
+	}
+	return reach
+}
+
+// shortestPath returns a path with the fewest imports from any of from to to,
+// or nil if there isn't one.
+func (g *importGraph) shortestPath(from []int, to int) []int {
+	via := make(map[int]int)
+	queue := make([]int, 0, len(from))
+	for _, n := range from {
+		via[n] = -1
+		queue = append(queue, n)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n == to {
+			var path []int
+			for ; n >= 0; n = via[n] {
+				path = append([]int{n}, path...)
+			}
+			return path
+		}
+		for _, m := range g.imports[n] {
+			if _, seen := via[m]; !seen {
+				via[m] = n
+				queue = append(queue, m)
+			}
+		}
+	}
+	return nil
+}
+
+// walkPaths calls visit with each distinct path from any of from to any of
+// to, depth first, until it returns false. The paths end at the first of to
+// which they reach, and don't pass through any of avoid. The path is reused
+// between calls.
+func (g *importGraph) walkPaths(from []int, to, avoid map[int]bool, visit func(path []int) bool) {
+	reach := g.reaching(to)
+	var path []int
+	var walk func(n int) bool
+	walk = func(n int) bool {
+		path = append(path, n)
+		defer func() { path = path[:len(path)-1] }()
+		if to[n] {
+			return visit(path)
+		}
+		for _, m := range g.imports[n] {
+			if reach[m] && !avoid[m] && !walk(m) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, n := range from {
+		if reach[n] && !walk(n) {
+			return
+		}
+	}
+}
+
+// countPaths returns the number of the distinct paths walked by walkPaths,
+// which may be far too many to walk.
+func (g *importGraph) countPaths(from []int, to, avoid map[int]bool) *big.Int {
+	counts := make(map[int]*big.Int)
+	for n := range to {
+		counts[n] = big.NewInt(1)
+	}
+	var count func(n int) *big.Int
+	count = func(n int) *big.Int {
+		if c, ok := counts[n]; ok {
+			return c
+		}
+		c := new(big.Int)
+		counts[n] = c
+		for _, m := range g.imports[n] {
+			if !avoid[m] {
+				c.Add(c, count(m))
+			}
+		}
+		return c
+	}
+	total := new(big.Int)
+	for _, n := range from {
+		total.Add(total, count(n))
+	}
+	return total
+}
+
+// chain returns the compiles of the path.
+func (g *importGraph) chain(path []int) *importChain {
+	c := &importChain{Hops: len(path) - 1, IDs: append([]int(nil), path...)}
+	for _, id := range path {
+		act := &g.store.actions[id]
+		c.Duration += act.Duration
+		c.Packages = append(c.Packages, act.Package)
+	}
+	return c
+}